@@ -7,12 +7,27 @@ import (
 	"github.com/vmware/transport-go/model"
 	"reflect"
 	"strconv"
+	"sync"
 )
 
 type Account struct {
 	client   *Client
 	id       int64
 	eventBus bus.EventBus
+	symbols  *SymbolCache
+	quotes   *QuoteCache
+
+	subscriptions *SubscriptionManager
+	risk          *RiskManager
+
+	// portfolioMu serializes creating the portfolio, loadedMu guards the field so that it can be
+	// read while a portfolio is being seeded
+	portfolioMu sync.Mutex
+	loadedMu    sync.RWMutex
+	portfolio   *Portfolio
+
+	ordersMu sync.Mutex
+	orders   *OrderTracker
 }
 
 func NewAccount(client *Client, id int64) (*Account, error) {
@@ -31,6 +46,20 @@ func NewAccount(client *Client, id int64) (*Account, error) {
 		}
 	}
 
+	symbols, err := newSymbolCache(account)
+	if err != nil {
+		return nil, err
+	}
+	account.symbols = symbols
+
+	quotes, err := newAccountQuoteCache(account)
+	if err != nil {
+		return nil, err
+	}
+	account.quotes = quotes
+	account.subscriptions = newSubscriptionManager(account)
+	account.risk = newAccountRiskManager(account)
+
 	return account, nil
 }
 
@@ -38,10 +67,32 @@ func (account *Account) Id() int64 {
 	return account.id
 }
 
+// Close stops the portfolio, the order tracker and the caches of the account and releases the spot
+// subscriptions held by the risk checks. The client stays connected.
+func (account *Account) Close() {
+	account.risk.Close()
+	if portfolio, ok := account.loadedPortfolio(); ok {
+		portfolio.Close()
+	}
+
+	account.ordersMu.Lock()
+	if account.orders != nil {
+		account.orders.Close()
+		account.orders = nil
+	}
+	account.ordersMu.Unlock()
+
+	account.quotes.Close()
+	account.symbols.Close()
+}
+
 func (account *Account) NewOrder(req *openapi.ProtoOANewOrderReq) (*openapi.ProtoOAExecutionEvent, error) {
 	if req.CtidTraderAccountId != nil {
 		return nil, errors.New("account id must be empty")
 	}
+	if err := account.risk.CheckNewOrder(req); err != nil {
+		return nil, err
+	}
 	req.CtidTraderAccountId = &account.id
 
 	reqType := openapi.ProtoOAPayloadType_PROTO_OA_NEW_ORDER_REQ
@@ -88,6 +139,9 @@ func (account *Account) AmendOrder(req *openapi.ProtoOAAmendOrderReq) (*openapi.
 	if req.CtidTraderAccountId != nil {
 		return nil, errors.New("account id must be empty")
 	}
+	if err := account.risk.CheckAmendOrder(req); err != nil {
+		return nil, err
+	}
 
 	reqType := openapi.ProtoOAPayloadType_PROTO_OA_AMEND_ORDER_REQ
 
@@ -752,8 +806,8 @@ func (account *Account) createEventListener(payloadType openapi.ProtoOAPayloadTy
 					for _, id := range ids {
 						if id == account.id {
 							accountId = &id
+							break
 						}
-						break
 					}
 				}
 			}
@@ -762,8 +816,8 @@ func (account *Account) createEventListener(payloadType openapi.ProtoOAPayloadTy
 				return
 			}
 
-			if accountId == &account.id {
-				err := account.eventBus.SendBroadcastMessage(strconv.Itoa(int(payloadType)), msg)
+			if *accountId == account.id {
+				err := account.eventBus.SendBroadcastMessage(strconv.Itoa(int(payloadType)), msg.Payload)
 				if err != nil {
 					panic(err)
 				}
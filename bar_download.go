@@ -0,0 +1,135 @@
+package ctrader
+
+import (
+	"context"
+	"github.com/ty2/ctrader-go/proto/openapi"
+	"time"
+)
+
+// MaxTrendbarRange is the widest from/to window GetTrendbars accepts for period.
+func MaxTrendbarRange(period openapi.ProtoOATrendbarPeriod) time.Duration {
+	switch period {
+	case openapi.ProtoOATrendbarPeriod_M1, openapi.ProtoOATrendbarPeriod_M2, openapi.ProtoOATrendbarPeriod_M3,
+		openapi.ProtoOATrendbarPeriod_M4, openapi.ProtoOATrendbarPeriod_M5:
+		return 302400000 * time.Millisecond
+	case openapi.ProtoOATrendbarPeriod_M10, openapi.ProtoOATrendbarPeriod_M15, openapi.ProtoOATrendbarPeriod_M30,
+		openapi.ProtoOATrendbarPeriod_H1:
+		return 21168000000 * time.Millisecond
+	case openapi.ProtoOATrendbarPeriod_H4, openapi.ProtoOATrendbarPeriod_H12, openapi.ProtoOATrendbarPeriod_D1:
+		return 31622400000 * time.Millisecond
+	default:
+		return 158112000000 * time.Millisecond
+	}
+}
+
+// DownloadBars streams the candles of [from, to) oldest first. The range is split into windows
+// no wider than MaxTrendbarRange; each window is paged backwards from its end until its start
+// is reached, so responses truncated by the server count cap are completed. Requests share the
+// connection's historical rate limit. The candle channel is closed when the download ends; the
+// error channel then yields at most one error.
+func (account *Account) DownloadBars(ctx context.Context, symbolId int64, period openapi.ProtoOATrendbarPeriod, from, to time.Time) (<-chan Candle, <-chan error) {
+	candles := make(chan Candle, 256)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(errs)
+		defer close(candles)
+
+		info, err := account.symbols.Info(symbolId)
+		if err != nil {
+			errs <- err
+			return
+		}
+
+		fetch := func(fromTimestamp, toTimestamp int64) ([]Candle, error) {
+			var res *openapi.ProtoOAGetTrendbarsRes
+			err := account.historicalRequest(ctx, func() error {
+				var err error
+				res, err = account.GetTrendbars(fromTimestamp, toTimestamp, period, symbolId, 0)
+				return err
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			candles := DecodeTrendbars(res, info.Digits())
+			for i := range candles {
+				candles[i].SymbolId = symbolId
+				candles[i].Period = period
+			}
+
+			return candles, nil
+		}
+
+		if err := downloadBars(ctx, fetch, MaxTrendbarRange(period), from, to, candles); err != nil {
+			errs <- err
+		}
+	}()
+
+	return candles, errs
+}
+
+// downloadBars walks [from, to) in windows, emitting every candle once and in time order.
+func downloadBars(ctx context.Context, fetch func(fromTimestamp, toTimestamp int64) ([]Candle, error), window time.Duration, from, to time.Time, out chan<- Candle) error {
+	var last time.Time
+	emitted := false
+
+	for start := from; start.Before(to); start = start.Add(window) {
+		end := start.Add(window)
+		if end.After(to) {
+			end = to
+		}
+
+		chunk, err := fetchBarWindow(ctx, fetch, unixMillis(start), unixMillis(end))
+		if err != nil {
+			return err
+		}
+
+		for _, candle := range chunk {
+			if candle.Time.Before(from) || !candle.Time.Before(to) || (emitted && !candle.Time.After(last)) {
+				continue
+			}
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case out <- candle:
+			}
+			last, emitted = candle.Time, true
+		}
+	}
+
+	return nil
+}
+
+// fetchBarWindow pages backwards through one window until a response is empty or reaches its start.
+func fetchBarWindow(ctx context.Context, fetch func(fromTimestamp, toTimestamp int64) ([]Candle, error), fromTimestamp, toTimestamp int64) ([]Candle, error) {
+	var chunk []Candle
+	for fromTimestamp < toTimestamp {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		candles, err := fetch(fromTimestamp, toTimestamp)
+		if err != nil {
+			return nil, err
+		}
+		if len(candles) == 0 {
+			break
+		}
+
+		chunk = append(candles, chunk...)
+
+		earliest := unixMillis(candles[0].Time)
+		if earliest <= fromTimestamp || earliest > toTimestamp {
+			break
+		}
+		toTimestamp = earliest - 1
+	}
+
+	return chunk, nil
+}
+
+func unixMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
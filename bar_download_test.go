@@ -0,0 +1,48 @@
+package ctrader
+
+import (
+	"context"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+func TestDownloadBars(t *testing.T) {
+	Convey("downloadBars", t, func() {
+		from := time.Date(2021, 1, 4, 0, 0, 0, 0, time.UTC)
+		to := from.Add(10 * time.Hour)
+
+		// the fake server has one bar per minute and returns at most 100 bars back from toTimestamp, boundaries included
+		calls := 0
+		fetch := func(fromTimestamp, toTimestamp int64) ([]Candle, error) {
+			calls++
+			var candles []Candle
+			for ts := toTimestamp - toTimestamp%60000; ts >= fromTimestamp && len(candles) < 100; ts -= 60000 {
+				candles = append([]Candle{{Time: time.Unix(0, ts*int64(time.Millisecond)).UTC()}}, candles...)
+			}
+			return candles, nil
+		}
+
+		out := make(chan Candle, 1000)
+		err := downloadBars(context.Background(), fetch, 3*time.Hour, from, to, out)
+		close(out)
+		So(err, ShouldBeNil)
+
+		var candles []Candle
+		for candle := range out {
+			candles = append(candles, candle)
+		}
+
+		So(candles, ShouldHaveLength, 600)
+		for i, candle := range candles {
+			So(candle.Time, ShouldEqual, from.Add(time.Duration(i)*time.Minute))
+		}
+		So(calls, ShouldBeGreaterThan, 6)
+
+		Convey("stops when the context is cancelled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			So(downloadBars(ctx, fetch, time.Hour, from, to, make(chan Candle)), ShouldEqual, context.Canceled)
+		})
+	})
+}
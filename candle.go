@@ -0,0 +1,133 @@
+package ctrader
+
+import (
+	"github.com/shopspring/decimal"
+	"github.com/ty2/ctrader-go/proto/openapi"
+	"sort"
+	"time"
+)
+
+// Candle is a decoded trend bar. Prices are absolute and rounded to the symbol digits,
+// Volume is the number of ticks in the bar.
+type Candle struct {
+	SymbolId int64
+	Period   openapi.ProtoOATrendbarPeriod
+	Time     time.Time
+	Open     decimal.Decimal
+	High     decimal.Decimal
+	Low      decimal.Decimal
+	Close    decimal.Decimal
+	Volume   int64
+}
+
+// TrendbarPeriodDuration is the length of a server trend bar period; MN1 varies and returns 0.
+func TrendbarPeriodDuration(period openapi.ProtoOATrendbarPeriod) time.Duration {
+	switch period {
+	case openapi.ProtoOATrendbarPeriod_M1:
+		return time.Minute
+	case openapi.ProtoOATrendbarPeriod_M2:
+		return 2 * time.Minute
+	case openapi.ProtoOATrendbarPeriod_M3:
+		return 3 * time.Minute
+	case openapi.ProtoOATrendbarPeriod_M4:
+		return 4 * time.Minute
+	case openapi.ProtoOATrendbarPeriod_M5:
+		return 5 * time.Minute
+	case openapi.ProtoOATrendbarPeriod_M10:
+		return 10 * time.Minute
+	case openapi.ProtoOATrendbarPeriod_M15:
+		return 15 * time.Minute
+	case openapi.ProtoOATrendbarPeriod_M30:
+		return 30 * time.Minute
+	case openapi.ProtoOATrendbarPeriod_H1:
+		return time.Hour
+	case openapi.ProtoOATrendbarPeriod_H4:
+		return 4 * time.Hour
+	case openapi.ProtoOATrendbarPeriod_H12:
+		return 12 * time.Hour
+	case openapi.ProtoOATrendbarPeriod_D1:
+		return 24 * time.Hour
+	case openapi.ProtoOATrendbarPeriod_W1:
+		return 7 * 24 * time.Hour
+	default:
+		return 0
+	}
+}
+
+// DecodeTrendbar converts a trend bar encoded as low plus deltas into a candle.
+// digits is the symbol Digits; PriceScale keeps the full 1/100000 precision.
+func DecodeTrendbar(bar *openapi.ProtoOATrendbar, digits int32) Candle {
+	low := bar.GetLow()
+	return Candle{
+		Period: bar.GetPeriod(),
+		Time:   time.Unix(int64(bar.GetUtcTimestampInMinutes())*60, 0).UTC(),
+		Open:   rawToDecimal(low+int64(bar.GetDeltaOpen()), digits),
+		High:   rawToDecimal(low+int64(bar.GetDeltaHigh()), digits),
+		Low:    rawToDecimal(low, digits),
+		Close:  rawToDecimal(low+int64(bar.GetDeltaClose()), digits),
+		Volume: bar.GetVolume(),
+	}
+}
+
+// DecodeTrendbars decodes a historical trend bar response, oldest bar first.
+func DecodeTrendbars(res *openapi.ProtoOAGetTrendbarsRes, digits int32) []Candle {
+	candles := make([]Candle, len(res.Trendbar))
+	for i, bar := range res.Trendbar {
+		candles[i] = DecodeTrendbar(bar, digits)
+		candles[i].SymbolId = res.GetSymbolId()
+		candles[i].Period = res.GetPeriod()
+	}
+
+	sortCandles(candles)
+	return candles
+}
+
+// DecodeSpotTrendbars decodes the live trend bars carried by a spot event. Live bars are still
+// forming; when the server omits the close delta the bar closes at the current bid.
+func DecodeSpotTrendbars(event *openapi.ProtoOASpotEvent, digits int32) []Candle {
+	candles := make([]Candle, len(event.Trendbar))
+	for i, bar := range event.Trendbar {
+		candles[i] = DecodeTrendbar(bar, digits)
+		candles[i].SymbolId = event.GetSymbolId()
+		if bar.DeltaClose == nil && event.Bid != nil {
+			candles[i].Close = rawToDecimal(int64(event.GetBid()), digits)
+		}
+	}
+
+	return candles
+}
+
+// GetCandles is GetTrendbars decoded with the symbol digits from the symbol cache.
+func (account *Account) GetCandles(fromTimestamp, toTimestamp int64, period openapi.ProtoOATrendbarPeriod, symbolId int64, count uint32) ([]Candle, error) {
+	info, err := account.symbols.Info(symbolId)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := account.GetTrendbars(fromTimestamp, toTimestamp, period, symbolId, count)
+	if err != nil {
+		return nil, err
+	}
+
+	candles := DecodeTrendbars(res, info.Digits())
+	for i := range candles {
+		candles[i].SymbolId = symbolId
+	}
+
+	return candles, nil
+}
+
+func rawToDecimal(raw int64, digits int32) decimal.Decimal {
+	price := decimal.New(raw, -PriceScale)
+	if digits >= 0 && digits < PriceScale {
+		price = price.Round(digits)
+	}
+
+	return price
+}
+
+func sortCandles(candles []Candle) {
+	sort.SliceStable(candles, func(i, j int) bool {
+		return candles[i].Time.Before(candles[j].Time)
+	})
+}
@@ -0,0 +1,491 @@
+package ctrader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/shopspring/decimal"
+	"github.com/ty2/ctrader-go/proto/openapi"
+	"github.com/vmware/transport-go/bus"
+	"github.com/vmware/transport-go/model"
+	"sync"
+	"time"
+)
+
+const (
+	candleUpdateChannel = "candle-update"
+	candleCloseChannel  = "candle-close"
+)
+
+// DefaultCandleHistory is how many closed candles a CandleBuilder keeps.
+const DefaultCandleHistory = 1000
+
+type BarKind int
+
+const (
+	TimeBarKind BarKind = iota
+	TickBarKind
+	RangeBarKind
+)
+
+// BarSpec tells a CandleBuilder when to close a bar.
+type BarSpec struct {
+	Kind   BarKind
+	Period time.Duration
+	Ticks  int64
+	Range  decimal.Decimal
+}
+
+// TimeBars closes bars on period boundaries aligned to UTC, e.g. TimeBars(3 * time.Minute).
+func TimeBars(period time.Duration) BarSpec {
+	return BarSpec{Kind: TimeBarKind, Period: period}
+}
+
+// TickBars closes a bar every n price updates. Spot events carry no traded volume, so these are
+// also the volume bars of the spot stream.
+func TickBars(n int64) BarSpec {
+	return BarSpec{Kind: TickBarKind, Ticks: n}
+}
+
+// RangeBars closes a bar when the next price would stretch its high-low range beyond size.
+func RangeBars(size decimal.Decimal) BarSpec {
+	return BarSpec{Kind: RangeBarKind, Range: size}
+}
+
+func (spec BarSpec) validate() error {
+	switch spec.Kind {
+	case TimeBarKind:
+		if spec.Period <= 0 {
+			return errors.New("time bars need a positive period")
+		}
+	case TickBarKind:
+		if spec.Ticks <= 0 {
+			return errors.New("tick bars need a positive tick count")
+		}
+	case RangeBarKind:
+		if !spec.Range.IsPositive() {
+			return errors.New("range bars need a positive range")
+		}
+	default:
+		return fmt.Errorf("unknown bar kind %d", spec.Kind)
+	}
+
+	return nil
+}
+
+// CandleBuilder aggregates bid prices into candles of any BarSpec. Volume counts price updates and
+// Period is left unset since bars need not match a server period.
+//
+// Time bars close when a price arrives for a later period or, for a quiet market, when Flush is
+// called after the period ended; periods without prices (weekends, market close) produce no bars.
+type CandleBuilder struct {
+	symbolId int64
+	spec     BarSpec
+	eventBus bus.EventBus
+
+	mu          sync.Mutex
+	current     *Candle
+	end         time.Time
+	closedUntil time.Time
+	history     []Candle
+	historySize int
+	seeding     bool
+	held        []heldPrice
+
+	spotHandler  bus.MessageHandler
+	subscription *Subscription
+	stop         chan struct{}
+	stopOnce     sync.Once
+}
+
+// heldPrice is a live price that arrived while WarmUp was seeding.
+type heldPrice struct {
+	time  time.Time
+	price decimal.Decimal
+}
+
+func NewCandleBuilder(symbolId int64, spec BarSpec) (*CandleBuilder, error) {
+	if err := spec.validate(); err != nil {
+		return nil, err
+	}
+
+	builder := &CandleBuilder{
+		symbolId:    symbolId,
+		spec:        spec,
+		eventBus:    bus.NewEventBusInstance(),
+		historySize: DefaultCandleHistory,
+		stop:        make(chan struct{}),
+	}
+
+	cm := builder.eventBus.GetChannelManager()
+	cm.CreateChannel(candleUpdateChannel)
+	cm.CreateChannel(candleCloseChannel)
+
+	return builder, nil
+}
+
+// CandleBuilder builds candles from the account spot events of symbolId. It holds a spot
+// subscription of the symbol until Close.
+func (account *Account) CandleBuilder(symbolId int64, spec BarSpec) (*CandleBuilder, error) {
+	builder, err := NewCandleBuilder(symbolId, spec)
+	if err != nil {
+		return nil, err
+	}
+
+	spotHandler, err := account.OnSpot()
+	if err != nil {
+		return nil, err
+	}
+
+	spotHandler.Handle(
+		func(msg *model.Message) {
+			v, ok := msg.Payload.(*openapi.ProtoOASpotEvent)
+			if !ok || v.GetSymbolId() != symbolId || v.Bid == nil {
+				return
+			}
+
+			t := time.Now()
+			if v.Timestamp != nil {
+				t = time.Unix(0, v.GetTimestamp()*int64(time.Millisecond))
+			}
+			builder.Update(t, decimal.New(int64(v.GetBid()), -PriceScale))
+		},
+		func(err error) {
+			logger.Warn(err.Error())
+		})
+	builder.spotHandler = spotHandler
+
+	if builder.subscription, err = account.subscriptions.Spots(symbolId); err != nil {
+		spotHandler.Close()
+		return nil, err
+	}
+
+	if spec.Kind == TimeBarKind {
+		go builder.flushLoop()
+	}
+
+	return builder, nil
+}
+
+// WarmUp seeds the builder with lookback of history so indicators have data before the first live
+// bar closes. Time bars are aggregated from the largest server period that divides the bar period;
+// tick and range bars replay the bid ticks of lookback. Live prices arriving meanwhile are held back
+// and added after the history, except those the history already covers.
+func (account *Account) WarmUp(ctx context.Context, builder *CandleBuilder, lookback time.Duration) error {
+	builder.holdPrices()
+	to := time.Now()
+	from := to.Add(-lookback)
+	defer builder.releasePrices(to)
+
+	if builder.spec.Kind != TimeBarKind {
+		ticks, errs := account.DownloadTicks(ctx, builder.symbolId, openapi.ProtoOAQuoteType_BID, from, to)
+		for tick := range ticks {
+			builder.update(tick.Time, tick.Price)
+		}
+		return <-errs
+	}
+
+	period, ok := seedPeriod(builder.spec.Period)
+	if !ok {
+		return fmt.Errorf("no server period divides %s", builder.spec.Period)
+	}
+
+	var candles []Candle
+	stream, errs := account.DownloadBars(ctx, builder.symbolId, period, from.Truncate(builder.spec.Period), to)
+	for candle := range stream {
+		candles = append(candles, candle)
+	}
+	if err := <-errs; err != nil {
+		return err
+	}
+
+	builder.Seed(AggregateCandles(candles, builder.spec.Period))
+	return nil
+}
+
+// seedPeriod is the largest server period that divides d.
+func seedPeriod(d time.Duration) (openapi.ProtoOATrendbarPeriod, bool) {
+	for period := openapi.ProtoOATrendbarPeriod_D1; period >= openapi.ProtoOATrendbarPeriod_M1; period-- {
+		if length := TrendbarPeriodDuration(period); length > 0 && d%length == 0 {
+			return period, true
+		}
+	}
+
+	return 0, false
+}
+
+// AggregateCandles merges time ordered candles into bars of period aligned to UTC.
+func AggregateCandles(candles []Candle, period time.Duration) []Candle {
+	var merged []Candle
+	for _, candle := range candles {
+		start := candle.Time.Truncate(period)
+		if n := len(merged); n > 0 && merged[n-1].Time.Equal(start) {
+			last := &merged[n-1]
+			if candle.High.GreaterThan(last.High) {
+				last.High = candle.High
+			}
+			if candle.Low.LessThan(last.Low) {
+				last.Low = candle.Low
+			}
+			last.Close = candle.Close
+			last.Volume += candle.Volume
+			continue
+		}
+
+		candle.Time = start
+		candle.Period = 0
+		merged = append(merged, candle)
+	}
+
+	return merged
+}
+
+// SetHistorySize changes how many closed candles are kept.
+func (builder *CandleBuilder) SetHistorySize(n int) {
+	builder.mu.Lock()
+	defer builder.mu.Unlock()
+	builder.historySize = n
+	builder.trimHistory()
+}
+
+func (builder *CandleBuilder) trimHistory() {
+	if len(builder.history) > builder.historySize {
+		builder.history = append([]Candle{}, builder.history[len(builder.history)-builder.historySize:]...)
+	}
+}
+
+// Seed adds time ordered candles from history in front of the bars built so far. Candles from the
+// start of the first built bar on are dropped, so history never replaces live bars. For time bars
+// of a builder without bars a last candle whose period has not ended yet becomes the forming bar.
+func (builder *CandleBuilder) Seed(candles []Candle) {
+	builder.mu.Lock()
+	defer builder.mu.Unlock()
+
+	var cutoff time.Time
+	if len(builder.history) > 0 {
+		cutoff = builder.history[0].Time
+	} else if builder.current != nil {
+		cutoff = builder.current.Time
+	}
+
+	var seeded []Candle
+	for _, candle := range candles {
+		if !cutoff.IsZero() && !candle.Time.Before(cutoff) {
+			break
+		}
+
+		candle.SymbolId = builder.symbolId
+		if builder.spec.Kind == TimeBarKind {
+			end := candle.Time.Add(builder.spec.Period)
+			if cutoff.IsZero() && end.After(time.Now()) {
+				forming := candle
+				builder.current, builder.end = &forming, end
+				continue
+			}
+			if end.After(builder.closedUntil) {
+				builder.closedUntil = end
+			}
+		}
+		seeded = append(seeded, candle)
+	}
+	builder.history = append(seeded, builder.history...)
+	builder.trimHistory()
+}
+
+// holdPrices makes Update keep prices back until releasePrices.
+func (builder *CandleBuilder) holdPrices() {
+	builder.mu.Lock()
+	defer builder.mu.Unlock()
+	builder.seeding = true
+}
+
+// releasePrices adds the held prices from seededUntil on in their order and stops holding them.
+func (builder *CandleBuilder) releasePrices(seededUntil time.Time) {
+	for {
+		builder.mu.Lock()
+		held := builder.held
+		builder.held = nil
+		if len(held) == 0 {
+			builder.seeding = false
+			builder.mu.Unlock()
+			return
+		}
+		builder.mu.Unlock()
+
+		for _, price := range held {
+			if !price.time.Before(seededUntil) {
+				builder.update(price.time, price.price)
+			}
+		}
+	}
+}
+
+// Update adds a price at time t and returns the candles it closed. While WarmUp runs, prices are
+// held back and nothing is returned.
+func (builder *CandleBuilder) Update(t time.Time, price decimal.Decimal) []Candle {
+	builder.mu.Lock()
+	if builder.seeding {
+		builder.held = append(builder.held, heldPrice{time: t, price: price})
+		builder.mu.Unlock()
+		return nil
+	}
+
+	return builder.apply(t, price)
+}
+
+func (builder *CandleBuilder) update(t time.Time, price decimal.Decimal) []Candle {
+	builder.mu.Lock()
+	return builder.apply(t, price)
+}
+
+// apply adds a price; the caller holds the lock, which is released before publishing.
+func (builder *CandleBuilder) apply(t time.Time, price decimal.Decimal) []Candle {
+	var closed []Candle
+
+	if builder.spec.Kind == TimeBarKind && t.Before(builder.closedUntil) {
+		// late price for a bar that has closed already
+		builder.mu.Unlock()
+		return nil
+	}
+
+	if builder.current != nil {
+		switch builder.spec.Kind {
+		case TimeBarKind:
+			if !t.Before(builder.end) {
+				closed = append(closed, builder.closeCurrent())
+			}
+		case RangeBarKind:
+			high, low := builder.current.High, builder.current.Low
+			if price.GreaterThan(high) {
+				high = price
+			}
+			if price.LessThan(low) {
+				low = price
+			}
+			if high.Sub(low).GreaterThan(builder.spec.Range) {
+				closed = append(closed, builder.closeCurrent())
+			}
+		}
+	}
+
+	if builder.current == nil {
+		start := t.UTC()
+		if builder.spec.Kind == TimeBarKind {
+			start = t.UTC().Truncate(builder.spec.Period)
+			builder.end = start.Add(builder.spec.Period)
+		}
+		builder.current = &Candle{SymbolId: builder.symbolId, Time: start, Open: price, High: price, Low: price, Close: price}
+	}
+
+	current := builder.current
+	if price.GreaterThan(current.High) {
+		current.High = price
+	}
+	if price.LessThan(current.Low) {
+		current.Low = price
+	}
+	current.Close = price
+	current.Volume++
+
+	update := *current
+	if builder.spec.Kind == TickBarKind && current.Volume >= builder.spec.Ticks {
+		closed = append(closed, builder.closeCurrent())
+	}
+	builder.mu.Unlock()
+
+	builder.publish(closed, &update)
+	return closed
+}
+
+// Flush closes the forming time bar if its period ended before now, so the last bar before a
+// market close is not held back until trading resumes.
+func (builder *CandleBuilder) Flush(now time.Time) []Candle {
+	builder.mu.Lock()
+	var closed []Candle
+	if builder.spec.Kind == TimeBarKind && builder.current != nil && !now.Before(builder.end) {
+		closed = append(closed, builder.closeCurrent())
+	}
+	builder.mu.Unlock()
+
+	builder.publish(closed, nil)
+	return closed
+}
+
+func (builder *CandleBuilder) closeCurrent() Candle {
+	candle := *builder.current
+	builder.current = nil
+	if builder.spec.Kind == TimeBarKind {
+		builder.closedUntil = builder.end
+	}
+	builder.history = append(builder.history, candle)
+	builder.trimHistory()
+	return candle
+}
+
+func (builder *CandleBuilder) publish(closed []Candle, update *Candle) {
+	for _, candle := range closed {
+		if err := builder.eventBus.SendBroadcastMessage(candleCloseChannel, candle); err != nil {
+			logger.Warn(err.Error())
+		}
+	}
+	if update != nil {
+		if err := builder.eventBus.SendBroadcastMessage(candleUpdateChannel, *update); err != nil {
+			logger.Warn(err.Error())
+		}
+	}
+}
+
+func (builder *CandleBuilder) flushLoop() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-builder.stop:
+			return
+		case now := <-ticker.C:
+			builder.Flush(now)
+		}
+	}
+}
+
+// Current returns the forming bar.
+func (builder *CandleBuilder) Current() (Candle, bool) {
+	builder.mu.Lock()
+	defer builder.mu.Unlock()
+	if builder.current == nil {
+		return Candle{}, false
+	}
+
+	return *builder.current, true
+}
+
+// History returns the closed candles, oldest first.
+func (builder *CandleBuilder) History() []Candle {
+	builder.mu.Lock()
+	defer builder.mu.Unlock()
+	return append([]Candle{}, builder.history...)
+}
+
+// OnBarClose delivers each closed Candle.
+func (builder *CandleBuilder) OnBarClose() (bus.MessageHandler, error) {
+	return builder.eventBus.ListenFirehose(candleCloseChannel)
+}
+
+// OnBarUpdate delivers the forming Candle after every price.
+func (builder *CandleBuilder) OnBarUpdate() (bus.MessageHandler, error) {
+	return builder.eventBus.ListenFirehose(candleUpdateChannel)
+}
+
+func (builder *CandleBuilder) Close() {
+	builder.stopOnce.Do(func() {
+		close(builder.stop)
+		if builder.spotHandler != nil {
+			builder.spotHandler.Close()
+		}
+		if builder.subscription != nil {
+			if err := builder.subscription.Close(); err != nil {
+				logger.Warn(err.Error())
+			}
+		}
+	})
+}
@@ -0,0 +1,139 @@
+package ctrader
+
+import (
+	"github.com/shopspring/decimal"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+func TestCandleBuilder(t *testing.T) {
+	at := func(minute, second int) time.Time {
+		return time.Date(2022, 1, 3, 10, minute, second, 0, time.UTC)
+	}
+	price := func(s string) decimal.Decimal {
+		return decimal.RequireFromString(s)
+	}
+
+	Convey("time bars", t, func() {
+		builder, err := NewCandleBuilder(1, TimeBars(3*time.Minute))
+		So(err, ShouldBeNil)
+
+		So(builder.Update(at(0, 10), price("1.1")), ShouldBeEmpty)
+		So(builder.Update(at(1, 0), price("1.3")), ShouldBeEmpty)
+		So(builder.Update(at(2, 59), price("1.2")), ShouldBeEmpty)
+
+		closed := builder.Update(at(3, 0), price("1.25"))
+		So(closed, ShouldHaveLength, 1)
+		So(closed[0].Time, ShouldEqual, at(0, 0))
+		So(closed[0].Open.String(), ShouldEqual, "1.1")
+		So(closed[0].High.String(), ShouldEqual, "1.3")
+		So(closed[0].Low.String(), ShouldEqual, "1.1")
+		So(closed[0].Close.String(), ShouldEqual, "1.2")
+		So(closed[0].Volume, ShouldEqual, 3)
+
+		Convey("flush closes a quiet bar and drops late prices", func() {
+			So(builder.Flush(at(5, 59)), ShouldBeEmpty)
+			So(builder.Flush(at(6, 0)), ShouldHaveLength, 1)
+			So(builder.Update(at(5, 30), price("1")), ShouldBeEmpty)
+			_, ok := builder.Current()
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("gaps produce no empty bars", func() {
+			closed := builder.Update(at(30, 0), price("1.4"))
+			So(closed, ShouldHaveLength, 1)
+			So(closed[0].Time, ShouldEqual, at(3, 0))
+			current, ok := builder.Current()
+			So(ok, ShouldBeTrue)
+			So(current.Time, ShouldEqual, at(30, 0))
+			So(builder.History(), ShouldHaveLength, 2)
+		})
+	})
+
+	Convey("tick bars", t, func() {
+		builder, err := NewCandleBuilder(1, TickBars(2))
+		So(err, ShouldBeNil)
+		So(builder.Update(at(0, 1), price("1")), ShouldBeEmpty)
+		closed := builder.Update(at(0, 2), price("2"))
+		So(closed, ShouldHaveLength, 1)
+		So(closed[0].Close.String(), ShouldEqual, "2")
+		So(closed[0].Volume, ShouldEqual, 2)
+	})
+
+	Convey("range bars", t, func() {
+		builder, err := NewCandleBuilder(1, RangeBars(price("0.001")))
+		So(err, ShouldBeNil)
+		So(builder.Update(at(0, 1), price("1.1000")), ShouldBeEmpty)
+		So(builder.Update(at(0, 2), price("1.1010")), ShouldBeEmpty)
+		closed := builder.Update(at(0, 3), price("1.1011"))
+		So(closed, ShouldHaveLength, 1)
+		So(closed[0].High.String(), ShouldEqual, "1.101")
+		current, _ := builder.Current()
+		So(current.Open.String(), ShouldEqual, "1.1011")
+	})
+
+	Convey("seeding from aggregated history", t, func() {
+		var minutes []Candle
+		for i := 0; i < 7; i++ {
+			p := decimal.NewFromInt(int64(i))
+			minutes = append(minutes, Candle{Time: at(i, 0), Open: p, High: p, Low: p, Close: p, Volume: 1})
+		}
+
+		bars := AggregateCandles(minutes, 3*time.Minute)
+		So(bars, ShouldHaveLength, 3)
+		So(bars[1].Time, ShouldEqual, at(3, 0))
+		So(bars[1].Open.String(), ShouldEqual, "3")
+		So(bars[1].High.String(), ShouldEqual, "5")
+		So(bars[1].Close.String(), ShouldEqual, "5")
+		So(bars[1].Volume, ShouldEqual, 3)
+
+		builder, err := NewCandleBuilder(1, TimeBars(3*time.Minute))
+		So(err, ShouldBeNil)
+		builder.Seed(bars)
+		So(builder.History(), ShouldHaveLength, 3)
+		So(builder.Update(at(7, 0), price("1")), ShouldBeEmpty)
+
+		_, err = NewCandleBuilder(1, TickBars(0))
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("warming up", t, func() {
+		builder, err := NewCandleBuilder(1, TimeBars(3*time.Minute))
+		So(err, ShouldBeNil)
+		seeded := []Candle{
+			{Time: at(0, 0), Open: price("1"), High: price("1"), Low: price("1"), Close: price("1"), Volume: 1},
+			{Time: at(3, 0), Open: price("2"), High: price("2"), Low: price("2"), Close: price("2"), Volume: 1},
+		}
+
+		Convey("holds live prices back until the history is seeded", func() {
+			builder.holdPrices()
+			So(builder.Update(at(5, 0), price("1.5")), ShouldBeEmpty)
+			So(builder.Update(at(6, 10), price("3")), ShouldBeEmpty)
+			_, ok := builder.Current()
+			So(ok, ShouldBeFalse)
+
+			builder.Seed(seeded)
+			builder.releasePrices(at(6, 0))
+			So(builder.History(), ShouldHaveLength, 2)
+			current, ok := builder.Current()
+			So(ok, ShouldBeTrue)
+			So(current.Time, ShouldEqual, at(6, 0))
+			So(current.Volume, ShouldEqual, 1)
+
+			So(builder.Update(at(6, 20), price("3.1")), ShouldBeEmpty)
+			current, _ = builder.Current()
+			So(current.Close.String(), ShouldEqual, "3.1")
+		})
+
+		Convey("never replaces bars built from live prices", func() {
+			builder.Update(at(3, 30), price("2.5"))
+			builder.Seed(seeded)
+			history := builder.History()
+			So(history, ShouldHaveLength, 1)
+			So(history[0].Time, ShouldEqual, at(0, 0))
+			current, _ := builder.Current()
+			So(current.Open.String(), ShouldEqual, "2.5")
+		})
+	})
+}
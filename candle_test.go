@@ -0,0 +1,66 @@
+package ctrader
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ty2/ctrader-go/proto/openapi"
+	"testing"
+	"time"
+)
+
+func testTrendbar(minutes uint32, low int64, open, high, close uint64, volume int64) *openapi.ProtoOATrendbar {
+	return &openapi.ProtoOATrendbar{
+		Volume:                &volume,
+		Period:                openapi.ProtoOATrendbarPeriod_M1.Enum(),
+		Low:                   &low,
+		DeltaOpen:             &open,
+		DeltaHigh:             &high,
+		DeltaClose:            &close,
+		UtcTimestampInMinutes: &minutes,
+	}
+}
+
+func TestCandle(t *testing.T) {
+	Convey("DecodeTrendbar", t, func() {
+		candle := DecodeTrendbar(testTrendbar(27000000, 112340, 5, 20, 12, 42), 5)
+		So(candle.Time, ShouldEqual, time.Date(2021, 5, 3, 0, 0, 0, 0, time.UTC))
+		So(candle.Open.String(), ShouldEqual, "1.12345")
+		So(candle.High.String(), ShouldEqual, "1.1236")
+		So(candle.Low.String(), ShouldEqual, "1.1234")
+		So(candle.Close.String(), ShouldEqual, "1.12352")
+		So(candle.Volume, ShouldEqual, 42)
+
+		Convey("rounds to the symbol digits", func() {
+			candle := DecodeTrendbar(testTrendbar(0, 1234567, 0, 0, 0, 1), 3)
+			So(candle.Low.String(), ShouldEqual, "12.346")
+		})
+	})
+
+	Convey("DecodeTrendbars sorts and tags candles", t, func() {
+		symbolId := int64(1)
+		period := openapi.ProtoOATrendbarPeriod_H1
+		res := &openapi.ProtoOAGetTrendbarsRes{
+			SymbolId: &symbolId,
+			Period:   &period,
+			Trendbar: []*openapi.ProtoOATrendbar{testTrendbar(120, 100000, 0, 0, 0, 1), testTrendbar(60, 100000, 0, 0, 0, 1)},
+		}
+
+		candles := DecodeTrendbars(res, 5)
+		So(candles, ShouldHaveLength, 2)
+		So(candles[0].Time.Before(candles[1].Time), ShouldBeTrue)
+		So(candles[0].SymbolId, ShouldEqual, 1)
+		So(candles[0].Period, ShouldEqual, openapi.ProtoOATrendbarPeriod_H1)
+	})
+
+	Convey("DecodeSpotTrendbars closes live bars at the bid", t, func() {
+		symbolId := int64(1)
+		bid := uint64(100050)
+		bar := testTrendbar(60, 100000, 10, 80, 0, 3)
+		bar.DeltaClose = nil
+		event := &openapi.ProtoOASpotEvent{SymbolId: &symbolId, Bid: &bid, Trendbar: []*openapi.ProtoOATrendbar{bar}}
+
+		candles := DecodeSpotTrendbars(event, 5)
+		So(candles, ShouldHaveLength, 1)
+		So(candles[0].Close.String(), ShouldEqual, "1.0005")
+		So(candles[0].SymbolId, ShouldEqual, 1)
+	})
+}
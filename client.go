@@ -25,6 +25,8 @@ type Client struct {
 	secret       string
 	accountToken string
 	eventBus     bus.EventBus
+
+	historicalLimiter *rateLimiter
 }
 
 func NewClient(conn *Conn, id string, secret string, accountToken string) *Client {
@@ -34,6 +36,8 @@ func NewClient(conn *Conn, id string, secret string, accountToken string) *Clien
 		secret:       secret,
 		accountToken: accountToken,
 		eventBus:     bus.NewEventBusInstance(),
+
+		historicalLimiter: newRateLimiter(HistoricalRequestsPerSecond, time.Second),
 	}
 
 	cm := client.eventBus.GetChannelManager()
@@ -274,6 +278,10 @@ func (client *Client) responseMessageHandler(resTypes []openapi.ProtoOAPayloadTy
 	}, nil
 }
 
+// ErrTimeout is returned by requests without a response after 10 seconds. The request may still
+// have reached the server.
+var ErrTimeout = errors.New("timeout")
+
 func (resMsgHandler *responseMessageHandler) waitMessageResponse() (interface{}, error) {
 	select {
 	case v := <-resMsgHandler.msgCh:
@@ -281,7 +289,7 @@ func (resMsgHandler *responseMessageHandler) waitMessageResponse() (interface{},
 	case err := <-resMsgHandler.errCh:
 		return nil, err
 	case <-time.After(time.Second * 10):
-		return nil, errors.New("timeout")
+		return nil, ErrTimeout
 	}
 }
 
@@ -331,7 +339,8 @@ func (client *Client) handleMessage(b []byte) error {
 	case uint32(openapi.ProtoOAPayloadType_PROTO_OA_TRADER_RES):
 		resMessage = &openapi.ProtoOATraderRes{}
 	case uint32(openapi.ProtoOAPayloadType_PROTO_OA_TRADER_UPDATE_EVENT):
-		resMessage = &openapi.ProtoOAMarginCallUpdateEvent{}
+		// decoded as ProtoOAMarginCallUpdateEvent before, which dropped the trader of the event
+		resMessage = &openapi.ProtoOATraderUpdatedEvent{}
 	case uint32(openapi.ProtoOAPayloadType_PROTO_OA_RECONCILE_RES):
 		resMessage = &openapi.ProtoOAReconcileRes{}
 	case uint32(openapi.ProtoOAPayloadType_PROTO_OA_EXECUTION_EVENT):
@@ -0,0 +1,574 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"github.com/pkg/errors"
+	ctrader "github.com/ty2/ctrader-go"
+	"github.com/ty2/ctrader-go/export"
+	"github.com/ty2/ctrader-go/proto/openapi"
+	"github.com/vmware/transport-go/model"
+	"google.golang.org/protobuf/encoding/protojson"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+func runAccounts(a *app, args []string) error {
+	flags := flag.NewFlagSet("accounts", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	client, err := a.connect()
+	if err != nil {
+		return err
+	}
+
+	res, err := client.GetAccountListByAccessToken(a.config.AccessToken)
+	if err != nil {
+		return err
+	}
+
+	t := newTable("ACCOUNT", "LOGIN", "LIVE", "LAST CLOSING DEAL", "LAST BALANCE UPDATE")
+	for _, v := range res.CtidTraderAccount {
+		id := ""
+		if v.CtidTraderAccountId != nil {
+			id = strconv.FormatUint(*v.CtidTraderAccountId, 10)
+		}
+		t.add(id, formatInt(v.TraderLogin), formatBool(v.IsLive), formatMillis(v.LastClosingDealTimestamp), formatMillis(v.LastBalanceUpdateTimestamp))
+	}
+
+	return a.printer.print(t, res)
+}
+
+func runSymbols(a *app, args []string) error {
+	flags := flag.NewFlagSet("symbols", flag.ContinueOnError)
+	archived := flags.Bool("archived", false, "list archived symbols instead")
+	filter := flags.String("filter", "", "only symbols whose name contains this text")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	account, err := a.session()
+	if err != nil {
+		return err
+	}
+
+	res, err := account.SymbolList()
+	if err != nil {
+		return err
+	}
+
+	match := func(name *string) bool {
+		return *filter == "" || (name != nil && strings.Contains(strings.ToUpper(*name), strings.ToUpper(*filter)))
+	}
+
+	if *archived {
+		t := newTable("ID", "NAME", "LAST UPDATE", "DESCRIPTION")
+		for _, v := range res.ArchivedSymbol {
+			if match(v.Name) {
+				t.add(formatInt(v.SymbolId), formatString(v.Name), formatMillis(v.UtcLastUpdateTimestamp), formatString(v.Description))
+			}
+		}
+		return a.printer.print(t, res)
+	}
+
+	t := newTable("ID", "NAME", "ENABLED", "BASE", "QUOTE", "CATEGORY", "DESCRIPTION")
+	for _, v := range res.Symbol {
+		if match(v.SymbolName) {
+			t.add(formatInt(v.SymbolId), formatString(v.SymbolName), formatBool(v.Enabled), formatInt(v.BaseAssetId), formatInt(v.QuoteAssetId), formatInt(v.SymbolCategoryId), formatString(v.Description))
+		}
+	}
+
+	return a.printer.print(t, res)
+}
+
+func runTrader(a *app, args []string) error {
+	flags := flag.NewFlagSet("trader", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	account, err := a.session()
+	if err != nil {
+		return err
+	}
+
+	res, err := account.Trader()
+	if err != nil {
+		return err
+	}
+
+	trader := res.Trader
+	leverage := ""
+	if trader.LeverageInCents != nil {
+		leverage = "1:" + formatScaled(int64(*trader.LeverageInCents), 2)
+	}
+
+	t := newTable("FIELD", "VALUE")
+	t.add("account", formatInt(trader.CtidTraderAccountId))
+	t.add("login", formatInt(trader.TraderLogin))
+	t.add("broker", formatString(trader.BrokerName))
+	t.add("type", trader.GetAccountType().String())
+	t.add("balance", formatMoney(trader.Balance, trader.MoneyDigits))
+	t.add("deposit asset", formatInt(trader.DepositAssetId))
+	t.add("leverage", leverage)
+	t.add("margin calculation", trader.GetTotalMarginCalculationType().String())
+	t.add("registered", formatMillis(trader.RegistrationTimestamp))
+
+	return a.printer.print(t, res)
+}
+
+func runPositions(a *app, args []string) error {
+	flags := flag.NewFlagSet("positions", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	account, err := a.session()
+	if err != nil {
+		return err
+	}
+
+	res, err := account.Reconcile()
+	if err != nil {
+		return err
+	}
+
+	t := newTable("ID", "SYMBOL", "SIDE", "VOLUME", "PRICE", "SL", "TP", "SWAP", "COMMISSION", "OPENED")
+	for _, v := range res.Position {
+		data := v.GetTradeData()
+		t.add(formatInt(v.PositionId), formatInt(data.SymbolId), data.GetTradeSide().String(), formatVolume(data.Volume),
+			formatFloat(v.Price), formatFloat(v.StopLoss), formatFloat(v.TakeProfit),
+			formatMoney(v.Swap, v.MoneyDigits), formatMoney(v.Commission, v.MoneyDigits), formatMillis(data.OpenTimestamp))
+	}
+
+	return a.printer.print(t, res)
+}
+
+func runOrders(a *app, args []string) error {
+	flags := flag.NewFlagSet("orders", flag.ContinueOnError)
+	from := flags.String("from", "", "list historical orders from this time")
+	to := flags.String("to", "now", "list historical orders until this time")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	account, err := a.session()
+	if err != nil {
+		return err
+	}
+
+	if *from == "" {
+		res, err := account.Reconcile()
+		if err != nil {
+			return err
+		}
+		return a.printer.print(ordersTable(res.Order), res)
+	}
+
+	fromTimestamp, toTimestamp, err := parseRange(*from, *to)
+	if err != nil {
+		return err
+	}
+
+	res, err := account.OrderList(fromTimestamp, toTimestamp)
+	if err != nil {
+		return err
+	}
+	if res.GetHasMore() {
+		fmt.Fprintln(os.Stderr, "ctrader: more orders available, narrow the time range")
+	}
+
+	return a.printer.print(ordersTable(res.Order), res)
+}
+
+func ordersTable(orders []*openapi.ProtoOAOrder) *table {
+	t := newTable("ID", "SYMBOL", "TYPE", "SIDE", "VOLUME", "LIMIT", "STOP", "SL", "TP", "STATUS", "POSITION", "CLIENT ID", "UPDATED")
+	for _, v := range orders {
+		data := v.GetTradeData()
+		t.add(formatInt(v.OrderId), formatInt(data.SymbolId), v.GetOrderType().String(), data.GetTradeSide().String(), formatVolume(data.Volume),
+			formatFloat(v.LimitPrice), formatFloat(v.StopPrice), formatFloat(v.StopLoss), formatFloat(v.TakeProfit),
+			v.GetOrderStatus().String(), formatInt(v.PositionId), formatString(v.ClientOrderId), formatMillis(v.UtcLastUpdateTimestamp))
+	}
+
+	return t
+}
+
+func runDeals(a *app, args []string) error {
+	flags := flag.NewFlagSet("deals", flag.ContinueOnError)
+	from := flags.String("from", "24h", "start time")
+	to := flags.String("to", "now", "end time")
+	maxRows := flags.Int("max", 0, "maximum number of rows")
+	out := flags.String("out", "", "write to a .csv or .parquet file instead of printing")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	fromTimestamp, toTimestamp, err := parseRange(*from, *to)
+	if err != nil {
+		return err
+	}
+
+	account, err := a.session()
+	if err != nil {
+		return err
+	}
+
+	var rows *int32
+	if *maxRows > 0 {
+		v := int32(*maxRows)
+		rows = &v
+	}
+
+	res, err := account.DealList(fromTimestamp, toTimestamp, rows)
+	if err != nil {
+		return err
+	}
+	if res.GetHasMore() {
+		fmt.Fprintln(os.Stderr, "ctrader: more deals available, narrow the time range")
+	}
+
+	if *out != "" {
+		return export.File(*out, func(w io.Writer, format export.Format) error {
+			return export.Deals(w, format, res.Deal)
+		})
+	}
+
+	t := newTable("ID", "ORDER", "POSITION", "SYMBOL", "SIDE", "VOLUME", "FILLED", "PRICE", "STATUS", "COMMISSION", "GROSS PROFIT", "EXECUTED")
+	for _, v := range res.Deal {
+		grossProfit := ""
+		if detail := v.ClosePositionDetail; detail != nil {
+			grossProfit = formatMoney(detail.GrossProfit, detail.MoneyDigits)
+		}
+		t.add(formatInt(v.DealId), formatInt(v.OrderId), formatInt(v.PositionId), formatInt(v.SymbolId), v.GetTradeSide().String(),
+			formatVolume(v.Volume), formatVolume(v.FilledVolume), formatFloat(v.ExecutionPrice), v.GetDealStatus().String(),
+			formatMoney(v.Commission, v.MoneyDigits), grossProfit, formatMillis(v.ExecutionTimestamp))
+	}
+
+	return a.printer.print(t, res)
+}
+
+func runCashFlow(a *app, args []string) error {
+	flags := flag.NewFlagSet("cashflow", flag.ContinueOnError)
+	from := flags.String("from", "168h", "start time, at most one week before -to")
+	to := flags.String("to", "now", "end time")
+	out := flags.String("out", "", "write to a .csv or .parquet file instead of printing")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	fromTimestamp, toTimestamp, err := parseRange(*from, *to)
+	if err != nil {
+		return err
+	}
+
+	account, err := a.session()
+	if err != nil {
+		return err
+	}
+
+	res, err := account.CashFlowHistoryList(fromTimestamp, toTimestamp)
+	if err != nil {
+		return err
+	}
+
+	if *out != "" {
+		return export.File(*out, func(w io.Writer, format export.Format) error {
+			return export.CashFlow(w, format, res.DepositWithdraw)
+		})
+	}
+
+	t := newTable("ID", "TYPE", "DELTA", "BALANCE", "EQUITY", "NOTE", "TIME")
+	for _, v := range res.DepositWithdraw {
+		t.add(formatInt(v.BalanceHistoryId), v.GetOperationType().String(), formatMoney(v.Delta, v.MoneyDigits),
+			formatMoney(v.Balance, v.MoneyDigits), formatMoney(v.Equity, v.MoneyDigits), formatString(v.ExternalNote), formatMillis(v.ChangeBalanceTimestamp))
+	}
+
+	return a.printer.print(t, res)
+}
+
+func runBars(a *app, args []string) error {
+	flags := flag.NewFlagSet("bars", flag.ContinueOnError)
+	symbol := flags.String("symbol", "", "symbol id or name")
+	period := flags.String("period", "M1", "trend bar period (M1, M5, H1, D1, ...)")
+	from := flags.String("from", "1h", "start time")
+	to := flags.String("to", "now", "end time")
+	count := flags.Uint("count", 0, "maximum number of bars back from -to in a single request; 0 downloads the whole range")
+	out := flags.String("out", "", "write to a .csv or .parquet file instead of printing")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	p, ok := openapi.ProtoOATrendbarPeriod_value[strings.ToUpper(*period)]
+	if !ok {
+		return errors.Errorf("unknown period %q", *period)
+	}
+
+	fromTimestamp, toTimestamp, err := parseRange(*from, *to)
+	if err != nil {
+		return err
+	}
+
+	account, err := a.session()
+	if err != nil {
+		return err
+	}
+
+	symbolId, err := resolveSymbol(account, *symbol)
+	if err != nil {
+		return err
+	}
+
+	var candles []ctrader.Candle
+	if *count > 0 {
+		if candles, err = account.GetCandles(fromTimestamp, toTimestamp, openapi.ProtoOATrendbarPeriod(p), symbolId, uint32(*count)); err != nil {
+			return err
+		}
+	} else {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+
+		stream, errs := account.DownloadBars(ctx, symbolId, openapi.ProtoOATrendbarPeriod(p), fromMillis(fromTimestamp), fromMillis(toTimestamp))
+		for v := range stream {
+			candles = append(candles, v)
+		}
+		if err := <-errs; err != nil {
+			return err
+		}
+	}
+
+	if *out != "" {
+		return export.File(*out, func(w io.Writer, format export.Format) error {
+			return export.Bars(w, format, candles)
+		})
+	}
+
+	t := newTable("TIME", "OPEN", "HIGH", "LOW", "CLOSE", "VOLUME")
+	for _, v := range candles {
+		t.add(v.Time.Format(time.RFC3339), v.Open.String(), v.High.String(), v.Low.String(), v.Close.String(), strconv.FormatInt(v.Volume, 10))
+	}
+
+	return a.printer.print(t, nil)
+}
+
+func runTicks(a *app, args []string) error {
+	flags := flag.NewFlagSet("ticks", flag.ContinueOnError)
+	symbol := flags.String("symbol", "", "symbol id or name")
+	quoteType := flags.String("type", "bid", "quote type: bid, ask or both")
+	from := flags.String("from", "10m", "start time")
+	to := flags.String("to", "now", "end time")
+	out := flags.String("out", "", "write to a .csv or .parquet file instead of printing")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	both := strings.EqualFold(*quoteType, "both")
+	q, ok := openapi.ProtoOAQuoteType_value[strings.ToUpper(*quoteType)]
+	if !ok && !both {
+		return errors.Errorf("unknown quote type %q", *quoteType)
+	}
+
+	fromTimestamp, toTimestamp, err := parseRange(*from, *to)
+	if err != nil {
+		return err
+	}
+
+	account, err := a.session()
+	if err != nil {
+		return err
+	}
+
+	symbolId, err := resolveSymbol(account, *symbol)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if both {
+		var quotes []ctrader.Quote
+		stream, errs := account.DownloadQuotes(ctx, symbolId, fromMillis(fromTimestamp), fromMillis(toTimestamp))
+		for v := range stream {
+			quotes = append(quotes, v)
+		}
+		if err := <-errs; err != nil {
+			return err
+		}
+
+		if *out != "" {
+			return export.File(*out, func(w io.Writer, format export.Format) error {
+				return export.Quotes(w, format, quotes)
+			})
+		}
+
+		t := newTable("TIME", "BID", "ASK")
+		for _, v := range quotes {
+			t.add(v.Time.Format(timeLayoutMillis), v.Bid.String(), v.Ask.String())
+		}
+
+		return a.printer.print(t, nil)
+	}
+
+	var ticks []ctrader.Tick
+	stream, errs := account.DownloadTicks(ctx, symbolId, openapi.ProtoOAQuoteType(q), fromMillis(fromTimestamp), fromMillis(toTimestamp))
+	for v := range stream {
+		ticks = append(ticks, v)
+	}
+	if err := <-errs; err != nil {
+		return err
+	}
+
+	if *out != "" {
+		return export.File(*out, func(w io.Writer, format export.Format) error {
+			return export.Ticks(w, format, ticks)
+		})
+	}
+
+	t := newTable("TIME", "PRICE")
+	for _, v := range ticks {
+		t.add(v.Time.Format(timeLayoutMillis), v.Price.String())
+	}
+
+	return a.printer.print(t, nil)
+}
+
+func runSpots(a *app, args []string) error {
+	flags := flag.NewFlagSet("spots", flag.ContinueOnError)
+	symbol := flags.String("symbol", "", "comma separated symbol ids or names")
+	follow := flags.Bool("follow", false, "stream spot events until interrupted")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	account, err := a.session()
+	if err != nil {
+		return err
+	}
+
+	symbolIds, err := resolveSymbols(account, *symbol)
+	if err != nil {
+		return err
+	}
+
+	spotHandler, err := account.OnSpot()
+	if err != nil {
+		return err
+	}
+	defer spotHandler.Close()
+
+	spots := make(chan *openapi.ProtoOASpotEvent, 64)
+	spotHandler.Handle(
+		func(msg *model.Message) {
+			if v, ok := msg.Payload.(*openapi.ProtoOASpotEvent); ok {
+				select {
+				case spots <- v:
+				default:
+				}
+			}
+		},
+		func(err error) {
+			fmt.Fprintln(os.Stderr, "ctrader:", err)
+		})
+
+	if _, err := account.SubscribeSpots(symbolIds); err != nil {
+		return err
+	}
+	defer account.UnsubscribeSpots(symbolIds)
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+
+	// spot events may carry only one side, keep the last known bid and ask per symbol
+	var mu sync.Mutex
+	last := map[int64]*openapi.ProtoOASpotEvent{}
+	merge := func(event *openapi.ProtoOASpotEvent) *openapi.ProtoOASpotEvent {
+		mu.Lock()
+		defer mu.Unlock()
+		prev, ok := last[event.GetSymbolId()]
+		if !ok {
+			prev = &openapi.ProtoOASpotEvent{SymbolId: event.SymbolId}
+			last[event.GetSymbolId()] = prev
+		}
+		if event.Bid != nil {
+			prev.Bid = event.Bid
+		}
+		if event.Ask != nil {
+			prev.Ask = event.Ask
+		}
+		if event.Timestamp != nil {
+			prev.Timestamp = event.Timestamp
+		}
+		return prev
+	}
+
+	if *follow {
+		w := csv.NewWriter(os.Stdout)
+		if a.printer.format == FormatCSV {
+			_ = w.Write([]string{"TIME", "SYMBOL", "BID", "ASK"})
+		}
+		for {
+			select {
+			case event := <-spots:
+				quote := merge(event)
+				timestamp := quote.GetTimestamp()
+				if timestamp == 0 {
+					timestamp = toMillis(time.Now())
+				}
+				switch a.printer.format {
+				case FormatJSON:
+					b, err := protojson.Marshal(event)
+					if err != nil {
+						return err
+					}
+					fmt.Println(string(b))
+				case FormatCSV:
+					_ = w.Write([]string{formatMillis(&timestamp), formatInt(quote.SymbolId), formatPrice(int64(quote.GetBid())), formatPrice(int64(quote.GetAsk()))})
+					w.Flush()
+				default:
+					fmt.Printf("%-20s  %-8d  %-12s  %-12s\n", formatMillis(&timestamp), quote.GetSymbolId(), formatPrice(int64(quote.GetBid())), formatPrice(int64(quote.GetAsk())))
+				}
+			case <-interrupt:
+				return nil
+			}
+		}
+	}
+
+	pending := map[int64]bool{}
+	for _, id := range symbolIds {
+		pending[id] = true
+	}
+
+	timeout := time.After(time.Second * 30)
+	for len(pending) > 0 {
+		select {
+		case event := <-spots:
+			quote := merge(event)
+			if quote.Bid != nil && quote.Ask != nil {
+				delete(pending, quote.GetSymbolId())
+			}
+		case <-interrupt:
+			return nil
+		case <-timeout:
+			return errors.New("timeout waiting for spot prices")
+		}
+	}
+
+	t := newTable("SYMBOL", "BID", "ASK", "TIME")
+	for _, id := range symbolIds {
+		quote := last[id]
+		timestamp := quote.GetTimestamp()
+		t.add(strconv.FormatInt(id, 10), formatPrice(int64(quote.GetBid())), formatPrice(int64(quote.GetAsk())), formatMillis(&timestamp))
+	}
+
+	return a.printer.print(t, nil)
+}
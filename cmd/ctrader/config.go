@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+const (
+	DemoHost = "demo.ctraderapi.com:5035"
+	LiveHost = "live.ctraderapi.com:5035"
+)
+
+type Config struct {
+	Host         string `json:"host"`
+	ClientId     string `json:"clientId"`
+	ClientSecret string `json:"clientSecret"`
+	AccessToken  string `json:"accessToken"`
+	AccountId    int64  `json:"accountId"`
+}
+
+func DefaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(dir, "ctrader", "config.json")
+}
+
+// LoadConfig reads the config file (if any) and then applies CTRADER_* environment overrides.
+func LoadConfig(path string) (*Config, error) {
+	config := &Config{Host: DemoHost}
+
+	explicit := path != ""
+	if !explicit {
+		path = DefaultConfigPath()
+	}
+
+	if path != "" {
+		b, err := os.ReadFile(path)
+		switch {
+		case err == nil:
+			if err := json.Unmarshal(b, config); err != nil {
+				return nil, errors.Wrapf(err, "parse config %s", path)
+			}
+		case os.IsNotExist(err) && !explicit:
+		default:
+			return nil, err
+		}
+	}
+
+	if v := os.Getenv("CTRADER_HOST"); v != "" {
+		config.Host = v
+	}
+	if v := os.Getenv("CTRADER_CLIENT_ID"); v != "" {
+		config.ClientId = v
+	}
+	if v := os.Getenv("CTRADER_CLIENT_SECRET"); v != "" {
+		config.ClientSecret = v
+	}
+	if v := os.Getenv("CTRADER_ACCESS_TOKEN"); v != "" {
+		config.AccessToken = v
+	}
+	if v := os.Getenv("CTRADER_ACCOUNT_ID"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, errors.Wrap(err, "CTRADER_ACCOUNT_ID")
+		}
+		config.AccountId = id
+	}
+
+	return config, nil
+}
+
+func (config *Config) validate(needAccount bool) error {
+	if config.ClientId == "" || config.ClientSecret == "" {
+		return errors.New("client id and secret are required (CTRADER_CLIENT_ID, CTRADER_CLIENT_SECRET)")
+	}
+
+	if config.AccessToken == "" {
+		return errors.New("access token is required (CTRADER_ACCESS_TOKEN)")
+	}
+
+	if needAccount && config.AccountId == 0 {
+		return errors.New("account id is required (CTRADER_ACCOUNT_ID or -account)")
+	}
+
+	return nil
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/pkg/errors"
+	ctrader "github.com/ty2/ctrader-go"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+type command struct {
+	usage string
+	run   func(app *app, args []string) error
+}
+
+var commands = map[string]command{
+	"accounts":  {"list accounts granted to the access token", runAccounts},
+	"symbols":   {"list symbols", runSymbols},
+	"trader":    {"show trader account details", runTrader},
+	"positions": {"list open positions", runPositions},
+	"orders":    {"list pending orders, or historical orders with -from/-to", runOrders},
+	"deals":     {"list deals", runDeals},
+	"cashflow":  {"list deposits and withdrawals", runCashFlow},
+	"bars":      {"get historical trend bars", runBars},
+	"ticks":     {"get historical tick data", runTicks},
+	"spots":     {"show spot prices, -follow to stream", runSpots},
+	"order":     {"new | amend | cancel an order", runOrder},
+	"position":  {"close a position", runPosition},
+	"monitor":   {"interactive console with live quotes, positions, orders and executions", runMonitor},
+}
+
+type app struct {
+	config  *Config
+	printer *printer
+	client  *ctrader.Client
+	account *ctrader.Account
+}
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "ctrader:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	flags := flag.NewFlagSet("ctrader", flag.ContinueOnError)
+	configPath := flags.String("config", "", "config file (default "+DefaultConfigPath()+")")
+	format := flags.String("o", FormatTable, "output format: table, json or csv")
+	accountId := flags.Int64("account", 0, "ctid trader account id, overrides config")
+	host := flags.String("host", "", "api host, overrides config")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: ctrader [flags] <command> [command flags]")
+		fmt.Fprintln(flags.Output(), "\ncommands:")
+		names := make([]string, 0, len(commands))
+		for name := range commands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(flags.Output(), "  %-10s %s\n", name, commands[name].usage)
+		}
+		fmt.Fprintln(flags.Output(), "\nflags:")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return errors.New("missing command")
+	}
+
+	cmd, ok := commands[flags.Arg(0)]
+	if !ok {
+		flags.Usage()
+		return errors.Errorf("unknown command %q", flags.Arg(0))
+	}
+
+	config, err := LoadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	if *accountId != 0 {
+		config.AccountId = *accountId
+	}
+	if *host != "" {
+		config.Host = *host
+	}
+
+	p, err := newPrinter(os.Stdout, *format)
+	if err != nil {
+		return err
+	}
+
+	a := &app{config: config, printer: p}
+	defer a.close()
+
+	return cmd.run(a, flags.Args()[1:])
+}
+
+// connect opens the connection and authorises the application.
+func (a *app) connect() (*ctrader.Client, error) {
+	if a.client != nil {
+		return a.client, nil
+	}
+
+	if err := a.config.validate(false); err != nil {
+		return nil, err
+	}
+
+	client := ctrader.NewClient(ctrader.NewConn(a.config.Host), a.config.ClientId, a.config.ClientSecret, a.config.AccessToken)
+	if err := client.Connect(); err != nil {
+		return nil, err
+	}
+
+	if _, err := client.ApplicationAuth(); err != nil {
+		_ = client.Close()
+		return nil, errors.Wrap(err, "application auth")
+	}
+
+	a.client = client
+	return client, nil
+}
+
+// session connects and authorises the configured trading account.
+func (a *app) session() (*ctrader.Account, error) {
+	if a.account != nil {
+		return a.account, nil
+	}
+
+	if err := a.config.validate(true); err != nil {
+		return nil, err
+	}
+
+	client, err := a.connect()
+	if err != nil {
+		return nil, err
+	}
+
+	account, err := client.Account(a.config.AccountId)
+	if err != nil {
+		return nil, errors.Wrap(err, "account auth")
+	}
+
+	a.account = account
+	return account, nil
+}
+
+func (a *app) close() {
+	if a.client != nil {
+		_ = a.client.Close()
+	}
+}
+
+// parseTime accepts RFC3339, a date (2006-01-02), "now", or a duration meaning that long ago (e.g. 24h).
+func parseTime(s string) (time.Time, error) {
+	if s == "" || s == "now" {
+		return time.Now(), nil
+	}
+
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04", "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, errors.Errorf("invalid time %q", s)
+}
+
+func parseRange(from, to string) (int64, int64, error) {
+	fromTime, err := parseTime(from)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	toTime, err := parseTime(to)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if !fromTime.Before(toTime) {
+		return 0, 0, errors.New("-from must be before -to")
+	}
+
+	return toMillis(fromTime), toMillis(toTime), nil
+}
+
+func toMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func fromMillis(v int64) time.Time {
+	return time.Unix(0, v*int64(time.Millisecond))
+}
+
+// resolveSymbols maps a comma separated list of symbol ids or names to ids.
+func resolveSymbols(account *ctrader.Account, s string) ([]int64, error) {
+	if s == "" {
+		return nil, errors.New("-symbol is required")
+	}
+
+	var ids []int64
+	for _, v := range strings.Split(s, ",") {
+		id, err := account.Symbols().Resolve(strings.TrimSpace(v))
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+func resolveSymbol(account *ctrader.Account, s string) (int64, error) {
+	ids, err := resolveSymbols(account, s)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(ids) != 1 {
+		return 0, errors.New("exactly one -symbol is required")
+	}
+
+	return ids[0], nil
+}
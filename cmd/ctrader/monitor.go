@@ -0,0 +1,449 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/gdamore/tcell/v2"
+	"github.com/pkg/errors"
+	ctrader "github.com/ty2/ctrader-go"
+	"github.com/ty2/ctrader-go/proto/openapi"
+	"github.com/vmware/transport-go/model"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const monitorLogSize = 200
+
+const (
+	focusPositions = iota
+	focusOrders
+)
+
+type monitorQuote struct {
+	bid, ask  uint64
+	timestamp int64
+}
+
+// monitor is the state behind the interactive console; every field is guarded by mu.
+type monitor struct {
+	account *ctrader.Account
+	screen  tcell.Screen
+
+	mu         sync.Mutex
+	symbolIds  []int64
+	subscribed map[int64]bool
+	names      map[int64]string
+	quotes     map[int64]*monitorQuote
+	positions  []*openapi.ProtoOAPosition
+	orders     []*openapi.ProtoOAOrder
+	log        []string
+	focus      int
+	selected   [2]int
+	confirm    func()
+	prompt     string
+}
+
+func runMonitor(a *app, args []string) error {
+	flags := flag.NewFlagSet("monitor", flag.ContinueOnError)
+	symbol := flags.String("symbol", "", "comma separated symbol ids or names to watch besides open positions")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	account, err := a.session()
+	if err != nil {
+		return err
+	}
+
+	m := &monitor{
+		account:    account,
+		subscribed: map[int64]bool{},
+		names:      map[int64]string{},
+		quotes:     map[int64]*monitorQuote{},
+	}
+
+	if *symbol != "" {
+		if m.symbolIds, err = resolveSymbols(account, *symbol); err != nil {
+			return err
+		}
+	}
+
+	if symbols, err := account.Symbols().LightSymbols(); err == nil {
+		for _, v := range symbols {
+			m.names[v.GetSymbolId()] = v.GetSymbolName()
+		}
+	}
+
+	spotHandler, err := account.OnSpot()
+	if err != nil {
+		return err
+	}
+	defer spotHandler.Close()
+	spotHandler.Handle(func(msg *model.Message) {
+		if v, ok := msg.Payload.(*openapi.ProtoOASpotEvent); ok {
+			m.onSpot(v)
+		}
+	}, m.onError)
+
+	executionHandler, err := account.OnExecution()
+	if err != nil {
+		return err
+	}
+	defer executionHandler.Close()
+	executionHandler.Handle(func(msg *model.Message) {
+		if v, ok := msg.Payload.(*openapi.ProtoOAExecutionEvent); ok {
+			m.onExecution(v)
+		}
+	}, m.onError)
+
+	if err := m.reconcile(); err != nil {
+		return err
+	}
+
+	screen, err := tcell.NewScreen()
+	if err != nil {
+		return err
+	}
+	if err := screen.Init(); err != nil {
+		return err
+	}
+	defer screen.Fini()
+
+	m.mu.Lock()
+	m.screen = screen
+	m.mu.Unlock()
+
+	go func() {
+		for range time.Tick(time.Second) {
+			screen.PostEvent(tcell.NewEventInterrupt(nil))
+		}
+	}()
+
+	for {
+		m.draw()
+		switch ev := screen.PollEvent().(type) {
+		case nil:
+			return nil
+		case *tcell.EventResize:
+			screen.Sync()
+		case *tcell.EventKey:
+			if quit := m.onKey(ev); quit {
+				return nil
+			}
+		}
+	}
+}
+
+func (m *monitor) refresh() {
+	if m.screen != nil {
+		m.screen.PostEvent(tcell.NewEventInterrupt(nil))
+	}
+}
+
+func (m *monitor) logf(format string, args ...interface{}) {
+	m.log = append(m.log, time.Now().Format("15:04:05")+" "+fmt.Sprintf(format, args...))
+	if len(m.log) > monitorLogSize {
+		m.log = m.log[len(m.log)-monitorLogSize:]
+	}
+}
+
+func (m *monitor) onError(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.logf("error: %v", err)
+	m.refresh()
+}
+
+func (m *monitor) onSpot(event *openapi.ProtoOASpotEvent) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	quote, ok := m.quotes[event.GetSymbolId()]
+	if !ok {
+		quote = &monitorQuote{}
+		m.quotes[event.GetSymbolId()] = quote
+	}
+	if event.Bid != nil {
+		quote.bid = *event.Bid
+	}
+	if event.Ask != nil {
+		quote.ask = *event.Ask
+	}
+	quote.timestamp = event.GetTimestamp()
+	if quote.timestamp == 0 {
+		quote.timestamp = toMillis(time.Now())
+	}
+}
+
+func (m *monitor) onExecution(event *openapi.ProtoOAExecutionEvent) {
+	m.mu.Lock()
+	line := event.GetExecutionType().String()
+	if order := event.Order; order != nil {
+		data := order.GetTradeData()
+		line += fmt.Sprintf(" order %d %s %s %s %s", order.GetOrderId(), order.GetOrderType(), data.GetTradeSide(), m.symbolName(data.GetSymbolId()), formatVolume(data.Volume))
+	}
+	if deal := event.Deal; deal != nil {
+		line += fmt.Sprintf(" deal %d @ %s", deal.GetDealId(), formatFloat(deal.ExecutionPrice))
+	}
+	if position := event.Position; position != nil {
+		line += fmt.Sprintf(" position %d %s", position.GetPositionId(), position.GetPositionStatus())
+	}
+	m.logf("%s", line)
+	m.mu.Unlock()
+
+	go func() {
+		if err := m.reconcile(); err != nil {
+			m.onError(err)
+		}
+	}()
+}
+
+// reconcile reloads positions and orders and subscribes to any new symbols.
+func (m *monitor) reconcile() error {
+	res, err := m.account.Reconcile()
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.positions = res.Position
+	m.orders = res.Order
+	sort.Slice(m.positions, func(i, j int) bool { return m.positions[i].GetPositionId() < m.positions[j].GetPositionId() })
+	sort.Slice(m.orders, func(i, j int) bool { return m.orders[i].GetOrderId() < m.orders[j].GetOrderId() })
+
+	var subscribe []int64
+	want := append([]int64{}, m.symbolIds...)
+	for _, position := range m.positions {
+		want = append(want, position.GetTradeData().GetSymbolId())
+	}
+	for _, order := range m.orders {
+		want = append(want, order.GetTradeData().GetSymbolId())
+	}
+	for _, id := range want {
+		if !m.subscribed[id] {
+			m.subscribed[id] = true
+			subscribe = append(subscribe, id)
+		}
+	}
+	m.mu.Unlock()
+
+	if len(subscribe) > 0 {
+		if _, err := m.account.Subscriptions().Spots(subscribe...); err != nil {
+			return errors.Wrap(err, "subscribe spots")
+		}
+	}
+
+	m.mu.Lock()
+	m.refresh()
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *monitor) symbolName(id int64) string {
+	if name, ok := m.names[id]; ok {
+		return name
+	}
+
+	return strconv.FormatInt(id, 10)
+}
+
+func (m *monitor) onKey(ev *tcell.EventKey) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.confirm != nil {
+		if ev.Rune() == 'y' || ev.Rune() == 'Y' {
+			go m.confirm()
+		}
+		m.confirm, m.prompt = nil, ""
+		return false
+	}
+
+	rows := len(m.positions)
+	if m.focus == focusOrders {
+		rows = len(m.orders)
+	}
+
+	switch ev.Key() {
+	case tcell.KeyEscape, tcell.KeyCtrlC:
+		return true
+	case tcell.KeyTab:
+		m.focus = (m.focus + 1) % 2
+	case tcell.KeyUp:
+		if m.selected[m.focus] > 0 {
+			m.selected[m.focus]--
+		}
+	case tcell.KeyDown:
+		if m.selected[m.focus] < rows-1 {
+			m.selected[m.focus]++
+		}
+	case tcell.KeyRune:
+		switch ev.Rune() {
+		case 'q':
+			return true
+		case 'r':
+			go func() {
+				if err := m.reconcile(); err != nil {
+					m.onError(err)
+				}
+			}()
+		case 'c':
+			m.confirmSelected()
+		}
+	}
+
+	return false
+}
+
+func (m *monitor) confirmSelected() {
+	account := m.account
+	if m.focus == focusPositions {
+		if m.selected[0] >= len(m.positions) {
+			return
+		}
+		position := m.positions[m.selected[0]]
+		positionId, volume := position.GetPositionId(), position.GetTradeData().GetVolume()
+		m.prompt = fmt.Sprintf("close position %d (%s)? y/n", positionId, formatVolume(&volume))
+		m.confirm = func() {
+			if _, err := account.ClosePosition(positionId, volume); err != nil {
+				m.onError(errors.Wrapf(err, "close position %d", positionId))
+			}
+		}
+		return
+	}
+
+	if m.selected[1] >= len(m.orders) {
+		return
+	}
+	orderId := m.orders[m.selected[1]].GetOrderId()
+	m.prompt = fmt.Sprintf("cancel order %d? y/n", orderId)
+	m.confirm = func() {
+		if _, err := account.CancelOrder(orderId); err != nil {
+			m.onError(errors.Wrapf(err, "cancel order %d", orderId))
+		}
+	}
+}
+
+// floatingPnl is the unrealised gross profit of a position in its quote currency.
+func (m *monitor) floatingPnl(position *openapi.ProtoOAPosition) string {
+	data := position.GetTradeData()
+	quote, ok := m.quotes[data.GetSymbolId()]
+	if !ok || position.Price == nil {
+		return ""
+	}
+
+	price := quote.bid
+	if data.GetTradeSide() == openapi.ProtoOATradeSide_SELL {
+		price = quote.ask
+	}
+	if price == 0 {
+		return ""
+	}
+
+	diff := float64(price)/100000 - position.GetPrice()
+	if data.GetTradeSide() == openapi.ProtoOATradeSide_SELL {
+		diff = -diff
+	}
+
+	return strconv.FormatFloat(diff*float64(data.GetVolume())/100, 'f', 2, 64)
+}
+
+func (m *monitor) draw() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s := m.screen
+	s.Clear()
+	width, height := s.Size()
+	header := tcell.StyleDefault.Bold(true)
+	selected := tcell.StyleDefault.Reverse(true)
+
+	y := 0
+	put := func(x int, style tcell.Style, text string) {
+		for _, r := range text {
+			if x >= width {
+				break
+			}
+			s.SetContent(x, y, r, nil, style)
+			x++
+		}
+	}
+	row := func(style tcell.Style, widths []int, cols ...string) {
+		x := 0
+		for i, col := range cols {
+			put(x, style, col)
+			x += widths[i]
+		}
+		y++
+	}
+
+	put(0, header, fmt.Sprintf("account %d  [tab] switch  [up/down] select  [c] close/cancel  [r] reconcile  [q] quit", m.account.Id()))
+	y += 2
+
+	quoteWidths := []int{14, 14, 14, 10, 22}
+	row(header, quoteWidths, "SYMBOL", "BID", "ASK", "SPREAD", "TIME")
+	symbolIds := make([]int64, 0, len(m.quotes))
+	for id := range m.quotes {
+		symbolIds = append(symbolIds, id)
+	}
+	sort.Slice(symbolIds, func(i, j int) bool { return m.symbolName(symbolIds[i]) < m.symbolName(symbolIds[j]) })
+	for _, id := range symbolIds {
+		quote := m.quotes[id]
+		spread := ""
+		if quote.bid > 0 && quote.ask > 0 {
+			spread = formatPrice(int64(quote.ask) - int64(quote.bid))
+		}
+		timestamp := quote.timestamp
+		row(tcell.StyleDefault, quoteWidths, m.symbolName(id), formatPrice(int64(quote.bid)), formatPrice(int64(quote.ask)), spread, formatMillis(&timestamp))
+	}
+	y++
+
+	positionWidths := []int{12, 12, 6, 12, 12, 12, 12, 14}
+	row(header, positionWidths, "POSITION", "SYMBOL", "SIDE", "VOLUME", "PRICE", "SL", "TP", "PNL (QUOTE)")
+	for i, position := range m.positions {
+		style := tcell.StyleDefault
+		if m.focus == focusPositions && m.selected[0] == i {
+			style = selected
+		}
+		data := position.GetTradeData()
+		row(style, positionWidths, formatInt(position.PositionId), m.symbolName(data.GetSymbolId()), data.GetTradeSide().String(), formatVolume(data.Volume),
+			formatFloat(position.Price), formatFloat(position.StopLoss), formatFloat(position.TakeProfit), m.floatingPnl(position))
+	}
+	y++
+
+	orderWidths := []int{12, 12, 6, 14, 12, 12, 12, 12}
+	row(header, orderWidths, "ORDER", "SYMBOL", "SIDE", "TYPE", "VOLUME", "LIMIT", "STOP", "EXPIRY")
+	for i, order := range m.orders {
+		style := tcell.StyleDefault
+		if m.focus == focusOrders && m.selected[1] == i {
+			style = selected
+		}
+		data := order.GetTradeData()
+		row(style, orderWidths, formatInt(order.OrderId), m.symbolName(data.GetSymbolId()), data.GetTradeSide().String(), order.GetOrderType().String(),
+			formatVolume(data.Volume), formatFloat(order.LimitPrice), formatFloat(order.StopPrice), formatMillis(order.ExpirationTimestamp))
+	}
+	y++
+
+	put(0, header, "EXECUTIONS")
+	y++
+	lines := height - y - 1
+	if lines > 0 {
+		start := len(m.log) - lines
+		if start < 0 {
+			start = 0
+		}
+		for _, line := range m.log[start:] {
+			put(0, tcell.StyleDefault, line)
+			y++
+		}
+	}
+
+	if m.prompt != "" {
+		y = height - 1
+		put(0, selected, m.prompt)
+	}
+
+	s.Show()
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	FormatTable = "table"
+	FormatJSON  = "json"
+	FormatCSV   = "csv"
+)
+
+type table struct {
+	header []string
+	rows   [][]string
+}
+
+func newTable(header ...string) *table {
+	return &table{header: header}
+}
+
+func (t *table) add(cols ...string) {
+	t.rows = append(t.rows, cols)
+}
+
+type printer struct {
+	w      io.Writer
+	format string
+}
+
+func newPrinter(w io.Writer, format string) (*printer, error) {
+	switch format {
+	case FormatTable, FormatJSON, FormatCSV:
+	default:
+		return nil, errors.Errorf("unknown output format %q", format)
+	}
+
+	return &printer{w: w, format: format}, nil
+}
+
+// print writes t as a table or CSV; in JSON mode the full response message is written instead,
+// or the table rows as objects when there is no response message.
+func (p *printer) print(t *table, res proto.Message) error {
+	switch p.format {
+	case FormatJSON:
+		if res == nil {
+			return p.printRows(t)
+		}
+		b, err := protojson.MarshalOptions{Multiline: true, Indent: "  "}.Marshal(res)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(p.w, string(b))
+		return err
+	case FormatCSV:
+		w := csv.NewWriter(p.w)
+		if err := w.Write(t.header); err != nil {
+			return err
+		}
+		if err := w.WriteAll(t.rows); err != nil {
+			return err
+		}
+		w.Flush()
+		return w.Error()
+	default:
+		w := tabwriter.NewWriter(p.w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, strings.Join(t.header, "\t"))
+		for _, row := range t.rows {
+			fmt.Fprintln(w, strings.Join(row, "\t"))
+		}
+		return w.Flush()
+	}
+}
+
+func (p *printer) printRows(t *table) error {
+	rows := make([]map[string]string, len(t.rows))
+	for i, row := range t.rows {
+		rows[i] = map[string]string{}
+		for j, col := range row {
+			rows[i][strings.ToLower(t.header[j])] = col
+		}
+	}
+
+	encoder := json.NewEncoder(p.w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(rows)
+}
+
+func formatInt(v *int64) string {
+	if v == nil {
+		return ""
+	}
+
+	return strconv.FormatInt(*v, 10)
+}
+
+func formatFloat(v *float64) string {
+	if v == nil {
+		return ""
+	}
+
+	return strconv.FormatFloat(*v, 'f', -1, 64)
+}
+
+func formatBool(v *bool) string {
+	if v == nil {
+		return ""
+	}
+
+	return strconv.FormatBool(*v)
+}
+
+func formatString(v *string) string {
+	if v == nil {
+		return ""
+	}
+
+	return *v
+}
+
+// timeLayoutMillis is RFC3339 with milliseconds, for ticks.
+const timeLayoutMillis = "2006-01-02T15:04:05.000Z07:00"
+
+func formatMillis(v *int64) string {
+	if v == nil || *v == 0 {
+		return ""
+	}
+
+	return time.Unix(0, *v*int64(time.Millisecond)).UTC().Format(time.RFC3339)
+}
+
+// formatMoney scales an Open API money value by its moneyDigits (2 when unset).
+func formatMoney(v *int64, moneyDigits *uint32) string {
+	if v == nil {
+		return ""
+	}
+
+	digits := 2
+	if moneyDigits != nil {
+		digits = int(*moneyDigits)
+	}
+
+	return formatScaled(*v, digits)
+}
+
+func formatScaled(v int64, digits int) string {
+	if digits <= 0 {
+		return strconv.FormatInt(v, 10)
+	}
+
+	sign := ""
+	if v < 0 {
+		sign = "-"
+		v = -v
+	}
+
+	s := strconv.FormatInt(v, 10)
+	for len(s) <= digits {
+		s = "0" + s
+	}
+
+	return sign + s[:len(s)-digits] + "." + s[len(s)-digits:]
+}
+
+// formatPrice renders a price encoded in 1/100000 of a unit.
+func formatPrice(v int64) string {
+	return formatScaled(v, 5)
+}
+
+// formatVolume renders a volume in cents as units.
+func formatVolume(v *int64) string {
+	if v == nil {
+		return ""
+	}
+
+	return formatScaled(*v, 2)
+}
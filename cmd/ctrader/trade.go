@@ -0,0 +1,315 @@
+package main
+
+import (
+	"flag"
+	"github.com/pkg/errors"
+	"github.com/ty2/ctrader-go/proto/openapi"
+	"strings"
+)
+
+func runOrder(a *app, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: ctrader order new|amend|cancel [flags]")
+	}
+
+	switch args[0] {
+	case "new":
+		return runOrderNew(a, args[1:])
+	case "amend":
+		return runOrderAmend(a, args[1:])
+	case "cancel":
+		return runOrderCancel(a, args[1:])
+	default:
+		return errors.Errorf("unknown order command %q", args[0])
+	}
+}
+
+// optionalFlags tracks flags that map to optional proto fields, so unset flags stay nil.
+type optionalFlags struct {
+	set map[string]bool
+}
+
+func (o *optionalFlags) parse(flags *flag.FlagSet, args []string) error {
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	o.set = map[string]bool{}
+	flags.Visit(func(f *flag.Flag) {
+		o.set[f.Name] = true
+	})
+
+	return nil
+}
+
+func (o *optionalFlags) floatValue(name string, v *float64) *float64 {
+	if !o.set[name] {
+		return nil
+	}
+
+	return v
+}
+
+func (o *optionalFlags) int64Value(name string, v *int64) *int64 {
+	if !o.set[name] {
+		return nil
+	}
+
+	return v
+}
+
+func (o *optionalFlags) stringValue(name string, v *string) *string {
+	if !o.set[name] {
+		return nil
+	}
+
+	return v
+}
+
+func runOrderNew(a *app, args []string) error {
+	flags := flag.NewFlagSet("order new", flag.ContinueOnError)
+	symbol := flags.String("symbol", "", "symbol id or name")
+	side := flags.String("side", "", "buy or sell")
+	orderType := flags.String("type", "market", "market, limit, stop, stop_limit or market_range")
+	volume := flags.Int64("volume", 0, "volume in cents of units (e.g. 100000 = 1000 units)")
+	limitPrice := flags.Float64("price", 0, "limit price")
+	stopPrice := flags.Float64("stop-price", 0, "stop price")
+	stopLoss := flags.Float64("sl", 0, "absolute stop loss price")
+	takeProfit := flags.Float64("tp", 0, "absolute take profit price")
+	relativeStopLoss := flags.Int64("rel-sl", 0, "relative stop loss in 1/100000 of price")
+	relativeTakeProfit := flags.Int64("rel-tp", 0, "relative take profit in 1/100000 of price")
+	basePrice := flags.Float64("base-price", 0, "base slippage price for market range orders")
+	slippage := flags.Int("slippage", 0, "slippage in points for market range and stop limit orders")
+	timeInForce := flags.String("tif", "", "time in force: good_till_cancel, good_till_date, immediate_or_cancel, fill_or_kill")
+	expiry := flags.String("expiry", "", "expiration time for good till date orders")
+	label := flags.String("label", "", "order label")
+	comment := flags.String("comment", "", "order comment")
+	clientOrderId := flags.String("client-id", "", "client order id")
+	positionId := flags.Int64("position", 0, "existing position id")
+	trailing := flags.Bool("trailing", false, "trailing stop loss")
+
+	var optional optionalFlags
+	if err := optional.parse(flags, args); err != nil {
+		return err
+	}
+
+	tradeSide, ok := openapi.ProtoOATradeSide_value[strings.ToUpper(*side)]
+	if !ok {
+		return errors.New("-side must be buy or sell")
+	}
+
+	t, ok := openapi.ProtoOAOrderType_value[strings.ToUpper(*orderType)]
+	if !ok {
+		return errors.Errorf("unknown order type %q", *orderType)
+	}
+
+	if *volume <= 0 {
+		return errors.New("-volume is required")
+	}
+
+	account, err := a.session()
+	if err != nil {
+		return err
+	}
+
+	symbolId, err := resolveSymbol(account, *symbol)
+	if err != nil {
+		return err
+	}
+
+	req := &openapi.ProtoOANewOrderReq{
+		SymbolId:           &symbolId,
+		OrderType:          openapi.ProtoOAOrderType(t).Enum(),
+		TradeSide:          openapi.ProtoOATradeSide(tradeSide).Enum(),
+		Volume:             volume,
+		LimitPrice:         optional.floatValue("price", limitPrice),
+		StopPrice:          optional.floatValue("stop-price", stopPrice),
+		StopLoss:           optional.floatValue("sl", stopLoss),
+		TakeProfit:         optional.floatValue("tp", takeProfit),
+		RelativeStopLoss:   optional.int64Value("rel-sl", relativeStopLoss),
+		RelativeTakeProfit: optional.int64Value("rel-tp", relativeTakeProfit),
+		BaseSlippagePrice:  optional.floatValue("base-price", basePrice),
+		Label:              optional.stringValue("label", label),
+		Comment:            optional.stringValue("comment", comment),
+		ClientOrderId:      optional.stringValue("client-id", clientOrderId),
+		PositionId:         optional.int64Value("position", positionId),
+	}
+
+	if optional.set["slippage"] {
+		v := int32(*slippage)
+		req.SlippageInPoints = &v
+	}
+
+	if *trailing {
+		req.TrailingStopLoss = trailing
+	}
+
+	if *timeInForce != "" {
+		tif, ok := openapi.ProtoOATimeInForce_value[strings.ToUpper(*timeInForce)]
+		if !ok {
+			return errors.Errorf("unknown time in force %q", *timeInForce)
+		}
+		req.TimeInForce = openapi.ProtoOATimeInForce(tif).Enum()
+	}
+
+	if *expiry != "" {
+		expiration, err := parseTime(*expiry)
+		if err != nil {
+			return err
+		}
+		timestamp := toMillis(expiration)
+		req.ExpirationTimestamp = &timestamp
+	}
+
+	res, err := account.NewOrder(req)
+	if err != nil {
+		return err
+	}
+
+	return a.printer.print(executionTable(res), res)
+}
+
+func runOrderAmend(a *app, args []string) error {
+	flags := flag.NewFlagSet("order amend", flag.ContinueOnError)
+	orderId := flags.Int64("id", 0, "order id")
+	volume := flags.Int64("volume", 0, "volume in cents of units")
+	limitPrice := flags.Float64("price", 0, "limit price")
+	stopPrice := flags.Float64("stop-price", 0, "stop price")
+	stopLoss := flags.Float64("sl", 0, "absolute stop loss price")
+	takeProfit := flags.Float64("tp", 0, "absolute take profit price")
+	relativeStopLoss := flags.Int64("rel-sl", 0, "relative stop loss in 1/100000 of price")
+	relativeTakeProfit := flags.Int64("rel-tp", 0, "relative take profit in 1/100000 of price")
+	expiry := flags.String("expiry", "", "expiration time for good till date orders")
+
+	var optional optionalFlags
+	if err := optional.parse(flags, args); err != nil {
+		return err
+	}
+
+	if *orderId == 0 {
+		return errors.New("-id is required")
+	}
+
+	req := &openapi.ProtoOAAmendOrderReq{
+		OrderId:            orderId,
+		Volume:             optional.int64Value("volume", volume),
+		LimitPrice:         optional.floatValue("price", limitPrice),
+		StopPrice:          optional.floatValue("stop-price", stopPrice),
+		StopLoss:           optional.floatValue("sl", stopLoss),
+		TakeProfit:         optional.floatValue("tp", takeProfit),
+		RelativeStopLoss:   optional.int64Value("rel-sl", relativeStopLoss),
+		RelativeTakeProfit: optional.int64Value("rel-tp", relativeTakeProfit),
+	}
+
+	if *expiry != "" {
+		expiration, err := parseTime(*expiry)
+		if err != nil {
+			return err
+		}
+		timestamp := toMillis(expiration)
+		req.ExpirationTimestamp = &timestamp
+	}
+
+	account, err := a.session()
+	if err != nil {
+		return err
+	}
+
+	res, err := account.AmendOrder(req)
+	if err != nil {
+		return err
+	}
+
+	return a.printer.print(executionTable(res), res)
+}
+
+func runOrderCancel(a *app, args []string) error {
+	flags := flag.NewFlagSet("order cancel", flag.ContinueOnError)
+	orderId := flags.Int64("id", 0, "order id")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *orderId == 0 {
+		return errors.New("-id is required")
+	}
+
+	account, err := a.session()
+	if err != nil {
+		return err
+	}
+
+	res, err := account.CancelOrder(*orderId)
+	if err != nil {
+		return err
+	}
+
+	return a.printer.print(executionTable(res), res)
+}
+
+func runPosition(a *app, args []string) error {
+	if len(args) == 0 || args[0] != "close" {
+		return errors.New("usage: ctrader position close -id <position id> [-volume <volume>]")
+	}
+
+	flags := flag.NewFlagSet("position close", flag.ContinueOnError)
+	positionId := flags.Int64("id", 0, "position id")
+	volume := flags.Int64("volume", 0, "volume in cents of units to close, the whole position when omitted")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	if *positionId == 0 {
+		return errors.New("-id is required")
+	}
+
+	account, err := a.session()
+	if err != nil {
+		return err
+	}
+
+	if *volume == 0 {
+		res, err := account.Reconcile()
+		if err != nil {
+			return err
+		}
+		for _, position := range res.Position {
+			if position.GetPositionId() == *positionId {
+				*volume = position.GetTradeData().GetVolume()
+			}
+		}
+		if *volume == 0 {
+			return errors.Errorf("position %d not found", *positionId)
+		}
+	}
+
+	res, err := account.ClosePosition(*positionId, *volume)
+	if err != nil {
+		return err
+	}
+
+	return a.printer.print(executionTable(res), res)
+}
+
+func executionTable(event *openapi.ProtoOAExecutionEvent) *table {
+	t := newTable("EXECUTION", "ORDER", "STATUS", "POSITION", "DEAL", "PRICE", "ERROR")
+
+	var orderId, positionId, dealId *int64
+	var price *float64
+	status := ""
+	if order := event.Order; order != nil {
+		orderId = order.OrderId
+		status = order.GetOrderStatus().String()
+	}
+	if position := event.Position; position != nil {
+		positionId = position.PositionId
+	}
+	if deal := event.Deal; deal != nil {
+		dealId = deal.DealId
+		price = deal.ExecutionPrice
+	}
+
+	t.add(event.GetExecutionType().String(), formatInt(orderId), status, formatInt(positionId), formatInt(dealId), formatFloat(price), formatString(event.ErrorCode))
+
+	return t
+}
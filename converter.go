@@ -0,0 +1,244 @@
+package ctrader
+
+import (
+	"fmt"
+	"github.com/shopspring/decimal"
+	"github.com/ty2/ctrader-go/proto/openapi"
+	"strings"
+	"sync"
+)
+
+// PriceSide selects the quote price used by a conversion.
+type PriceSide int
+
+const (
+	// MidPrice converts at the middle of bid and ask.
+	MidPrice PriceSide = iota
+	// BidPrice values the source asset at what selling it would receive.
+	BidPrice
+	// AskPrice values the source asset at what buying it would cost.
+	AskPrice
+)
+
+// MissingQuoteError is returned by conversions when a symbol of the path has no quote yet.
+type MissingQuoteError struct {
+	SymbolId int64
+}
+
+func (err *MissingQuoteError) Error() string {
+	return fmt.Sprintf("no quote for symbol %d", err.SymbolId)
+}
+
+// Converter converts amounts between assets along the symbol paths of SymbolsForConversion. Paths
+// are cached and their spots stay subscribed until Close.
+type Converter struct {
+	quotes          *QuoteCache
+	assetList       func() ([]*openapi.ProtoOAAsset, error)
+	conversionChain func(fromAssetId, toAssetId int64) ([]*openapi.ProtoOALightSymbol, error)
+	subscribe       func(symbolIds ...int64) (*Subscription, error)
+
+	mu            sync.Mutex
+	assets        []*openapi.ProtoOAAsset
+	paths         map[[2]int64][]*openapi.ProtoOALightSymbol
+	used          map[int64]bool
+	subscriptions []*Subscription
+}
+
+func newConverter(quotes *QuoteCache) *Converter {
+	return &Converter{
+		quotes: quotes,
+		paths:  map[[2]int64][]*openapi.ProtoOALightSymbol{},
+		used:   map[int64]bool{},
+	}
+}
+
+func newAccountConverter(account *Account, quotes *QuoteCache) *Converter {
+	converter := newConverter(quotes)
+	converter.assetList = func() ([]*openapi.ProtoOAAsset, error) {
+		res, err := account.AssetsList()
+		if err != nil {
+			return nil, err
+		}
+		return res.GetAsset(), nil
+	}
+	converter.conversionChain = func(fromAssetId, toAssetId int64) ([]*openapi.ProtoOALightSymbol, error) {
+		res, err := account.SymbolsForConversion(fromAssetId, toAssetId)
+		if err != nil {
+			return nil, err
+		}
+		return res.GetSymbol(), nil
+	}
+	converter.subscribe = account.subscriptions.Spots
+
+	return converter
+}
+
+// Converter returns a converter reading the account quote cache.
+func (account *Account) Converter() (*Converter, error) {
+	return newAccountConverter(account, account.quotes), nil
+}
+
+// Asset finds an asset by name, e.g. "USD", ignoring case.
+func (converter *Converter) Asset(name string) (*openapi.ProtoOAAsset, error) {
+	converter.mu.Lock()
+	assets := converter.assets
+	converter.mu.Unlock()
+
+	if assets == nil {
+		var err error
+		if assets, err = converter.assetList(); err != nil {
+			return nil, err
+		}
+		converter.mu.Lock()
+		converter.assets = assets
+		converter.mu.Unlock()
+	}
+
+	for _, asset := range assets {
+		if strings.EqualFold(asset.GetName(), name) {
+			return asset, nil
+		}
+	}
+
+	return nil, fmt.Errorf("asset %s not found", name)
+}
+
+// Path returns the symbols converting fromAssetId to toAssetId, loading and subscribing them on
+// first use. The path of an asset to itself is empty.
+func (converter *Converter) Path(fromAssetId, toAssetId int64) ([]*openapi.ProtoOALightSymbol, error) {
+	if fromAssetId == toAssetId {
+		return nil, nil
+	}
+
+	key := [2]int64{fromAssetId, toAssetId}
+	converter.mu.Lock()
+	path, ok := converter.paths[key]
+	converter.mu.Unlock()
+	if ok {
+		return path, nil
+	}
+
+	path, err := converter.conversionChain(fromAssetId, toAssetId)
+	if err != nil {
+		return nil, err
+	}
+	if len(path) == 0 {
+		return nil, fmt.Errorf("no conversion path from asset %d to %d", fromAssetId, toAssetId)
+	}
+
+	var subscription *Subscription
+	if converter.subscribe != nil {
+		symbolIds := make([]int64, len(path))
+		for i, symbol := range path {
+			symbolIds[i] = symbol.GetSymbolId()
+		}
+		if subscription, err = converter.subscribe(symbolIds...); err != nil {
+			return nil, err
+		}
+	}
+
+	converter.mu.Lock()
+	defer converter.mu.Unlock()
+	if cached, ok := converter.paths[key]; ok {
+		// loaded concurrently
+		if subscription != nil {
+			if err := subscription.Close(); err != nil {
+				logger.Warn(err.Error())
+			}
+		}
+		return cached, nil
+	}
+
+	converter.paths[key] = path
+	for _, symbol := range path {
+		converter.used[symbol.GetSymbolId()] = true
+	}
+	if subscription != nil {
+		converter.subscriptions = append(converter.subscriptions, subscription)
+	}
+
+	return path, nil
+}
+
+// Loaded reports whether the path from fromAssetId to toAssetId is loaded, after which Rate and
+// Convert only read the quote cache.
+func (converter *Converter) Loaded(fromAssetId, toAssetId int64) bool {
+	if fromAssetId == toAssetId {
+		return true
+	}
+
+	converter.mu.Lock()
+	defer converter.mu.Unlock()
+	_, ok := converter.paths[[2]int64{fromAssetId, toAssetId}]
+	return ok
+}
+
+// Uses reports whether symbolId is part of a loaded path.
+func (converter *Converter) Uses(symbolId int64) bool {
+	converter.mu.Lock()
+	defer converter.mu.Unlock()
+	return converter.used[symbolId]
+}
+
+// Rate is the value of one unit of fromAssetId in toAssetId. A symbol of the path without a quote
+// yields a *MissingQuoteError.
+func (converter *Converter) Rate(fromAssetId, toAssetId int64, side PriceSide) (decimal.Decimal, error) {
+	path, err := converter.Path(fromAssetId, toAssetId)
+	if err != nil {
+		return decimal.Zero, err
+	}
+
+	rate, assetId := decimal.NewFromInt(1), fromAssetId
+	for _, symbol := range path {
+		quote, ok := converter.quotes.Last(symbol.GetSymbolId())
+		if !ok || quote.Bid.IsZero() || quote.Ask.IsZero() {
+			return decimal.Zero, &MissingQuoteError{SymbolId: symbol.GetSymbolId()}
+		}
+
+		// selling the base asset receives the bid, buying it pays the ask
+		sell, buy := quote.Mid(), quote.Mid()
+		switch side {
+		case BidPrice:
+			sell, buy = quote.Bid, quote.Ask
+		case AskPrice:
+			sell, buy = quote.Ask, quote.Bid
+		}
+
+		switch assetId {
+		case symbol.GetBaseAssetId():
+			rate, assetId = rate.Mul(sell), symbol.GetQuoteAssetId()
+		case symbol.GetQuoteAssetId():
+			rate, assetId = rate.Div(buy), symbol.GetBaseAssetId()
+		default:
+			return decimal.Zero, fmt.Errorf("symbol %d does not continue the conversion path", symbol.GetSymbolId())
+		}
+	}
+
+	if assetId != toAssetId {
+		return decimal.Zero, fmt.Errorf("conversion path ends at asset %d instead of %d", assetId, toAssetId)
+	}
+
+	return rate, nil
+}
+
+// Convert expresses amount of fromAssetId in toAssetId.
+func (converter *Converter) Convert(amount decimal.Decimal, fromAssetId, toAssetId int64, side PriceSide) (decimal.Decimal, error) {
+	rate, err := converter.Rate(fromAssetId, toAssetId, side)
+	if err != nil {
+		return decimal.Zero, err
+	}
+
+	return amount.Mul(rate), nil
+}
+
+// Close releases the spot subscriptions of the loaded paths.
+func (converter *Converter) Close() {
+	converter.mu.Lock()
+	defer converter.mu.Unlock()
+	for _, subscription := range converter.subscriptions {
+		if err := subscription.Close(); err != nil {
+			logger.Warn(err.Error())
+		}
+	}
+	converter.subscriptions = nil
+}
@@ -0,0 +1,90 @@
+package ctrader
+
+import (
+	"errors"
+	"github.com/shopspring/decimal"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ty2/ctrader-go/proto/openapi"
+	"testing"
+)
+
+func TestConverter(t *testing.T) {
+	Convey("Converter", t, func() {
+		// assets: 1 USD, 2 EUR, 4 JPY
+		eurusd, usdjpy := testLightSymbol(20, 2, 1), testLightSymbol(21, 1, 4)
+		converter := newConverter(NewQuoteCache())
+		loads := 0
+		converter.conversionChain = func(fromAssetId, toAssetId int64) ([]*openapi.ProtoOALightSymbol, error) {
+			loads++
+			switch [2]int64{fromAssetId, toAssetId} {
+			case [2]int64{2, 4}:
+				return []*openapi.ProtoOALightSymbol{eurusd, usdjpy}, nil
+			case [2]int64{4, 2}:
+				return []*openapi.ProtoOALightSymbol{usdjpy, eurusd}, nil
+			}
+			return nil, nil
+		}
+		var subscribed []int64
+		released := 0
+		subscriptions := newSubscriptionManager(nil)
+		subscriptions.subscribeSpots = func(ids []int64) error {
+			subscribed = append(subscribed, ids...)
+			return nil
+		}
+		subscriptions.unsubscribeSpots = func(ids []int64) error {
+			released += len(ids)
+			return nil
+		}
+		converter.subscribe = subscriptions.Spots
+
+		Convey("reports missing quotes", func() {
+			converter.quotes.Apply(testSpot(20, 110000, 110020))
+			_, err := converter.Rate(2, 4, MidPrice)
+			var missing *MissingQuoteError
+			So(errors.As(err, &missing), ShouldBeTrue)
+			So(missing.SymbolId, ShouldEqual, 21)
+		})
+
+		Convey("converts along the path in both directions", func() {
+			converter.quotes.Apply(testSpot(20, 110000, 110020))
+			converter.quotes.Apply(testSpot(21, 15000000, 15010000))
+
+			amount, err := converter.Convert(decimal.NewFromInt(100), 2, 4, MidPrice)
+			So(err, ShouldBeNil)
+			// 100 * 1.1001 * 150.05
+			So(amount.StringFixed(4), ShouldEqual, "16507.0005")
+
+			rate, err := converter.Rate(2, 4, BidPrice)
+			So(err, ShouldBeNil)
+			So(rate.String(), ShouldEqual, "165")
+
+			rate, err = converter.Rate(4, 2, BidPrice)
+			So(err, ShouldBeNil)
+			// 1 / 150.1 / 1.1002
+			So(rate.StringFixed(8), ShouldEqual, "0.00605547")
+
+			So(loads, ShouldEqual, 2)
+			So(subscribed, ShouldResemble, []int64{20, 21})
+			So(converter.Uses(21), ShouldBeTrue)
+		})
+
+		Convey("needs no path for the same asset", func() {
+			rate, err := converter.Rate(1, 1, AskPrice)
+			So(err, ShouldBeNil)
+			So(rate.String(), ShouldEqual, "1")
+			So(loads, ShouldEqual, 0)
+		})
+
+		Convey("releases path subscriptions on close", func() {
+			_, err := converter.Path(2, 4)
+			So(err, ShouldBeNil)
+			converter.Close()
+			So(released, ShouldEqual, 2)
+		})
+
+		Convey("fails without a path", func() {
+			_, err := converter.Path(1, 9)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
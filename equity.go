@@ -0,0 +1,339 @@
+package ctrader
+
+import (
+	"errors"
+	"github.com/shopspring/decimal"
+	"github.com/ty2/ctrader-go/proto/openapi"
+	"github.com/vmware/transport-go/bus"
+	"github.com/vmware/transport-go/model"
+	"sort"
+	"sync"
+	"time"
+)
+
+const equityChannel = "equity"
+
+// PositionPnL is the unrealised result of one position in the deposit currency. ClosePrice is
+// the bid of a BUY position or the ask of a SELL position.
+type PositionPnL struct {
+	PositionId  int64
+	SymbolId    int64
+	ClosePrice  decimal.Decimal
+	GrossProfit decimal.Decimal
+	Swap        decimal.Decimal
+	Commission  decimal.Decimal
+	NetProfit   decimal.Decimal
+	UsedMargin  decimal.Decimal
+}
+
+// Equity is the account valuation in the deposit currency. MarginLevel is a percentage and zero
+// without used margin. Positions whose symbol or conversion symbols have no quote yet are left
+// out of the totals and their symbols are listed in Missing.
+type Equity struct {
+	Time        time.Time
+	Balance     decimal.Decimal
+	GrossProfit decimal.Decimal
+	NetProfit   decimal.Decimal
+	Equity      decimal.Decimal
+	UsedMargin  decimal.Decimal
+	FreeMargin  decimal.Decimal
+	MarginLevel decimal.Decimal
+	Positions   []PositionPnL
+	Missing     []int64
+}
+
+func (equity Equity) Complete() bool {
+	return len(equity.Missing) == 0
+}
+
+// EquityCalculator values the portfolio of an account with live quotes. Profits are converted
+// from the quote asset of each symbol to the deposit asset by a Converter at mid prices.
+type EquityCalculator struct {
+	portfolio   *Portfolio
+	converter   *Converter
+	quotes      *QuoteCache
+	eventBus    bus.EventBus
+	lightSymbol func(symbolId int64) (*openapi.ProtoOALightSymbol, error)
+	subscribe   func(symbolIds ...int64) (*Subscription, error)
+
+	mu            sync.Mutex
+	symbols       map[int64]*openapi.ProtoOALightSymbol
+	subscriptions map[int64]*Subscription
+	last          Equity
+
+	handlers []bus.MessageHandler
+}
+
+// newEquityCalculator shares the quote cache of converter for the position symbols.
+func newEquityCalculator(portfolio *Portfolio, converter *Converter) *EquityCalculator {
+	calculator := &EquityCalculator{
+		portfolio:     portfolio,
+		converter:     converter,
+		quotes:        converter.quotes,
+		eventBus:      bus.NewEventBusInstance(),
+		symbols:       map[int64]*openapi.ProtoOALightSymbol{},
+		subscriptions: map[int64]*Subscription{},
+	}
+	calculator.eventBus.GetChannelManager().CreateChannel(equityChannel)
+
+	return calculator
+}
+
+// EquityCalculator returns a calculator over the account portfolio. It subscribes to the spots of
+// the position symbols and their conversion paths and publishes a new Equity on every tick.
+func (account *Account) EquityCalculator() (*EquityCalculator, error) {
+	portfolio, err := account.Portfolio()
+	if err != nil {
+		return nil, err
+	}
+
+	calculator := newEquityCalculator(portfolio, newAccountConverter(account, account.quotes))
+	calculator.lightSymbol = account.symbols.Light
+	calculator.subscribe = account.subscriptions.Spots
+
+	quoteHandler, err := account.quotes.OnUpdate()
+	if err != nil {
+		return nil, err
+	}
+	quoteHandler.Handle(
+		func(msg *model.Message) {
+			if v, ok := msg.Payload.(*openapi.ProtoOASpotEvent); ok {
+				calculator.Recompute(v.GetSymbolId())
+			}
+		},
+		func(err error) {
+			logger.Warn(err.Error())
+		})
+	calculator.handlers = append(calculator.handlers, quoteHandler)
+
+	changeHandler, err := portfolio.OnChange()
+	if err != nil {
+		calculator.Close()
+		return nil, err
+	}
+	changeHandler.Handle(
+		func(msg *model.Message) {
+			if v, ok := msg.Payload.(PortfolioChange); ok {
+				if err := calculator.Refresh(v.Snapshot); err != nil {
+					logger.Warn(err.Error())
+				}
+			}
+		},
+		func(err error) {
+			logger.Warn(err.Error())
+		})
+	calculator.handlers = append(calculator.handlers, changeHandler)
+
+	if err := calculator.Refresh(portfolio.Snapshot()); err != nil {
+		calculator.Close()
+		return nil, err
+	}
+
+	return calculator, nil
+}
+
+// Refresh loads the symbols and conversion paths of the snapshot positions, keeps their spots
+// subscribed and publishes the new valuation.
+func (calculator *EquityCalculator) Refresh(snapshot PortfolioSnapshot) error {
+	depositAssetId := snapshot.Trader.GetDepositAssetId()
+	needed := map[int64]bool{}
+
+	for _, position := range snapshot.Positions {
+		symbolId := position.GetTradeData().GetSymbolId()
+		needed[symbolId] = true
+
+		symbol, err := calculator.symbol(symbolId)
+		if err != nil {
+			return err
+		}
+
+		if _, err := calculator.converter.Path(symbol.GetQuoteAssetId(), depositAssetId); err != nil {
+			return err
+		}
+	}
+
+	if err := calculator.subscribeOnly(needed); err != nil {
+		return err
+	}
+
+	calculator.publish(calculator.Compute(snapshot))
+	return nil
+}
+
+func (calculator *EquityCalculator) symbol(symbolId int64) (*openapi.ProtoOALightSymbol, error) {
+	calculator.mu.Lock()
+	symbol, ok := calculator.symbols[symbolId]
+	calculator.mu.Unlock()
+	if ok {
+		return symbol, nil
+	}
+
+	symbol, err := calculator.lightSymbol(symbolId)
+	if err != nil {
+		return nil, err
+	}
+
+	calculator.mu.Lock()
+	calculator.symbols[symbolId] = symbol
+	calculator.mu.Unlock()
+	return symbol, nil
+}
+
+// subscribeOnly holds spot subscriptions of the needed symbols and releases the others.
+func (calculator *EquityCalculator) subscribeOnly(needed map[int64]bool) error {
+	if calculator.subscribe == nil {
+		return nil
+	}
+
+	calculator.mu.Lock()
+	defer calculator.mu.Unlock()
+
+	for symbolId := range needed {
+		if _, ok := calculator.subscriptions[symbolId]; ok {
+			continue
+		}
+		subscription, err := calculator.subscribe(symbolId)
+		if err != nil {
+			return err
+		}
+		calculator.subscriptions[symbolId] = subscription
+	}
+
+	for symbolId, subscription := range calculator.subscriptions {
+		if needed[symbolId] {
+			continue
+		}
+		if err := subscription.Close(); err != nil {
+			logger.Warn(err.Error())
+		}
+		delete(calculator.subscriptions, symbolId)
+	}
+
+	return nil
+}
+
+// Recompute publishes a new valuation if symbolId, whose quote changed in the cache, is used.
+func (calculator *EquityCalculator) Recompute(symbolId int64) {
+	calculator.mu.Lock()
+	_, used := calculator.symbols[symbolId]
+	calculator.mu.Unlock()
+
+	if used || calculator.converter.Uses(symbolId) {
+		calculator.publish(calculator.Compute(calculator.portfolio.Snapshot()))
+	}
+}
+
+// Compute values a snapshot with the current quotes.
+func (calculator *EquityCalculator) Compute(snapshot PortfolioSnapshot) Equity {
+	trader := snapshot.Trader
+	depositAssetId := trader.GetDepositAssetId()
+	equity := Equity{
+		Time:    time.Now(),
+		Balance: MoneyToDecimal(trader.GetBalance(), moneyDigits(trader.MoneyDigits)),
+	}
+
+	missing := map[int64]bool{}
+	for _, position := range snapshot.Positions {
+		digits := moneyDigits(position.MoneyDigits)
+		usedMargin := MoneyToDecimal(int64(position.GetUsedMargin()), digits)
+		equity.UsedMargin = equity.UsedMargin.Add(usedMargin)
+
+		tradeData := position.GetTradeData()
+		quote, ok := calculator.quotes.Last(tradeData.GetSymbolId())
+		if !ok {
+			missing[tradeData.GetSymbolId()] = true
+			continue
+		}
+
+		calculator.mu.Lock()
+		symbol, ok := calculator.symbols[tradeData.GetSymbolId()]
+		calculator.mu.Unlock()
+		if !ok {
+			missing[tradeData.GetSymbolId()] = true
+			continue
+		}
+
+		rate, err := calculator.converter.Rate(symbol.GetQuoteAssetId(), depositAssetId, MidPrice)
+		if err != nil {
+			var missingQuote *MissingQuoteError
+			if errors.As(err, &missingQuote) {
+				missing[missingQuote.SymbolId] = true
+			} else {
+				missing[tradeData.GetSymbolId()] = true
+			}
+			continue
+		}
+
+		closePrice, difference := quote.Bid, quote.Bid.Sub(decimal.NewFromFloat(position.GetPrice()))
+		if tradeData.GetTradeSide() == openapi.ProtoOATradeSide_SELL {
+			closePrice, difference = quote.Ask, decimal.NewFromFloat(position.GetPrice()).Sub(quote.Ask)
+		}
+
+		pnl := PositionPnL{
+			PositionId:  position.GetPositionId(),
+			SymbolId:    tradeData.GetSymbolId(),
+			ClosePrice:  closePrice,
+			GrossProfit: difference.Mul(decimal.New(tradeData.GetVolume(), -2)).Mul(rate),
+			Swap:        MoneyToDecimal(position.GetSwap(), digits),
+			Commission:  MoneyToDecimal(position.GetCommission(), digits),
+			UsedMargin:  usedMargin,
+		}
+		// the position commission covers the opening deal; closing is charged the same again
+		pnl.NetProfit = pnl.GrossProfit.Add(pnl.Swap).Add(pnl.Commission.Mul(decimal.NewFromInt(2)))
+
+		equity.Positions = append(equity.Positions, pnl)
+		equity.GrossProfit = equity.GrossProfit.Add(pnl.GrossProfit)
+		equity.NetProfit = equity.NetProfit.Add(pnl.NetProfit)
+	}
+
+	for symbolId := range missing {
+		equity.Missing = append(equity.Missing, symbolId)
+	}
+	sort.Slice(equity.Missing, func(i, j int) bool { return equity.Missing[i] < equity.Missing[j] })
+
+	equity.Equity = equity.Balance.Add(equity.NetProfit)
+	equity.FreeMargin = equity.Equity.Sub(equity.UsedMargin)
+	if equity.UsedMargin.IsPositive() {
+		equity.MarginLevel = equity.Equity.Div(equity.UsedMargin).Mul(decimal.NewFromInt(100))
+	}
+
+	return equity
+}
+
+func (calculator *EquityCalculator) publish(equity Equity) {
+	calculator.mu.Lock()
+	calculator.last = equity
+	calculator.mu.Unlock()
+
+	if err := calculator.eventBus.SendBroadcastMessage(equityChannel, equity); err != nil {
+		logger.Warn(err.Error())
+	}
+}
+
+// Equity returns the last published valuation.
+func (calculator *EquityCalculator) Equity() Equity {
+	calculator.mu.Lock()
+	defer calculator.mu.Unlock()
+	return calculator.last
+}
+
+// OnUpdate delivers an Equity after every tick or portfolio change.
+func (calculator *EquityCalculator) OnUpdate() (bus.MessageHandler, error) {
+	return calculator.eventBus.ListenFirehose(equityChannel)
+}
+
+func (calculator *EquityCalculator) Close() {
+	for _, handler := range calculator.handlers {
+		handler.Close()
+	}
+	calculator.converter.Close()
+
+	calculator.mu.Lock()
+	defer calculator.mu.Unlock()
+	for symbolId, subscription := range calculator.subscriptions {
+		if err := subscription.Close(); err != nil {
+			logger.Warn(err.Error())
+		}
+		delete(calculator.subscriptions, symbolId)
+	}
+}
@@ -0,0 +1,91 @@
+package ctrader
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ty2/ctrader-go/proto/openapi"
+	"google.golang.org/protobuf/proto"
+	"testing"
+)
+
+func testLightSymbol(id, baseAssetId, quoteAssetId int64) *openapi.ProtoOALightSymbol {
+	return &openapi.ProtoOALightSymbol{SymbolId: proto.Int64(id), BaseAssetId: proto.Int64(baseAssetId), QuoteAssetId: proto.Int64(quoteAssetId)}
+}
+
+func testSpot(symbolId int64, bid, ask uint64) *openapi.ProtoOASpotEvent {
+	return &openapi.ProtoOASpotEvent{SymbolId: proto.Int64(symbolId), Bid: proto.Uint64(bid), Ask: proto.Uint64(ask)}
+}
+
+func TestEquityCalculator(t *testing.T) {
+	Convey("EquityCalculator", t, func() {
+		// assets: 1 USD (deposit), 2 EUR, 3 GBP, 4 JPY
+		symbols := map[int64]*openapi.ProtoOALightSymbol{
+			10: testLightSymbol(10, 2, 3), // EURGBP
+			11: testLightSymbol(11, 3, 1), // GBPUSD
+			12: testLightSymbol(12, 1, 4), // USDJPY
+		}
+		chains := map[[2]int64][]*openapi.ProtoOALightSymbol{
+			{3, 1}: {symbols[11]},
+			{4, 1}: {symbols[12]},
+		}
+
+		eurgbp := testPosition(1, 100000, openapi.ProtoOAPositionStatus_POSITION_STATUS_OPEN, 1)
+		eurgbp.TradeData.SymbolId = proto.Int64(10)
+		eurgbp.Price = proto.Float64(0.85)
+		eurgbp.Swap = proto.Int64(-100)
+		eurgbp.Commission = proto.Int64(-50)
+		eurgbp.UsedMargin = proto.Uint64(10000)
+
+		usdjpy := testPosition(2, 100000, openapi.ProtoOAPositionStatus_POSITION_STATUS_OPEN, 1)
+		usdjpy.TradeData.SymbolId = proto.Int64(12)
+		usdjpy.TradeData.TradeSide = openapi.ProtoOATradeSide_SELL.Enum()
+		usdjpy.Price = proto.Float64(160)
+
+		portfolio := NewPortfolio()
+		portfolio.Seed(&openapi.ProtoOATrader{Balance: proto.Int64(100000), DepositAssetId: proto.Int64(1)},
+			&openapi.ProtoOAReconcileRes{Position: []*openapi.ProtoOAPosition{eurgbp, usdjpy}})
+
+		converter := newConverter(NewQuoteCache())
+		converter.conversionChain = func(fromAssetId, toAssetId int64) ([]*openapi.ProtoOALightSymbol, error) {
+			return chains[[2]int64{fromAssetId, toAssetId}], nil
+		}
+		calculator := newEquityCalculator(portfolio, converter)
+		calculator.lightSymbol = func(symbolId int64) (*openapi.ProtoOALightSymbol, error) {
+			return symbols[symbolId], nil
+		}
+		So(calculator.Refresh(portfolio.Snapshot()), ShouldBeNil)
+		spot := func(event *openapi.ProtoOASpotEvent) {
+			converter.quotes.Apply(event)
+			calculator.Recompute(event.GetSymbolId())
+		}
+
+		Convey("lists symbols without quotes", func() {
+			spot(testSpot(10, 86000, 86020))
+			equity := calculator.Equity()
+			So(equity.Missing, ShouldResemble, []int64{11, 12})
+			So(equity.Equity.String(), ShouldEqual, "1000")
+			So(equity.UsedMargin.String(), ShouldEqual, "100")
+		})
+
+		Convey("converts profits to the deposit asset", func() {
+			spot(testSpot(10, 86000, 86020))
+			spot(testSpot(11, 125000, 125000))
+			spot(testSpot(12, 15000000, 15000000))
+
+			equity := calculator.Equity()
+			So(equity.Complete(), ShouldBeTrue)
+			So(len(equity.Positions), ShouldEqual, 2)
+
+			// (0.86 - 0.85) * 1000 GBP * 1.25
+			So(equity.Positions[0].ClosePrice.String(), ShouldEqual, "0.86")
+			So(equity.Positions[0].GrossProfit.String(), ShouldEqual, "12.5")
+			So(equity.Positions[0].NetProfit.String(), ShouldEqual, "10.5")
+			// (160 - 150) * 1000 JPY / 150
+			So(equity.Positions[1].GrossProfit.StringFixed(2), ShouldEqual, "66.67")
+
+			So(equity.NetProfit.StringFixed(2), ShouldEqual, "77.17")
+			So(equity.Equity.StringFixed(2), ShouldEqual, "1077.17")
+			So(equity.FreeMargin.StringFixed(2), ShouldEqual, "977.17")
+			So(equity.MarginLevel.StringFixed(2), ShouldEqual, "1077.17")
+		})
+	})
+}
@@ -0,0 +1,165 @@
+// Package export writes decoded market data and account history to CSV and Parquet.
+//
+// CSV cells hold ISO 8601 timestamps in UTC and exact decimals. Parquet files use TIMESTAMP_MILLIS
+// columns and DECIMAL columns with a fixed scale: 5 for market prices, 2 for volumes in units and 8
+// for money and deal prices. Money and deal prices are 16 byte DECIMAL(38, 8) columns, which hold
+// any Open API amount.
+package export
+
+import (
+	"encoding/csv"
+	"fmt"
+	"github.com/shopspring/decimal"
+	"github.com/xitongsys/parquet-go/writer"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type Format string
+
+const (
+	CSV     Format = "csv"
+	Parquet Format = "parquet"
+)
+
+const (
+	priceScale  = 5
+	volumeScale = 2
+	moneyScale  = 8
+)
+
+// moneyLength and moneyPrecision size the FIXED_LEN_BYTE_ARRAY money columns.
+const (
+	moneyLength    = 16
+	moneyPrecision = 38
+)
+
+var maxMoney = new(big.Int).Exp(big.NewInt(10), big.NewInt(moneyPrecision), nil)
+
+// TimeLayout is the CSV timestamp layout, RFC3339 with milliseconds.
+const TimeLayout = "2006-01-02T15:04:05.000Z07:00"
+
+// FormatFromPath picks the format from the file extension.
+func FormatFromPath(path string) (Format, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return CSV, nil
+	case ".parquet", ".pq":
+		return Parquet, nil
+	default:
+		return "", fmt.Errorf("unknown export format for %q, use .csv or .parquet", path)
+	}
+}
+
+// File creates path and calls fn with the format matching its extension, e.g.
+//
+//	export.File("bars.parquet", func(w io.Writer, format export.Format) error {
+//		return export.Bars(w, format, candles)
+//	})
+func File(path string, fn func(w io.Writer, format Format) error) error {
+	format, err := FormatFromPath(path)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := fn(f, format); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// row is implemented by the Parquet row structs so the same rows can be written as CSV.
+type row interface {
+	record() []string
+}
+
+func write(w io.Writer, format Format, header []string, schema interface{}, rows []row) error {
+	switch format {
+	case CSV:
+		writer := csv.NewWriter(w)
+		if err := writer.Write(header); err != nil {
+			return err
+		}
+		for _, v := range rows {
+			if err := writer.Write(v.record()); err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		return writer.Error()
+	case Parquet:
+		return writeParquet(w, schema, rows)
+	default:
+		return fmt.Errorf("unknown export format %q", format)
+	}
+}
+
+func writeParquet(w io.Writer, schema interface{}, rows []row) error {
+	pw, err := writer.NewParquetWriterFromWriter(w, schema, 1)
+	if err != nil {
+		return err
+	}
+
+	for _, v := range rows {
+		if err := pw.Write(v); err != nil {
+			return err
+		}
+	}
+
+	return pw.WriteStop()
+}
+
+func millis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func formatMillis(v int64) string {
+	if v == 0 {
+		return ""
+	}
+
+	return time.Unix(0, v*int64(time.Millisecond)).UTC().Format(TimeLayout)
+}
+
+// scaled converts d to an integer with scale decimal places for a Parquet DECIMAL column.
+func scaled(d decimal.Decimal, scale int32) int64 {
+	return d.Shift(scale).Round(0).IntPart()
+}
+
+func unscaled(v int64, scale int32) string {
+	return decimal.New(v, -scale).String()
+}
+
+// moneyBytes encodes d at moneyScale as the big-endian two's complement of a money column.
+func moneyBytes(d decimal.Decimal) (string, error) {
+	v := d.Shift(moneyScale).Round(0).BigInt()
+	if new(big.Int).Abs(v).Cmp(maxMoney) >= 0 {
+		return "", fmt.Errorf("%s is out of range for DECIMAL(%d, %d)", d, moneyPrecision, moneyScale)
+	}
+	if v.Sign() < 0 {
+		v.Add(v, new(big.Int).Lsh(big.NewInt(1), moneyLength*8))
+	}
+
+	b := make([]byte, moneyLength)
+	return string(v.FillBytes(b)), nil
+}
+
+func moneyDecimal(v string) decimal.Decimal {
+	i := new(big.Int).SetBytes([]byte(v))
+	if len(v) > 0 && v[0]&0x80 != 0 {
+		i.Sub(i, new(big.Int).Lsh(big.NewInt(1), uint(len(v))*8))
+	}
+
+	return decimal.NewFromBigInt(i, -moneyScale)
+}
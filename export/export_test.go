@@ -0,0 +1,128 @@
+package export
+
+import (
+	"bytes"
+	"github.com/shopspring/decimal"
+	. "github.com/smartystreets/goconvey/convey"
+	ctrader "github.com/ty2/ctrader-go"
+	"github.com/ty2/ctrader-go/proto/openapi"
+	"github.com/xitongsys/parquet-go-source/buffer"
+	"github.com/xitongsys/parquet-go/reader"
+	"io"
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExport(t *testing.T) {
+	candles := []ctrader.Candle{{
+		SymbolId: 1,
+		Period:   openapi.ProtoOATrendbarPeriod_H1,
+		Time:     time.Date(2022, 1, 3, 10, 0, 0, 0, time.UTC),
+		Open:     decimal.RequireFromString("1.13"),
+		High:     decimal.RequireFromString("1.13512"),
+		Low:      decimal.RequireFromString("1.12901"),
+		Close:    decimal.RequireFromString("1.1345"),
+		Volume:   1200,
+	}}
+
+	Convey("Bars to CSV", t, func() {
+		var buf bytes.Buffer
+		So(Bars(&buf, CSV, candles), ShouldBeNil)
+		So(buf.String(), ShouldEqual, "time,symbol_id,period,open,high,low,close,volume\n"+
+			"2022-01-03T10:00:00.000Z,1,H1,1.13,1.13512,1.12901,1.1345,1200\n")
+	})
+
+	Convey("Bars to Parquet", t, func() {
+		var buf bytes.Buffer
+		So(Bars(&buf, Parquet, candles), ShouldBeNil)
+
+		file, err := buffer.NewBufferFile(buf.Bytes())
+		So(err, ShouldBeNil)
+		pr, err := reader.NewParquetReader(file, new(barRow), 1)
+		So(err, ShouldBeNil)
+		So(pr.GetNumRows(), ShouldEqual, 1)
+
+		rows := make([]barRow, 1)
+		So(pr.Read(&rows), ShouldBeNil)
+		pr.ReadStop()
+		So(rows[0].High, ShouldEqual, 113512)
+		So(rows[0].Time, ShouldEqual, candles[0].Time.Unix()*1000)
+	})
+
+	Convey("Deals rescale money and keep missing values empty", t, func() {
+		dealId, volume, commission, profit, balance := int64(7), int64(100000), int64(-350), int64(1234567), int64(100000000)
+		moneyDigits, closeDigits := uint32(2), uint32(4)
+		price := 1.12345
+		deal := &openapi.ProtoOADeal{
+			DealId:         &dealId,
+			Volume:         &volume,
+			FilledVolume:   &volume,
+			ExecutionPrice: &price,
+			Commission:     &commission,
+			MoneyDigits:    &moneyDigits,
+			ClosePositionDetail: &openapi.ProtoOAClosePositionDetail{
+				GrossProfit: &profit,
+				Balance:     &balance,
+				MoneyDigits: &closeDigits,
+			},
+		}
+
+		var buf bytes.Buffer
+		So(Deals(&buf, CSV, []*openapi.ProtoOADeal{deal}), ShouldBeNil)
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		So(lines, ShouldHaveLength, 2)
+
+		values := map[string]string{}
+		for i, v := range strings.Split(lines[1], ",") {
+			values[dealHeader[i]] = v
+		}
+		So(values["volume"], ShouldEqual, "1000")
+		So(values["execution_price"], ShouldEqual, "1.12345")
+		So(values["commission"], ShouldEqual, "-3.5")
+		So(values["close_gross_profit"], ShouldEqual, "123.4567")
+		So(values["close_balance"], ShouldEqual, "10000")
+		So(values["close_swap"], ShouldEqual, "")
+		So(values["margin_rate"], ShouldEqual, "")
+
+		buf.Reset()
+		So(Deals(&buf, Parquet, []*openapi.ProtoOADeal{deal}), ShouldBeNil)
+		So(buf.Len(), ShouldBeGreaterThan, 0)
+	})
+
+	Convey("Money columns hold amounts beyond int64 at scale 8", t, func() {
+		delta, balance := int64(-50000000000000), int64(math.MaxInt64)
+		moneyDigits := uint32(2)
+		entry := &openapi.ProtoOADepositWithdraw{Delta: &delta, Balance: &balance, MoneyDigits: &moneyDigits}
+
+		var buf bytes.Buffer
+		So(CashFlow(&buf, Parquet, []*openapi.ProtoOADepositWithdraw{entry}), ShouldBeNil)
+
+		file, err := buffer.NewBufferFile(buf.Bytes())
+		So(err, ShouldBeNil)
+		pr, err := reader.NewParquetReader(file, new(cashFlowRow), 1)
+		So(err, ShouldBeNil)
+		rows := make([]cashFlowRow, 1)
+		So(pr.Read(&rows), ShouldBeNil)
+		pr.ReadStop()
+		So(moneyDecimal(rows[0].Delta).String(), ShouldEqual, "-500000000000")
+		So(moneyDecimal(rows[0].Balance).String(), ShouldEqual, "92233720368547758.07")
+		So(rows[0].Equity, ShouldBeNil)
+
+		_, err = moneyBytes(decimal.New(1, 30))
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("File picks the format from the extension", t, func() {
+		dir := t.TempDir()
+		So(File(filepath.Join(dir, "bars.csv"), func(w io.Writer, format Format) error {
+			So(format, ShouldEqual, CSV)
+			return Bars(w, format, candles)
+		}), ShouldBeNil)
+
+		_, err := FormatFromPath("bars.xlsx")
+		So(err, ShouldNotBeNil)
+	})
+}
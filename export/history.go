@@ -0,0 +1,220 @@
+package export
+
+import (
+	"fmt"
+	"github.com/shopspring/decimal"
+	ctrader "github.com/ty2/ctrader-go"
+	"github.com/ty2/ctrader-go/proto/openapi"
+	"io"
+	"strconv"
+)
+
+// defaultMoneyDigits applies when a message does not carry moneyDigits.
+const defaultMoneyDigits = 2
+
+type dealRow struct {
+	DealId                  int64    `parquet:"name=deal_id, type=INT64"`
+	OrderId                 int64    `parquet:"name=order_id, type=INT64"`
+	PositionId              int64    `parquet:"name=position_id, type=INT64"`
+	SymbolId                int64    `parquet:"name=symbol_id, type=INT64"`
+	CreateTime              int64    `parquet:"name=create_time, type=INT64, convertedtype=TIMESTAMP_MILLIS"`
+	ExecutionTime           int64    `parquet:"name=execution_time, type=INT64, convertedtype=TIMESTAMP_MILLIS"`
+	Side                    string   `parquet:"name=side, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Status                  string   `parquet:"name=status, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Volume                  int64    `parquet:"name=volume, type=INT64, convertedtype=DECIMAL, scale=2, precision=18"`
+	FilledVolume            int64    `parquet:"name=filled_volume, type=INT64, convertedtype=DECIMAL, scale=2, precision=18"`
+	ExecutionPrice          *string  `parquet:"name=execution_price, type=FIXED_LEN_BYTE_ARRAY, length=16, convertedtype=DECIMAL, scale=8, precision=38, repetitiontype=OPTIONAL"`
+	MarginRate              *float64 `parquet:"name=margin_rate, type=DOUBLE, repetitiontype=OPTIONAL"`
+	Commission              *string  `parquet:"name=commission, type=FIXED_LEN_BYTE_ARRAY, length=16, convertedtype=DECIMAL, scale=8, precision=38, repetitiontype=OPTIONAL"`
+	BaseToUsdRate           *float64 `parquet:"name=base_to_usd_rate, type=DOUBLE, repetitiontype=OPTIONAL"`
+	CloseEntryPrice         *string  `parquet:"name=close_entry_price, type=FIXED_LEN_BYTE_ARRAY, length=16, convertedtype=DECIMAL, scale=8, precision=38, repetitiontype=OPTIONAL"`
+	CloseGrossProfit        *string  `parquet:"name=close_gross_profit, type=FIXED_LEN_BYTE_ARRAY, length=16, convertedtype=DECIMAL, scale=8, precision=38, repetitiontype=OPTIONAL"`
+	CloseSwap               *string  `parquet:"name=close_swap, type=FIXED_LEN_BYTE_ARRAY, length=16, convertedtype=DECIMAL, scale=8, precision=38, repetitiontype=OPTIONAL"`
+	CloseCommission         *string  `parquet:"name=close_commission, type=FIXED_LEN_BYTE_ARRAY, length=16, convertedtype=DECIMAL, scale=8, precision=38, repetitiontype=OPTIONAL"`
+	ClosePnlConversionFee   *string  `parquet:"name=close_pnl_conversion_fee, type=FIXED_LEN_BYTE_ARRAY, length=16, convertedtype=DECIMAL, scale=8, precision=38, repetitiontype=OPTIONAL"`
+	CloseBalance            *string  `parquet:"name=close_balance, type=FIXED_LEN_BYTE_ARRAY, length=16, convertedtype=DECIMAL, scale=8, precision=38, repetitiontype=OPTIONAL"`
+	CloseQuoteToDepositRate *float64 `parquet:"name=close_quote_to_deposit_rate, type=DOUBLE, repetitiontype=OPTIONAL"`
+	ClosedVolume            *int64   `parquet:"name=closed_volume, type=INT64, convertedtype=DECIMAL, scale=2, precision=18, repetitiontype=OPTIONAL"`
+	BalanceVersion          *int64   `parquet:"name=balance_version, type=INT64, repetitiontype=OPTIONAL"`
+}
+
+var dealHeader = []string{"deal_id", "order_id", "position_id", "symbol_id", "create_time", "execution_time", "side", "status",
+	"volume", "filled_volume", "execution_price", "margin_rate", "commission", "base_to_usd_rate",
+	"close_entry_price", "close_gross_profit", "close_swap", "close_commission", "close_pnl_conversion_fee", "close_balance",
+	"close_quote_to_deposit_rate", "closed_volume", "balance_version"}
+
+func (v *dealRow) record() []string {
+	return []string{strconv.FormatInt(v.DealId, 10), strconv.FormatInt(v.OrderId, 10), strconv.FormatInt(v.PositionId, 10),
+		strconv.FormatInt(v.SymbolId, 10), formatMillis(v.CreateTime), formatMillis(v.ExecutionTime), v.Side, v.Status,
+		unscaled(v.Volume, volumeScale), unscaled(v.FilledVolume, volumeScale), formatMoney(v.ExecutionPrice), formatFloat(v.MarginRate),
+		formatMoney(v.Commission), formatFloat(v.BaseToUsdRate),
+		formatMoney(v.CloseEntryPrice), formatMoney(v.CloseGrossProfit), formatMoney(v.CloseSwap),
+		formatMoney(v.CloseCommission), formatMoney(v.ClosePnlConversionFee), formatMoney(v.CloseBalance),
+		formatFloat(v.CloseQuoteToDepositRate), formatScaled(v.ClosedVolume, volumeScale), formatInt(v.BalanceVersion)}
+}
+
+// Deals writes deals with their close position details; volumes are in units of the base asset
+// and money is rescaled from the deal moneyDigits.
+func Deals(w io.Writer, format Format, deals []*openapi.ProtoOADeal) error {
+	rows := make([]row, len(deals))
+	for i, v := range deals {
+		var encoder moneyEncoder
+		r := &dealRow{
+			DealId:         v.GetDealId(),
+			OrderId:        v.GetOrderId(),
+			PositionId:     v.GetPositionId(),
+			SymbolId:       v.GetSymbolId(),
+			CreateTime:     v.GetCreateTimestamp(),
+			ExecutionTime:  v.GetExecutionTimestamp(),
+			Side:           v.GetTradeSide().String(),
+			Status:         v.GetDealStatus().String(),
+			Volume:         v.GetVolume(),
+			FilledVolume:   v.GetFilledVolume(),
+			ExecutionPrice: encoder.price(v.ExecutionPrice),
+			MarginRate:     v.MarginRate,
+			Commission:     encoder.money(v.Commission, v.MoneyDigits),
+			BaseToUsdRate:  v.BaseToUsdConversionRate,
+		}
+
+		if detail := v.ClosePositionDetail; detail != nil {
+			r.CloseEntryPrice = encoder.price(detail.EntryPrice)
+			r.CloseGrossProfit = encoder.money(detail.GrossProfit, detail.MoneyDigits)
+			r.CloseSwap = encoder.money(detail.Swap, detail.MoneyDigits)
+			r.CloseCommission = encoder.money(detail.Commission, detail.MoneyDigits)
+			r.ClosePnlConversionFee = encoder.money(detail.PnlConversionFee, detail.MoneyDigits)
+			r.CloseBalance = encoder.money(detail.Balance, detail.MoneyDigits)
+			r.CloseQuoteToDepositRate = detail.QuoteToDepositConversionRate
+			r.ClosedVolume = detail.ClosedVolume
+			r.BalanceVersion = detail.BalanceVersion
+		}
+		if encoder.err != nil {
+			return fmt.Errorf("deal %d: %w", v.GetDealId(), encoder.err)
+		}
+
+		rows[i] = r
+	}
+
+	return write(w, format, dealHeader, new(dealRow), rows)
+}
+
+type cashFlowRow struct {
+	BalanceHistoryId int64   `parquet:"name=balance_history_id, type=INT64"`
+	Time             int64   `parquet:"name=time, type=INT64, convertedtype=TIMESTAMP_MILLIS"`
+	OperationType    string  `parquet:"name=operation_type, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Delta            string  `parquet:"name=delta, type=FIXED_LEN_BYTE_ARRAY, length=16, convertedtype=DECIMAL, scale=8, precision=38"`
+	Balance          string  `parquet:"name=balance, type=FIXED_LEN_BYTE_ARRAY, length=16, convertedtype=DECIMAL, scale=8, precision=38"`
+	Equity           *string `parquet:"name=equity, type=FIXED_LEN_BYTE_ARRAY, length=16, convertedtype=DECIMAL, scale=8, precision=38, repetitiontype=OPTIONAL"`
+	BalanceVersion   *int64  `parquet:"name=balance_version, type=INT64, repetitiontype=OPTIONAL"`
+	Note             *string `parquet:"name=note, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+}
+
+var cashFlowHeader = []string{"balance_history_id", "time", "operation_type", "delta", "balance", "equity", "balance_version", "note"}
+
+func (v *cashFlowRow) record() []string {
+	note := ""
+	if v.Note != nil {
+		note = *v.Note
+	}
+
+	return []string{strconv.FormatInt(v.BalanceHistoryId, 10), formatMillis(v.Time), v.OperationType,
+		moneyDecimal(v.Delta).String(), moneyDecimal(v.Balance).String(), formatMoney(v.Equity), formatInt(v.BalanceVersion), note}
+}
+
+// CashFlow writes deposit and withdrawal entries from CashFlowHistoryList.
+func CashFlow(w io.Writer, format Format, entries []*openapi.ProtoOADepositWithdraw) error {
+	rows := make([]row, len(entries))
+	for i, v := range entries {
+		var encoder moneyEncoder
+		r := &cashFlowRow{
+			BalanceHistoryId: v.GetBalanceHistoryId(),
+			Time:             v.GetChangeBalanceTimestamp(),
+			OperationType:    v.GetOperationType().String(),
+			Delta:            encoder.moneyValue(v.GetDelta(), v.MoneyDigits),
+			Balance:          encoder.moneyValue(v.GetBalance(), v.MoneyDigits),
+			Equity:           encoder.money(v.Equity, v.MoneyDigits),
+			BalanceVersion:   v.BalanceVersion,
+			Note:             v.ExternalNote,
+		}
+		if encoder.err != nil {
+			return fmt.Errorf("cash flow %d: %w", v.GetBalanceHistoryId(), encoder.err)
+		}
+
+		rows[i] = r
+	}
+
+	return write(w, format, cashFlowHeader, new(cashFlowRow), rows)
+}
+
+// moneyEncoder converts Open API amounts to money column values, keeping the first error.
+type moneyEncoder struct {
+	err error
+}
+
+// money rescales an optional Open API money value from its moneyDigits to moneyScale.
+func (encoder *moneyEncoder) money(v *int64, moneyDigits *uint32) *string {
+	if v == nil {
+		return nil
+	}
+
+	amount := encoder.moneyValue(*v, moneyDigits)
+	return &amount
+}
+
+func (encoder *moneyEncoder) moneyValue(v int64, moneyDigits *uint32) string {
+	digits := uint32(defaultMoneyDigits)
+	if moneyDigits != nil {
+		digits = *moneyDigits
+	}
+
+	return encoder.encode(ctrader.MoneyToDecimal(v, digits))
+}
+
+func (encoder *moneyEncoder) price(v *float64) *string {
+	if v == nil {
+		return nil
+	}
+
+	price := encoder.encode(decimal.NewFromFloat(*v))
+	return &price
+}
+
+func (encoder *moneyEncoder) encode(d decimal.Decimal) string {
+	b, err := moneyBytes(d)
+	if err != nil && encoder.err == nil {
+		encoder.err = err
+	}
+
+	return b
+}
+
+func formatInt(v *int64) string {
+	if v == nil {
+		return ""
+	}
+
+	return strconv.FormatInt(*v, 10)
+}
+
+func formatFloat(v *float64) string {
+	if v == nil {
+		return ""
+	}
+
+	return strconv.FormatFloat(*v, 'f', -1, 64)
+}
+
+func formatMoney(v *string) string {
+	if v == nil {
+		return ""
+	}
+
+	return moneyDecimal(*v).String()
+}
+
+func formatScaled(v *int64, scale int32) string {
+	if v == nil {
+		return ""
+	}
+
+	return unscaled(*v, scale)
+}
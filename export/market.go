@@ -0,0 +1,88 @@
+package export
+
+import (
+	ctrader "github.com/ty2/ctrader-go"
+	"io"
+	"strconv"
+)
+
+type barRow struct {
+	Time     int64  `parquet:"name=time, type=INT64, convertedtype=TIMESTAMP_MILLIS"`
+	SymbolId int64  `parquet:"name=symbol_id, type=INT64"`
+	Period   string `parquet:"name=period, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Open     int64  `parquet:"name=open, type=INT64, convertedtype=DECIMAL, scale=5, precision=18"`
+	High     int64  `parquet:"name=high, type=INT64, convertedtype=DECIMAL, scale=5, precision=18"`
+	Low      int64  `parquet:"name=low, type=INT64, convertedtype=DECIMAL, scale=5, precision=18"`
+	Close    int64  `parquet:"name=close, type=INT64, convertedtype=DECIMAL, scale=5, precision=18"`
+	Volume   int64  `parquet:"name=volume, type=INT64"`
+}
+
+var barHeader = []string{"time", "symbol_id", "period", "open", "high", "low", "close", "volume"}
+
+func (v *barRow) record() []string {
+	return []string{formatMillis(v.Time), strconv.FormatInt(v.SymbolId, 10), v.Period,
+		unscaled(v.Open, priceScale), unscaled(v.High, priceScale), unscaled(v.Low, priceScale), unscaled(v.Close, priceScale),
+		strconv.FormatInt(v.Volume, 10)}
+}
+
+// Bars writes candles; volume is the tick count of the bar.
+func Bars(w io.Writer, format Format, candles []ctrader.Candle) error {
+	rows := make([]row, len(candles))
+	for i, v := range candles {
+		rows[i] = &barRow{
+			Time:     millis(v.Time),
+			SymbolId: v.SymbolId,
+			Period:   v.Period.String(),
+			Open:     scaled(v.Open, priceScale),
+			High:     scaled(v.High, priceScale),
+			Low:      scaled(v.Low, priceScale),
+			Close:    scaled(v.Close, priceScale),
+			Volume:   v.Volume,
+		}
+	}
+
+	return write(w, format, barHeader, new(barRow), rows)
+}
+
+type tickRow struct {
+	Time     int64 `parquet:"name=time, type=INT64, convertedtype=TIMESTAMP_MILLIS"`
+	SymbolId int64 `parquet:"name=symbol_id, type=INT64"`
+	Price    int64 `parquet:"name=price, type=INT64, convertedtype=DECIMAL, scale=5, precision=18"`
+}
+
+var tickHeader = []string{"time", "symbol_id", "price"}
+
+func (v *tickRow) record() []string {
+	return []string{formatMillis(v.Time), strconv.FormatInt(v.SymbolId, 10), unscaled(v.Price, priceScale)}
+}
+
+func Ticks(w io.Writer, format Format, ticks []ctrader.Tick) error {
+	rows := make([]row, len(ticks))
+	for i, v := range ticks {
+		rows[i] = &tickRow{Time: millis(v.Time), SymbolId: v.SymbolId, Price: scaled(v.Price, priceScale)}
+	}
+
+	return write(w, format, tickHeader, new(tickRow), rows)
+}
+
+type quoteRow struct {
+	Time     int64 `parquet:"name=time, type=INT64, convertedtype=TIMESTAMP_MILLIS"`
+	SymbolId int64 `parquet:"name=symbol_id, type=INT64"`
+	Bid      int64 `parquet:"name=bid, type=INT64, convertedtype=DECIMAL, scale=5, precision=18"`
+	Ask      int64 `parquet:"name=ask, type=INT64, convertedtype=DECIMAL, scale=5, precision=18"`
+}
+
+var quoteHeader = []string{"time", "symbol_id", "bid", "ask"}
+
+func (v *quoteRow) record() []string {
+	return []string{formatMillis(v.Time), strconv.FormatInt(v.SymbolId, 10), unscaled(v.Bid, priceScale), unscaled(v.Ask, priceScale)}
+}
+
+func Quotes(w io.Writer, format Format, quotes []ctrader.Quote) error {
+	rows := make([]row, len(quotes))
+	for i, v := range quotes {
+		rows[i] = &quoteRow{Time: millis(v.Time), SymbolId: v.SymbolId, Bid: scaled(v.Bid, priceScale), Ask: scaled(v.Ask, priceScale)}
+	}
+
+	return write(w, format, quoteHeader, new(quoteRow), rows)
+}
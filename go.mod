@@ -3,10 +3,15 @@ module github.com/ty2/ctrader-go
 go 1.16
 
 require (
+	github.com/gdamore/tcell/v2 v2.4.0
+	github.com/golang/protobuf v1.5.2
 	github.com/google/uuid v1.3.0
 	github.com/pkg/errors v0.9.1
-	github.com/smartystreets/goconvey v1.7.2 // indirect
+	github.com/shopspring/decimal v1.3.1
+	github.com/smartystreets/goconvey v1.7.2
 	github.com/vmware/transport-go v1.3.4
+	github.com/xitongsys/parquet-go v1.6.2
+	github.com/xitongsys/parquet-go-source v0.0.0-20200817004010-026bad9b25d0
 	go.uber.org/zap v1.21.0
 	google.golang.org/protobuf v1.28.1
 )
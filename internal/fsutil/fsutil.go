@@ -0,0 +1,28 @@
+// Package fsutil holds the file helpers shared by the packages of the module.
+package fsutil
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// WriteFile replaces path at once through a temp file in the same directory, so a crash leaves
+// the old or the new content and concurrent writers never share a temp file.
+func WriteFile(path string, b []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
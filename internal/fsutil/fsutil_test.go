@@ -0,0 +1,31 @@
+package fsutil
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFile(t *testing.T) {
+	Convey("WriteFile", t, func() {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "file.json")
+
+		Convey("replaces the file and leaves no temp file", func() {
+			So(WriteFile(path, []byte("old")), ShouldBeNil)
+			So(WriteFile(path, []byte("new")), ShouldBeNil)
+
+			b, err := os.ReadFile(path)
+			So(err, ShouldBeNil)
+			So(string(b), ShouldEqual, "new")
+			entries, err := os.ReadDir(dir)
+			So(err, ShouldBeNil)
+			So(entries, ShouldHaveLength, 1)
+		})
+
+		Convey("fails without the directory", func() {
+			So(WriteFile(filepath.Join(dir, "missing", "file.json"), nil), ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,341 @@
+package ctrader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/shopspring/decimal"
+	"github.com/ty2/ctrader-go/proto/openapi"
+	"sort"
+	"sync"
+)
+
+// ExpectedMargin is the margin of a new position of Volume in the deposit currency, like
+// ProtoOAExpectedMargin.
+type ExpectedMargin struct {
+	Volume     int64
+	BuyMargin  decimal.Decimal
+	SellMargin decimal.Decimal
+}
+
+// MarginCalculator computes margin requirements locally from the trader leverage, the dynamic
+// leverage tiers of symbols and live conversion rates. Tier volumes are USD notionals per symbol
+// side; each tier applies to the part of the volume inside it, capped at the account leverage.
+// Buy margin is converted to the deposit asset at ask prices and sell margin at bid prices.
+type MarginCalculator struct {
+	converter       *Converter
+	trader          func() *openapi.ProtoOATrader
+	lightSymbol     func(symbolId int64) (*openapi.ProtoOALightSymbol, error)
+	fullSymbol      func(symbolId int64) (*openapi.ProtoOASymbol, error)
+	dynamicLeverage func(leverageId int64) (*openapi.ProtoOADynamicLeverage, error)
+
+	mu         sync.Mutex
+	usdAssetId int64
+	leverages  map[int64]*openapi.ProtoOADynamicLeverage
+}
+
+func newMarginCalculator(converter *Converter) *MarginCalculator {
+	return &MarginCalculator{
+		converter: converter,
+		leverages: map[int64]*openapi.ProtoOADynamicLeverage{},
+	}
+}
+
+// MarginCalculator returns a calculator using the account portfolio trader and a new converter.
+func (account *Account) MarginCalculator() (*MarginCalculator, error) {
+	portfolio, err := account.Portfolio()
+	if err != nil {
+		return nil, err
+	}
+
+	converter, err := account.Converter()
+	if err != nil {
+		return nil, err
+	}
+
+	calculator := newMarginCalculator(converter)
+	calculator.trader = portfolio.Trader
+	calculator.lightSymbol = account.symbols.Light
+	calculator.fullSymbol = account.symbols.Full
+	calculator.dynamicLeverage = func(leverageId int64) (*openapi.ProtoOADynamicLeverage, error) {
+		res, err := account.GetDynamicLeverageByID(leverageId)
+		if err != nil {
+			return nil, err
+		}
+		return res.GetLeverage(), nil
+	}
+
+	return calculator, nil
+}
+
+func (calculator *MarginCalculator) usd() (int64, error) {
+	calculator.mu.Lock()
+	usdAssetId := calculator.usdAssetId
+	calculator.mu.Unlock()
+	if usdAssetId != 0 {
+		return usdAssetId, nil
+	}
+
+	asset, err := calculator.converter.Asset("USD")
+	if err != nil {
+		return 0, err
+	}
+
+	calculator.mu.Lock()
+	calculator.usdAssetId = asset.GetAssetId()
+	calculator.mu.Unlock()
+	return asset.GetAssetId(), nil
+}
+
+// tiers returns the dynamic leverage tiers of a symbol sorted by volume, or nil without tiers.
+func (calculator *MarginCalculator) tiers(symbol *openapi.ProtoOASymbol) ([]*openapi.ProtoOADynamicLeverageTier, error) {
+	leverageId := symbol.GetLeverageId()
+	if leverageId == 0 {
+		return nil, nil
+	}
+
+	calculator.mu.Lock()
+	leverage, ok := calculator.leverages[leverageId]
+	calculator.mu.Unlock()
+	if !ok {
+		var err error
+		if leverage, err = calculator.dynamicLeverage(leverageId); err != nil {
+			return nil, err
+		}
+		calculator.mu.Lock()
+		calculator.leverages[leverageId] = leverage
+		calculator.mu.Unlock()
+	}
+
+	tiers := append([]*openapi.ProtoOADynamicLeverageTier{}, leverage.GetTiers()...)
+	sort.Slice(tiers, func(i, j int) bool { return tiers[i].GetVolume() < tiers[j].GetVolume() })
+	return tiers, nil
+}
+
+// WaitForQuotes loads the conversion paths of symbolIds and waits until all their quotes arrived.
+func (calculator *MarginCalculator) WaitForQuotes(ctx context.Context, symbolIds ...int64) error {
+	for _, symbolId := range symbolIds {
+		paths, err := calculator.paths(symbolId)
+		if err != nil {
+			return err
+		}
+
+		for _, path := range paths {
+			for _, symbol := range path {
+				if _, err := calculator.converter.quotes.WaitForQuote(ctx, symbol.GetSymbolId()); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func (calculator *MarginCalculator) paths(symbolId int64) ([][]*openapi.ProtoOALightSymbol, error) {
+	light, err := calculator.lightSymbol(symbolId)
+	if err != nil {
+		return nil, err
+	}
+
+	toDeposit, err := calculator.converter.Path(light.GetBaseAssetId(), calculator.trader().GetDepositAssetId())
+	if err != nil {
+		return nil, err
+	}
+
+	symbol, err := calculator.fullSymbol(symbolId)
+	if err != nil {
+		return nil, err
+	}
+	if symbol.GetLeverageId() == 0 {
+		return [][]*openapi.ProtoOALightSymbol{toDeposit}, nil
+	}
+
+	usdAssetId, err := calculator.usd()
+	if err != nil {
+		return nil, err
+	}
+	toUsd, err := calculator.converter.Path(light.GetBaseAssetId(), usdAssetId)
+	if err != nil {
+		return nil, err
+	}
+
+	return [][]*openapi.ProtoOALightSymbol{toDeposit, toUsd}, nil
+}
+
+// Margin is the margin of one side of volume on a symbol in the deposit currency.
+func (calculator *MarginCalculator) Margin(symbolId int64, side openapi.ProtoOATradeSide, volume int64) (decimal.Decimal, error) {
+	if volume <= 0 {
+		return decimal.Zero, nil
+	}
+
+	trader := calculator.trader()
+	accountLeverage := decimal.New(int64(trader.GetLeverageInCents()), -2)
+	if !accountLeverage.IsPositive() {
+		return decimal.Zero, errors.New("trader leverage is unknown")
+	}
+
+	light, err := calculator.lightSymbol(symbolId)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	symbol, err := calculator.fullSymbol(symbolId)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	tiers, err := calculator.tiers(symbol)
+	if err != nil {
+		return decimal.Zero, err
+	}
+
+	units := decimal.New(volume, -2)
+	margin := units.Div(accountLeverage)
+
+	if len(tiers) > 0 {
+		usdAssetId, err := calculator.usd()
+		if err != nil {
+			return decimal.Zero, err
+		}
+		usdRate, err := calculator.converter.Rate(light.GetBaseAssetId(), usdAssetId, MidPrice)
+		if err != nil {
+			return decimal.Zero, err
+		}
+
+		margin = decimal.Zero
+		remaining, tierStart := units, decimal.Zero
+		for i, tier := range tiers {
+			leverage := decimal.New(int64(tier.GetLeverage()), -2)
+			if !leverage.IsPositive() {
+				return decimal.Zero, fmt.Errorf("dynamic leverage %d has a tier without leverage", symbol.GetLeverageId())
+			}
+			if leverage.GreaterThan(accountLeverage) {
+				leverage = accountLeverage
+			}
+
+			portion := remaining
+			if i < len(tiers)-1 {
+				tierEnd := decimal.New(tier.GetVolume(), -2).Div(usdRate)
+				if size := tierEnd.Sub(tierStart); portion.GreaterThan(size) {
+					portion = size
+				}
+				tierStart = tierEnd
+			}
+
+			margin = margin.Add(portion.Div(leverage))
+			remaining = remaining.Sub(portion)
+			if !remaining.IsPositive() {
+				break
+			}
+		}
+	}
+
+	priceSide := AskPrice
+	if side == openapi.ProtoOATradeSide_SELL {
+		priceSide = BidPrice
+	}
+
+	return calculator.converter.Convert(margin, light.GetBaseAssetId(), trader.GetDepositAssetId(), priceSide)
+}
+
+// ExpectedMargins is the local counterpart of Account.ExpectedMargin.
+func (calculator *MarginCalculator) ExpectedMargins(symbolId int64, volumes []int64) ([]ExpectedMargin, error) {
+	margins := make([]ExpectedMargin, len(volumes))
+	for i, volume := range volumes {
+		buy, err := calculator.Margin(symbolId, openapi.ProtoOATradeSide_BUY, volume)
+		if err != nil {
+			return nil, err
+		}
+		sell, err := calculator.Margin(symbolId, openapi.ProtoOATradeSide_SELL, volume)
+		if err != nil {
+			return nil, err
+		}
+		margins[i] = ExpectedMargin{Volume: volume, BuyMargin: buy, SellMargin: sell}
+	}
+
+	return margins, nil
+}
+
+type symbolExposure struct {
+	buy  int64
+	sell int64
+}
+
+// TotalMargin is the margin of positions combined per symbol by the trader
+// TotalMarginCalculationType: the larger side (MAX), both sides (SUM) or the net volume (NET).
+func (calculator *MarginCalculator) TotalMargin(positions []*openapi.ProtoOAPosition) (decimal.Decimal, error) {
+	exposures := map[int64]*symbolExposure{}
+	var symbolIds []int64
+	for _, position := range positions {
+		tradeData := position.GetTradeData()
+		exposure, ok := exposures[tradeData.GetSymbolId()]
+		if !ok {
+			exposure = &symbolExposure{}
+			exposures[tradeData.GetSymbolId()] = exposure
+			symbolIds = append(symbolIds, tradeData.GetSymbolId())
+		}
+
+		if tradeData.GetTradeSide() == openapi.ProtoOATradeSide_SELL {
+			exposure.sell += tradeData.GetVolume()
+		} else {
+			exposure.buy += tradeData.GetVolume()
+		}
+	}
+
+	calculationType := calculator.trader().GetTotalMarginCalculationType()
+	total := decimal.Zero
+	for _, symbolId := range symbolIds {
+		exposure := exposures[symbolId]
+
+		if calculationType == openapi.ProtoOATotalMarginCalculationType_NET {
+			side, volume := openapi.ProtoOATradeSide_BUY, exposure.buy-exposure.sell
+			if volume < 0 {
+				side, volume = openapi.ProtoOATradeSide_SELL, -volume
+			}
+			margin, err := calculator.Margin(symbolId, side, volume)
+			if err != nil {
+				return decimal.Zero, err
+			}
+			total = total.Add(margin)
+			continue
+		}
+
+		buy, err := calculator.Margin(symbolId, openapi.ProtoOATradeSide_BUY, exposure.buy)
+		if err != nil {
+			return decimal.Zero, err
+		}
+		sell, err := calculator.Margin(symbolId, openapi.ProtoOATradeSide_SELL, exposure.sell)
+		if err != nil {
+			return decimal.Zero, err
+		}
+
+		if calculationType == openapi.ProtoOATotalMarginCalculationType_SUM {
+			total = total.Add(buy).Add(sell)
+		} else {
+			total = total.Add(decimal.Max(buy, sell))
+		}
+	}
+
+	return total, nil
+}
+
+// AdditionalMargin is how much the total margin of positions grows with a new position.
+func (calculator *MarginCalculator) AdditionalMargin(positions []*openapi.ProtoOAPosition, symbolId int64, side openapi.ProtoOATradeSide, volume int64) (decimal.Decimal, error) {
+	before, err := calculator.TotalMargin(positions)
+	if err != nil {
+		return decimal.Zero, err
+	}
+
+	position := &openapi.ProtoOAPosition{
+		TradeData: &openapi.ProtoOATradeData{SymbolId: &symbolId, TradeSide: &side, Volume: &volume},
+	}
+	after, err := calculator.TotalMargin(append(append([]*openapi.ProtoOAPosition{}, positions...), position))
+	if err != nil {
+		return decimal.Zero, err
+	}
+
+	return after.Sub(before), nil
+}
+
+func (calculator *MarginCalculator) Close() {
+	calculator.converter.Close()
+}
@@ -0,0 +1,125 @@
+package ctrader
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ty2/ctrader-go/proto/openapi"
+	"google.golang.org/protobuf/proto"
+	"testing"
+)
+
+func TestMarginCalculator(t *testing.T) {
+	Convey("MarginCalculator", t, func() {
+		// assets: 1 USD (deposit), 2 EUR; symbol 30 is EURUSD
+		eurusd := testLightSymbol(30, 2, 1)
+		symbol := &openapi.ProtoOASymbol{SymbolId: proto.Int64(30)}
+		trader := &openapi.ProtoOATrader{DepositAssetId: proto.Int64(1), LeverageInCents: proto.Uint32(10000)}
+
+		converter := newConverter(NewQuoteCache())
+		converter.assetList = func() ([]*openapi.ProtoOAAsset, error) {
+			return []*openapi.ProtoOAAsset{{AssetId: proto.Int64(1), Name: proto.String("USD")}}, nil
+		}
+		converter.conversionChain = func(fromAssetId, toAssetId int64) ([]*openapi.ProtoOALightSymbol, error) {
+			return []*openapi.ProtoOALightSymbol{eurusd}, nil
+		}
+
+		calculator := newMarginCalculator(converter)
+		calculator.trader = func() *openapi.ProtoOATrader { return trader }
+		calculator.lightSymbol = func(symbolId int64) (*openapi.ProtoOALightSymbol, error) { return eurusd, nil }
+		calculator.fullSymbol = func(symbolId int64) (*openapi.ProtoOASymbol, error) { return symbol, nil }
+		calculator.dynamicLeverage = func(leverageId int64) (*openapi.ProtoOADynamicLeverage, error) {
+			return &openapi.ProtoOADynamicLeverage{
+				LeverageId: proto.Int64(leverageId),
+				Tiers: []*openapi.ProtoOADynamicLeverageTier{
+					{Volume: proto.Int64(1000000000), Leverage: proto.Int32(5000)},
+					{Volume: proto.Int64(110000), Leverage: proto.Int32(20000)},
+				},
+			}, nil
+		}
+
+		Convey("uses the account leverage without tiers", func() {
+			converter.quotes.Apply(testSpot(30, 110000, 110020))
+			margins, err := calculator.ExpectedMargins(30, []int64{100000})
+			So(err, ShouldBeNil)
+			// 1000 EUR at 100:1 is 10 EUR
+			So(margins[0].BuyMargin.String(), ShouldEqual, "11.002")
+			So(margins[0].SellMargin.String(), ShouldEqual, "11")
+		})
+
+		Convey("applies dynamic leverage tiers to the volume inside them", func() {
+			converter.quotes.Apply(testSpot(30, 110000, 110000))
+			symbol.LeverageId = proto.Int64(7)
+			trader.LeverageInCents = proto.Uint32(50000)
+
+			// the first 1100 USD (1000 EUR) at 200:1, the other 2000 EUR at 50:1
+			margin, err := calculator.Margin(30, openapi.ProtoOATradeSide_BUY, 300000)
+			So(err, ShouldBeNil)
+			So(margin.String(), ShouldEqual, "49.5")
+
+			// capped at the account leverage of 100:1
+			trader.LeverageInCents = proto.Uint32(10000)
+			margin, err = calculator.Margin(30, openapi.ProtoOATradeSide_BUY, 300000)
+			So(err, ShouldBeNil)
+			So(margin.String(), ShouldEqual, "55")
+		})
+
+		Convey("splits expected margins at the tier edges", func() {
+			converter.quotes.Apply(testSpot(30, 110000, 110000))
+			symbol.LeverageId = proto.Int64(8)
+			trader.LeverageInCents = proto.Uint32(50000)
+			// up to 1100 USD at 200:1, up to 3300 USD at 100:1, the rest at 50:1
+			calculator.dynamicLeverage = func(leverageId int64) (*openapi.ProtoOADynamicLeverage, error) {
+				return &openapi.ProtoOADynamicLeverage{
+					LeverageId: proto.Int64(leverageId),
+					Tiers: []*openapi.ProtoOADynamicLeverageTier{
+						{Volume: proto.Int64(110000), Leverage: proto.Int32(20000)},
+						{Volume: proto.Int64(330000), Leverage: proto.Int32(10000)},
+						{Volume: proto.Int64(1000000000), Leverage: proto.Int32(5000)},
+					},
+				}, nil
+			}
+
+			margins, err := calculator.ExpectedMargins(30, []int64{0, 99900, 100000, 100100, 300000, 300100, 400000})
+			So(err, ShouldBeNil)
+			expected := []string{
+				"0",
+				"5.4945", // 999 EUR at 200:1
+				"5.5",    // the first tier exactly
+				"5.511",  // 1 EUR into the second tier
+				"27.5",   // 1000 EUR at 200:1 and 2000 EUR at 100:1
+				"27.522", // 1 EUR into the last tier
+				"49.5",   // and 1000 EUR at 50:1
+			}
+			for i, margin := range margins {
+				So(margin.BuyMargin.String(), ShouldEqual, expected[i])
+				So(margin.SellMargin.String(), ShouldEqual, expected[i])
+			}
+			So(margins[2].Volume, ShouldEqual, 100000)
+		})
+
+		Convey("combines sides by the total margin calculation type", func() {
+			converter.quotes.Apply(testSpot(30, 110000, 110000))
+			buy := testPosition(1, 100000, openapi.ProtoOAPositionStatus_POSITION_STATUS_OPEN, 1)
+			sell := testPosition(2, 40000, openapi.ProtoOAPositionStatus_POSITION_STATUS_OPEN, 1)
+			buy.TradeData.SymbolId, sell.TradeData.SymbolId = proto.Int64(30), proto.Int64(30)
+			sell.TradeData.TradeSide = openapi.ProtoOATradeSide_SELL.Enum()
+			positions := []*openapi.ProtoOAPosition{buy, sell}
+
+			for calculationType, expected := range map[openapi.ProtoOATotalMarginCalculationType]string{
+				openapi.ProtoOATotalMarginCalculationType_MAX: "11",
+				openapi.ProtoOATotalMarginCalculationType_SUM: "15.4",
+				openapi.ProtoOATotalMarginCalculationType_NET: "6.6",
+			} {
+				trader.TotalMarginCalculationType = calculationType.Enum()
+				total, err := calculator.TotalMargin(positions)
+				So(err, ShouldBeNil)
+				So(total.String(), ShouldEqual, expected)
+			}
+
+			trader.TotalMarginCalculationType = openapi.ProtoOATotalMarginCalculationType_MAX.Enum()
+			additional, err := calculator.AdditionalMargin(positions, 30, openapi.ProtoOATradeSide_SELL, 100000)
+			So(err, ShouldBeNil)
+			// the sell side grows to 1400 EUR and becomes the larger one
+			So(additional.String(), ShouldEqual, "4.4")
+		})
+	})
+}
@@ -0,0 +1,394 @@
+package ctrader
+
+import (
+	"fmt"
+	"github.com/shopspring/decimal"
+	"github.com/ty2/ctrader-go/proto/openapi"
+	"github.com/vmware/transport-go/bus"
+	"github.com/vmware/transport-go/model"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	marginAlertChannel  = "margin-alert"
+	marginDeRiskChannel = "margin-de-risk"
+)
+
+// DefaultDeRiskCooldown is how long a MarginMonitor waits after de-risking before it acts again,
+// so the closed volume shows in the margin level first.
+const DefaultDeRiskCooldown = 30 * time.Second
+
+// MarginAlert is published when the margin level falls below a threshold and, with Recovered set,
+// when it is back at or above it. Alerts triggered by the broker carry their MarginCallType.
+type MarginAlert struct {
+	Time           time.Time
+	Threshold      decimal.Decimal
+	Recovered      bool
+	Equity         Equity
+	MarginCallType openapi.ProtoOANotificationType
+}
+
+func (alert MarginAlert) Broker() bool {
+	return alert.MarginCallType != 0
+}
+
+// DeRiskOrder closes Volume of a position.
+type DeRiskOrder struct {
+	PositionId int64
+	SymbolId   int64
+	Volume     int64
+}
+
+// DeRiskPolicy picks the volume to close when the margin level is too low. Volumes are rounded down
+// to the symbol volume step; a volume that rounds below the minimum volume, or would leave less than
+// it open, closes the whole position.
+type DeRiskPolicy func(equity Equity, positions []*openapi.ProtoOAPosition) []DeRiskOrder
+
+// DeRiskResult is published after a policy ran; Errors holds the failed orders' errors.
+type DeRiskResult struct {
+	Time   time.Time
+	Equity Equity
+	Orders []DeRiskOrder
+	Errors []error
+}
+
+// CloseLargestLoser closes the position with the lowest negative net profit.
+func CloseLargestLoser() DeRiskPolicy {
+	return func(equity Equity, positions []*openapi.ProtoOAPosition) []DeRiskOrder {
+		var loser *PositionPnL
+		for i, pnl := range equity.Positions {
+			if pnl.NetProfit.IsNegative() && (loser == nil || pnl.NetProfit.LessThan(loser.NetProfit)) {
+				loser = &equity.Positions[i]
+			}
+		}
+		if loser == nil {
+			return nil
+		}
+
+		for _, position := range positions {
+			if position.GetPositionId() == loser.PositionId {
+				return []DeRiskOrder{{
+					PositionId: loser.PositionId,
+					SymbolId:   loser.SymbolId,
+					Volume:     position.GetTradeData().GetVolume(),
+				}}
+			}
+		}
+
+		return nil
+	}
+}
+
+// ReduceAll closes percent of every position. Positions too small to reduce by a step within the
+// minimum volume are closed in full.
+func ReduceAll(percent decimal.Decimal) DeRiskPolicy {
+	return func(equity Equity, positions []*openapi.ProtoOAPosition) []DeRiskOrder {
+		var orders []DeRiskOrder
+		for _, position := range positions {
+			volume := decimal.NewFromInt(position.GetTradeData().GetVolume()).Mul(percent).Div(decimal.NewFromInt(100)).IntPart()
+			if volume > 0 {
+				orders = append(orders, DeRiskOrder{
+					PositionId: position.GetPositionId(),
+					SymbolId:   position.GetTradeData().GetSymbolId(),
+					Volume:     volume,
+				})
+			}
+		}
+
+		return orders
+	}
+}
+
+type marginThreshold struct {
+	level decimal.Decimal
+	below bool
+}
+
+// MarginMonitor watches the margin level of an EquityCalculator, publishes MarginAlerts at user
+// thresholds and broker margin calls, and runs a DeRiskPolicy below the de-risk level. Levels
+// are percentages like ProtoOAMarginCall thresholds. Valuations with missing quotes are ignored.
+type MarginMonitor struct {
+	eventBus      bus.EventBus
+	portfolio     *Portfolio
+	calculator    *EquityCalculator
+	closePosition func(positionId, volume int64) error
+	symbolInfo    func(symbolId int64) (*SymbolInfo, error)
+	marginCalls   func() ([]*openapi.ProtoOAMarginCall, error)
+	setMarginCall func(marginCallType openapi.ProtoOANotificationType, threshold float64) error
+	now           func() time.Time
+
+	mu          sync.Mutex
+	thresholds  []*marginThreshold
+	deRiskLevel decimal.Decimal
+	policy      DeRiskPolicy
+	cooldown    time.Duration
+	deRisking   bool
+	deRiskedAt  time.Time
+	last        Equity
+
+	handlers []bus.MessageHandler
+}
+
+func newMarginMonitor(portfolio *Portfolio) *MarginMonitor {
+	monitor := &MarginMonitor{
+		eventBus:  bus.NewEventBusInstance(),
+		portfolio: portfolio,
+		now:       time.Now,
+		cooldown:  DefaultDeRiskCooldown,
+	}
+
+	cm := monitor.eventBus.GetChannelManager()
+	cm.CreateChannel(marginAlertChannel)
+	cm.CreateChannel(marginDeRiskChannel)
+
+	return monitor
+}
+
+// MarginMonitor returns a monitor over a new EquityCalculator of the account. De-risking closes
+// positions with ClosePosition and is off until SetDeRisk is called.
+func (account *Account) MarginMonitor() (*MarginMonitor, error) {
+	calculator, err := account.EquityCalculator()
+	if err != nil {
+		return nil, err
+	}
+
+	monitor := newMarginMonitor(calculator.portfolio)
+	monitor.calculator = calculator
+	monitor.closePosition = func(positionId, volume int64) error {
+		_, err := account.ClosePosition(positionId, volume)
+		return err
+	}
+	monitor.symbolInfo = account.symbols.Info
+	monitor.marginCalls = func() ([]*openapi.ProtoOAMarginCall, error) {
+		res, err := account.MarginCallList()
+		if err != nil {
+			return nil, err
+		}
+		return res.GetMarginCall(), nil
+	}
+	monitor.setMarginCall = func(marginCallType openapi.ProtoOANotificationType, threshold float64) error {
+		_, err := account.MarginCallUpdate(marginCallType, threshold)
+		return err
+	}
+
+	equityHandler, err := calculator.OnUpdate()
+	if err != nil {
+		monitor.Close()
+		return nil, err
+	}
+	equityHandler.Handle(
+		func(msg *model.Message) {
+			if v, ok := msg.Payload.(Equity); ok {
+				monitor.Update(v)
+			}
+		},
+		func(err error) {
+			logger.Warn(err.Error())
+		})
+	monitor.handlers = append(monitor.handlers, equityHandler)
+
+	marginCallHandler, err := account.OnMarginCallTrigger()
+	if err != nil {
+		monitor.Close()
+		return nil, err
+	}
+	marginCallHandler.Handle(
+		func(msg *model.Message) {
+			if v, ok := msg.Payload.(*openapi.ProtoOAMarginCallTriggerEvent); ok {
+				monitor.ApplyMarginCall(v)
+			}
+		},
+		func(err error) {
+			logger.Warn(err.Error())
+		})
+	monitor.handlers = append(monitor.handlers, marginCallHandler)
+
+	return monitor, nil
+}
+
+// AddAlert publishes a MarginAlert whenever the margin level crosses level.
+func (monitor *MarginMonitor) AddAlert(level decimal.Decimal) {
+	monitor.mu.Lock()
+	defer monitor.mu.Unlock()
+
+	for _, threshold := range monitor.thresholds {
+		if threshold.level.Equal(level) {
+			return
+		}
+	}
+	monitor.thresholds = append(monitor.thresholds, &marginThreshold{level: level})
+	sort.Slice(monitor.thresholds, func(i, j int) bool {
+		return monitor.thresholds[i].level.GreaterThan(monitor.thresholds[j].level)
+	})
+}
+
+// SetDeRisk runs policy whenever the margin level is below level; a nil policy turns it off.
+func (monitor *MarginMonitor) SetDeRisk(level decimal.Decimal, policy DeRiskPolicy) {
+	monitor.mu.Lock()
+	defer monitor.mu.Unlock()
+	monitor.deRiskLevel, monitor.policy = level, policy
+}
+
+func (monitor *MarginMonitor) SetDeRiskCooldown(cooldown time.Duration) {
+	monitor.mu.Lock()
+	defer monitor.mu.Unlock()
+	monitor.cooldown = cooldown
+}
+
+// BrokerMarginCalls lists the margin call thresholds configured on the account.
+func (monitor *MarginMonitor) BrokerMarginCalls() ([]*openapi.ProtoOAMarginCall, error) {
+	return monitor.marginCalls()
+}
+
+// SetBrokerMarginCall changes a margin call threshold of the account and alerts on it locally too.
+func (monitor *MarginMonitor) SetBrokerMarginCall(marginCallType openapi.ProtoOANotificationType, level decimal.Decimal) error {
+	threshold, _ := level.Float64()
+	if err := monitor.setMarginCall(marginCallType, threshold); err != nil {
+		return err
+	}
+
+	monitor.AddAlert(level)
+	return nil
+}
+
+// ApplyMarginCall publishes a broker margin call as a MarginAlert.
+func (monitor *MarginMonitor) ApplyMarginCall(event *openapi.ProtoOAMarginCallTriggerEvent) {
+	marginCall := event.GetMarginCall()
+	monitor.publish(marginAlertChannel, MarginAlert{
+		Time:           monitor.now(),
+		Threshold:      decimal.NewFromFloat(marginCall.GetMarginLevelThreshold()),
+		Equity:         monitor.Equity(),
+		MarginCallType: marginCall.GetMarginCallType(),
+	})
+}
+
+// Update checks a valuation against the alert thresholds and the de-risk level.
+func (monitor *MarginMonitor) Update(equity Equity) {
+	if !equity.Complete() {
+		return
+	}
+
+	monitor.mu.Lock()
+	monitor.last = equity
+
+	var alerts []MarginAlert
+	active := equity.UsedMargin.IsPositive()
+	for _, threshold := range monitor.thresholds {
+		below := active && equity.MarginLevel.LessThan(threshold.level)
+		if below != threshold.below {
+			threshold.below = below
+			alerts = append(alerts, MarginAlert{Time: monitor.now(), Threshold: threshold.level, Recovered: !below, Equity: equity})
+		}
+	}
+
+	policy := monitor.policy
+	deRisk := policy != nil && active && !monitor.deRisking &&
+		equity.MarginLevel.LessThan(monitor.deRiskLevel) &&
+		(monitor.deRiskedAt.IsZero() || monitor.now().Sub(monitor.deRiskedAt) >= monitor.cooldown)
+	if deRisk {
+		monitor.deRisking = true
+	}
+	monitor.mu.Unlock()
+
+	for _, alert := range alerts {
+		monitor.publish(marginAlertChannel, alert)
+	}
+
+	if deRisk {
+		// closing positions waits for the broker, which must not hold up the equity handler
+		go monitor.runDeRisk(equity, policy)
+	}
+}
+
+func (monitor *MarginMonitor) runDeRisk(equity Equity, policy DeRiskPolicy) {
+	result := monitor.deRisk(equity, policy)
+
+	monitor.mu.Lock()
+	monitor.deRisking, monitor.deRiskedAt = false, monitor.now()
+	monitor.mu.Unlock()
+
+	monitor.publish(marginDeRiskChannel, result)
+}
+
+func (monitor *MarginMonitor) deRisk(equity Equity, policy DeRiskPolicy) DeRiskResult {
+	result := DeRiskResult{Time: monitor.now(), Equity: equity}
+
+	positions := monitor.portfolio.Snapshot().Positions
+	open := make(map[int64]int64, len(positions))
+	for _, position := range positions {
+		open[position.GetPositionId()] = position.GetTradeData().GetVolume()
+	}
+
+	for _, order := range policy(equity, positions) {
+		info, err := monitor.symbolInfo(order.SymbolId)
+		if err != nil {
+			result.Errors = append(result.Errors, err)
+			continue
+		}
+
+		order.Volume = closeVolume(info, order.Volume, open[order.PositionId])
+		if order.Volume <= 0 {
+			continue
+		}
+
+		if err := monitor.closePosition(order.PositionId, order.Volume); err != nil {
+			result.Errors = append(result.Errors, fmt.Errorf("close position %d: %w", order.PositionId, err))
+			continue
+		}
+		result.Orders = append(result.Orders, order)
+	}
+
+	return result
+}
+
+// closeVolume rounds the volume to close of a position with open volume to the symbol volume step,
+// closing the whole position when the rounded volume or the remainder is below the minimum volume.
+func closeVolume(info *SymbolInfo, volume, open int64) int64 {
+	if volume <= 0 {
+		return 0
+	}
+	if volume >= open {
+		return open
+	}
+
+	volume = info.RoundVolume(volume)
+	if min := info.Symbol().GetMinVolume(); volume <= 0 || volume < min || open-volume < min {
+		return open
+	}
+
+	return volume
+}
+
+// Equity returns the last complete valuation.
+func (monitor *MarginMonitor) Equity() Equity {
+	monitor.mu.Lock()
+	defer monitor.mu.Unlock()
+	return monitor.last
+}
+
+func (monitor *MarginMonitor) publish(channel string, payload interface{}) {
+	if err := monitor.eventBus.SendBroadcastMessage(channel, payload); err != nil {
+		logger.Warn(err.Error())
+	}
+}
+
+// OnAlert delivers MarginAlerts.
+func (monitor *MarginMonitor) OnAlert() (bus.MessageHandler, error) {
+	return monitor.eventBus.ListenFirehose(marginAlertChannel)
+}
+
+// OnDeRisk delivers a DeRiskResult after every policy run.
+func (monitor *MarginMonitor) OnDeRisk() (bus.MessageHandler, error) {
+	return monitor.eventBus.ListenFirehose(marginDeRiskChannel)
+}
+
+func (monitor *MarginMonitor) Close() {
+	for _, handler := range monitor.handlers {
+		handler.Close()
+	}
+	if monitor.calculator != nil {
+		monitor.calculator.Close()
+	}
+}
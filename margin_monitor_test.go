@@ -0,0 +1,162 @@
+package ctrader
+
+import (
+	"errors"
+	"github.com/shopspring/decimal"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ty2/ctrader-go/proto/openapi"
+	"github.com/vmware/transport-go/model"
+	"google.golang.org/protobuf/proto"
+	"testing"
+	"time"
+)
+
+func testEquity(marginLevel int64, pnl ...PositionPnL) Equity {
+	return Equity{
+		UsedMargin:  decimal.NewFromInt(100),
+		MarginLevel: decimal.NewFromInt(marginLevel),
+		Positions:   pnl,
+	}
+}
+
+func TestMarginMonitor(t *testing.T) {
+	Convey("MarginMonitor", t, func() {
+		loser := testPosition(1, 100000, openapi.ProtoOAPositionStatus_POSITION_STATUS_OPEN, 1)
+		winner := testPosition(2, 30000, openapi.ProtoOAPositionStatus_POSITION_STATUS_OPEN, 1)
+		portfolio := NewPortfolio()
+		portfolio.Seed(&openapi.ProtoOATrader{}, &openapi.ProtoOAReconcileRes{Position: []*openapi.ProtoOAPosition{loser, winner}})
+		pnl := []PositionPnL{
+			{PositionId: 1, SymbolId: 1, NetProfit: decimal.NewFromInt(-50)},
+			{PositionId: 2, SymbolId: 1, NetProfit: decimal.NewFromInt(20)},
+		}
+
+		monitor := newMarginMonitor(portfolio)
+		now := time.Unix(0, 0)
+		monitor.now = func() time.Time { return now }
+		closed := map[int64]int64{}
+		monitor.closePosition = func(positionId, volume int64) error {
+			closed[positionId] += volume
+			return nil
+		}
+		symbol := testSymbol()
+		symbol.MinVolume, symbol.StepVolume = proto.Int64(10000), proto.Int64(1000)
+		monitor.symbolInfo = func(symbolId int64) (*SymbolInfo, error) {
+			return NewSymbolInfo(symbol, testLightSymbol(1, 2, 3)), nil
+		}
+
+		// closed is written by the de-risk goroutine and read once its result is delivered
+		results := make(chan DeRiskResult, 10)
+		deRiskHandler, err := monitor.OnDeRisk()
+		So(err, ShouldBeNil)
+		deRiskHandler.Handle(func(msg *model.Message) { results <- msg.Payload.(DeRiskResult) }, func(err error) {})
+		defer deRiskHandler.Close()
+		waitDeRisk := func() DeRiskResult {
+			select {
+			case result := <-results:
+				return result
+			case <-time.After(time.Second):
+				return DeRiskResult{Errors: []error{errors.New("no de-risk result")}}
+			}
+		}
+
+		alerts := make(chan MarginAlert, 10)
+		handler, err := monitor.OnAlert()
+		So(err, ShouldBeNil)
+		handler.Handle(func(msg *model.Message) { alerts <- msg.Payload.(MarginAlert) }, func(err error) {})
+		defer handler.Close()
+
+		Convey("alerts once per crossing", func() {
+			monitor.AddAlert(decimal.NewFromInt(200))
+			monitor.AddAlert(decimal.NewFromInt(100))
+
+			monitor.Update(testEquity(150))
+			monitor.Update(testEquity(140))
+			alert := <-alerts
+			So(alert.Threshold.String(), ShouldEqual, "200")
+			So(alert.Recovered, ShouldBeFalse)
+
+			monitor.Update(testEquity(250))
+			alert = <-alerts
+			So(alert.Recovered, ShouldBeTrue)
+			So(len(alerts), ShouldEqual, 0)
+		})
+
+		Convey("ignores incomplete valuations", func() {
+			monitor.AddAlert(decimal.NewFromInt(200))
+			equity := testEquity(50)
+			equity.Missing = []int64{3}
+			monitor.Update(equity)
+			So(len(alerts), ShouldEqual, 0)
+		})
+
+		Convey("closes the largest loser with a cooldown", func() {
+			monitor.SetDeRisk(decimal.NewFromInt(100), CloseLargestLoser())
+			monitor.Update(testEquity(90, pnl...))
+			So(waitDeRisk().Errors, ShouldBeEmpty)
+			So(closed, ShouldResemble, map[int64]int64{1: 100000})
+
+			monitor.Update(testEquity(80, pnl...))
+			So(monitor.deRisking, ShouldBeFalse)
+
+			now = now.Add(DefaultDeRiskCooldown)
+			monitor.Update(testEquity(80, pnl...))
+			So(waitDeRisk().Errors, ShouldBeEmpty)
+			So(closed[1], ShouldEqual, 200000)
+		})
+
+		Convey("rounds volumes to the step and closes positions below the minimum", func() {
+			monitor.SetDeRisk(decimal.NewFromInt(100), ReduceAll(decimal.NewFromInt(25)))
+			monitor.Update(testEquity(99, pnl...))
+			So(waitDeRisk().Orders, ShouldHaveLength, 2)
+			So(closed, ShouldResemble, map[int64]int64{1: 25000, 2: 30000})
+		})
+
+		Convey("closes positions whose remainder would be below the minimum", func() {
+			monitor.SetDeRisk(decimal.NewFromInt(100), ReduceAll(decimal.NewFromFloat(95.5)))
+			monitor.Update(testEquity(99, pnl...))
+			So(waitDeRisk().Orders, ShouldHaveLength, 2)
+			So(closed, ShouldResemble, map[int64]int64{1: 100000, 2: 30000})
+		})
+
+		Convey("does not hold up valuations while de-risking", func() {
+			release := make(chan struct{})
+			monitor.closePosition = func(positionId, volume int64) error {
+				<-release
+				closed[positionId] += volume
+				return nil
+			}
+			monitor.SetDeRisk(decimal.NewFromInt(100), CloseLargestLoser())
+
+			monitor.Update(testEquity(90, pnl...))
+			monitor.Update(testEquity(80, pnl...))
+			So(monitor.Equity().MarginLevel.String(), ShouldEqual, "80")
+
+			close(release)
+			So(waitDeRisk().Orders, ShouldHaveLength, 1)
+			So(closed, ShouldResemble, map[int64]int64{1: 100000})
+			So(len(results), ShouldEqual, 0)
+		})
+
+		Convey("reports failed orders", func() {
+			monitor.closePosition = func(positionId, volume int64) error {
+				return errors.New("market closed")
+			}
+
+			monitor.SetDeRisk(decimal.NewFromInt(100), CloseLargestLoser())
+			monitor.Update(testEquity(90, pnl...))
+			result := waitDeRisk()
+			So(result.Orders, ShouldBeEmpty)
+			So(len(result.Errors), ShouldEqual, 1)
+		})
+
+		Convey("publishes broker margin calls", func() {
+			monitor.ApplyMarginCall(&openapi.ProtoOAMarginCallTriggerEvent{MarginCall: &openapi.ProtoOAMarginCall{
+				MarginCallType:       openapi.ProtoOANotificationType_MARGIN_LEVEL_THRESHOLD_1.Enum(),
+				MarginLevelThreshold: proto.Float64(120),
+			}})
+			alert := <-alerts
+			So(alert.Broker(), ShouldBeTrue)
+			So(alert.Threshold.String(), ShouldEqual, "120")
+		})
+	})
+}
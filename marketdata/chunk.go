@@ -0,0 +1,165 @@
+package marketdata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/shopspring/decimal"
+	ctrader "github.com/ty2/ctrader-go"
+	"github.com/ty2/ctrader-go/internal/fsutil"
+	"github.com/ty2/ctrader-go/proto/openapi"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Chunk files hold fixed size little endian records behind a short header. Prices are stored as
+// raw Open API integers (1/100000 of a price unit) and times as unix milliseconds.
+var chunkMagic = []byte("CTMD\x01")
+
+var errBadChunk = errors.New("marketdata: corrupt chunk file")
+
+type barRecord struct {
+	Time   int64
+	Open   int64
+	High   int64
+	Low    int64
+	Close  int64
+	Volume int64
+}
+
+type tickRecord struct {
+	Time  int64
+	Price int64
+}
+
+func toRaw(price decimal.Decimal) int64 {
+	return price.Shift(ctrader.PriceScale).Round(0).IntPart()
+}
+
+func fromRaw(raw int64) decimal.Decimal {
+	return decimal.New(raw, -ctrader.PriceScale)
+}
+
+func toMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func fromMillis(v int64) time.Time {
+	return time.Unix(0, v*int64(time.Millisecond)).UTC()
+}
+
+func newBarRecord(candle ctrader.Candle) barRecord {
+	return barRecord{
+		Time:   toMillis(candle.Time),
+		Open:   toRaw(candle.Open),
+		High:   toRaw(candle.High),
+		Low:    toRaw(candle.Low),
+		Close:  toRaw(candle.Close),
+		Volume: candle.Volume,
+	}
+}
+
+func (record barRecord) candle(symbolId int64, period openapi.ProtoOATrendbarPeriod) ctrader.Candle {
+	return ctrader.Candle{
+		SymbolId: symbolId,
+		Period:   period,
+		Time:     fromMillis(record.Time),
+		Open:     fromRaw(record.Open),
+		High:     fromRaw(record.High),
+		Low:      fromRaw(record.Low),
+		Close:    fromRaw(record.Close),
+		Volume:   record.Volume,
+	}
+}
+
+func newTickRecord(tick ctrader.Tick) tickRecord {
+	return tickRecord{Time: toMillis(tick.Time), Price: toRaw(tick.Price)}
+}
+
+func (record tickRecord) tick(symbolId int64) ctrader.Tick {
+	return ctrader.Tick{SymbolId: symbolId, Time: fromMillis(record.Time), Price: fromRaw(record.Price)}
+}
+
+// readChunk decodes path into records, a *[]barRecord or *[]tickRecord of the given record size.
+// A missing file is an empty chunk.
+func readChunk(path string, records interface{}, size int) error {
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if !bytes.HasPrefix(b, chunkMagic) || (len(b)-len(chunkMagic))%size != 0 {
+		return errBadChunk
+	}
+
+	body := b[len(chunkMagic):]
+	switch v := records.(type) {
+	case *[]barRecord:
+		*v = make([]barRecord, len(body)/size)
+	case *[]tickRecord:
+		*v = make([]tickRecord, len(body)/size)
+	}
+
+	return binary.Read(bytes.NewReader(body), binary.LittleEndian, records)
+}
+
+// writeChunk replaces path atomically with the given records.
+func writeChunk(path string, records interface{}) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	buf.Write(chunkMagic)
+	if err := binary.Write(&buf, binary.LittleEndian, records); err != nil {
+		return err
+	}
+
+	return fsutil.WriteFile(path, buf.Bytes())
+}
+
+// mergeBars drops the records of chunk inside [from, to) and adds the new ones.
+func mergeBars(chunk []barRecord, from, to int64, records []barRecord) []barRecord {
+	merged := make([]barRecord, 0, len(chunk)+len(records))
+	for _, v := range chunk {
+		if v.Time < from || v.Time >= to {
+			merged = append(merged, v)
+		}
+	}
+	merged = append(merged, records...)
+	sort.SliceStable(merged, func(i, j int) bool { return merged[i].Time < merged[j].Time })
+
+	return merged
+}
+
+// mergeTicks is mergeBars for ticks. It also drops repeated prices within a millisecond, which a
+// download split inside the millisecond may return twice and which add nothing to the first tick.
+func mergeTicks(chunk []tickRecord, from, to int64, records []tickRecord) []tickRecord {
+	merged := make([]tickRecord, 0, len(chunk)+len(records))
+	for _, v := range chunk {
+		if v.Time < from || v.Time >= to {
+			merged = append(merged, v)
+		}
+	}
+	merged = append(merged, records...)
+	sort.SliceStable(merged, func(i, j int) bool { return merged[i].Time < merged[j].Time })
+
+	unique := merged[:0]
+	seen := map[tickRecord]bool{}
+	for _, v := range merged {
+		if len(unique) > 0 && unique[len(unique)-1].Time != v.Time {
+			seen = map[tickRecord]bool{}
+		}
+		if !seen[v] {
+			seen[v] = true
+			unique = append(unique, v)
+		}
+	}
+
+	return unique
+}
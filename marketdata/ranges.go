@@ -0,0 +1,72 @@
+package marketdata
+
+import (
+	"sort"
+	"time"
+)
+
+// Range is the half open interval [From, To).
+type Range struct {
+	From time.Time
+	To   time.Time
+}
+
+// Ranges is a sorted list of disjoint ranges.
+type Ranges []Range
+
+// Add returns ranges with r merged in; touching and overlapping ranges are joined.
+func (ranges Ranges) Add(r Range) Ranges {
+	if !r.From.Before(r.To) {
+		return ranges
+	}
+
+	all := append(append(Ranges{}, ranges...), r)
+	sort.Slice(all, func(i, j int) bool { return all[i].From.Before(all[j].From) })
+
+	merged := Ranges{all[0]}
+	for _, v := range all[1:] {
+		last := &merged[len(merged)-1]
+		if v.From.After(last.To) {
+			merged = append(merged, v)
+			continue
+		}
+		if v.To.After(last.To) {
+			last.To = v.To
+		}
+	}
+
+	return merged
+}
+
+// Missing returns the parts of [from, to) that are not covered.
+func (ranges Ranges) Missing(from, to time.Time) []Range {
+	var missing []Range
+	cursor := from
+	for _, v := range ranges {
+		if !v.To.After(cursor) {
+			continue
+		}
+		if !v.From.Before(to) {
+			break
+		}
+		if v.From.After(cursor) {
+			missing = append(missing, Range{From: cursor, To: v.From})
+		}
+		cursor = v.To
+	}
+
+	if cursor.Before(to) {
+		missing = append(missing, Range{From: cursor, To: to})
+	}
+
+	return missing
+}
+
+func (ranges Ranges) Covers(from, to time.Time) bool {
+	return len(ranges.Missing(from, to)) == 0
+}
+
+type rangeJSON struct {
+	From int64 `json:"from"`
+	To   int64 `json:"to"`
+}
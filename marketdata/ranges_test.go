@@ -0,0 +1,33 @@
+package marketdata
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+func TestRanges(t *testing.T) {
+	at := func(hour int) time.Time {
+		return time.Date(2021, 1, 1, hour, 0, 0, 0, time.UTC)
+	}
+
+	Convey("Ranges", t, func() {
+		var ranges Ranges
+		ranges = ranges.Add(Range{at(2), at(4)})
+		ranges = ranges.Add(Range{at(6), at(8)})
+
+		Convey("merges overlapping and touching ranges", func() {
+			So(ranges.Add(Range{at(4), at(6)}), ShouldResemble, Ranges{{at(2), at(8)}})
+			So(ranges.Add(Range{at(3), at(7)}), ShouldResemble, Ranges{{at(2), at(8)}})
+			So(ranges.Add(Range{at(9), at(10)}), ShouldHaveLength, 3)
+			So(ranges.Add(Range{at(5), at(5)}), ShouldResemble, ranges)
+		})
+
+		Convey("finds gaps", func() {
+			So(ranges.Missing(at(0), at(10)), ShouldResemble, []Range{{at(0), at(2)}, {at(4), at(6)}, {at(8), at(10)}})
+			So(ranges.Missing(at(3), at(7)), ShouldResemble, []Range{{at(4), at(6)}})
+			So(ranges.Covers(at(2), at(4)), ShouldBeTrue)
+			So(ranges.Covers(at(2), at(5)), ShouldBeFalse)
+		})
+	})
+}
@@ -0,0 +1,335 @@
+package marketdata
+
+import (
+	"context"
+	"encoding/json"
+	ctrader "github.com/ty2/ctrader-go"
+	"github.com/ty2/ctrader-go/internal/fsutil"
+	"github.com/ty2/ctrader-go/proto/openapi"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Source downloads history for the store; *ctrader.Account implements it.
+type Source interface {
+	DownloadBars(ctx context.Context, symbolId int64, period openapi.ProtoOATrendbarPeriod, from, to time.Time) (<-chan ctrader.Candle, <-chan error)
+	DownloadTicks(ctx context.Context, symbolId int64, quoteType openapi.ProtoOAQuoteType, from, to time.Time) (<-chan ctrader.Tick, <-chan error)
+}
+
+var _ Source = (*ctrader.Account)(nil)
+
+// Store keeps candles and ticks in a directory:
+//
+//	<dir>/<symbolId>/bars/<period>/2006-01.bin     candles by month
+//	<dir>/<symbolId>/ticks/<BID|ASK>/2006-01-02.bin  ticks by day
+//
+// next to a coverage.json per series listing the ranges already downloaded.
+type Store struct {
+	dir string
+	now func() time.Time
+
+	mu sync.Mutex
+}
+
+const (
+	barRecordSize  = 48
+	tickRecordSize = 16
+)
+
+func Open(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	return &Store{dir: dir, now: time.Now}, nil
+}
+
+func (store *Store) barDir(symbolId int64, period openapi.ProtoOATrendbarPeriod) string {
+	return filepath.Join(store.dir, strconv.FormatInt(symbolId, 10), "bars", period.String())
+}
+
+func (store *Store) tickDir(symbolId int64, quoteType openapi.ProtoOAQuoteType) string {
+	return filepath.Join(store.dir, strconv.FormatInt(symbolId, 10), "ticks", quoteType.String())
+}
+
+func monthStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+func dayStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+func barChunk(dir string, start time.Time) (string, time.Time) {
+	return filepath.Join(dir, start.Format("2006-01")+".bin"), start.AddDate(0, 1, 0)
+}
+
+func tickChunk(dir string, start time.Time) (string, time.Time) {
+	return filepath.Join(dir, start.Format("2006-01-02")+".bin"), start.AddDate(0, 0, 1)
+}
+
+// periodDuration is the length of a bar; months use their longest length.
+func periodDuration(period openapi.ProtoOATrendbarPeriod) time.Duration {
+	if d := ctrader.TrendbarPeriodDuration(period); d > 0 {
+		return d
+	}
+
+	return 31 * 24 * time.Hour
+}
+
+func readCoverage(dir string) (Ranges, error) {
+	b, err := os.ReadFile(filepath.Join(dir, "coverage.json"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var values []rangeJSON
+	if err := json.Unmarshal(b, &values); err != nil {
+		return nil, err
+	}
+
+	ranges := make(Ranges, len(values))
+	for i, v := range values {
+		ranges[i] = Range{From: fromMillis(v.From), To: fromMillis(v.To)}
+	}
+
+	return ranges, nil
+}
+
+func addCoverage(dir string, r Range) error {
+	ranges, err := readCoverage(dir)
+	if err != nil {
+		return err
+	}
+
+	ranges = ranges.Add(r)
+	values := make([]rangeJSON, len(ranges))
+	for i, v := range ranges {
+		values[i] = rangeJSON{From: toMillis(v.From), To: toMillis(v.To)}
+	}
+
+	b, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	return fsutil.WriteFile(filepath.Join(dir, "coverage.json"), b)
+}
+
+func (store *Store) BarCoverage(symbolId int64, period openapi.ProtoOATrendbarPeriod) (Ranges, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	return readCoverage(store.barDir(symbolId, period))
+}
+
+func (store *Store) TickCoverage(symbolId int64, quoteType openapi.ProtoOAQuoteType) (Ranges, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	return readCoverage(store.tickDir(symbolId, quoteType))
+}
+
+// Bars syncs [from, to) and returns the stored candles of the range.
+func (store *Store) Bars(ctx context.Context, source Source, symbolId int64, period openapi.ProtoOATrendbarPeriod, from, to time.Time) ([]ctrader.Candle, error) {
+	if err := store.SyncBars(ctx, source, symbolId, period, from, to); err != nil {
+		return nil, err
+	}
+
+	return store.ReadBars(symbolId, period, from, to)
+}
+
+// SyncBars downloads the parts of [from, to) that are not stored yet. Bars that have not closed
+// are neither stored nor counted as covered.
+func (store *Store) SyncBars(ctx context.Context, source Source, symbolId int64, period openapi.ProtoOATrendbarPeriod, from, to time.Time) error {
+	if closed := store.now().Add(-periodDuration(period)); to.After(closed) {
+		to = closed
+	}
+
+	coverage, err := store.BarCoverage(symbolId, period)
+	if err != nil {
+		return err
+	}
+
+	dir := store.barDir(symbolId, period)
+	for _, gap := range coverage.Missing(from, to) {
+		candles, errs := source.DownloadBars(ctx, symbolId, period, gap.From, gap.To)
+		var records []barRecord
+		for candle := range candles {
+			if !candle.Time.Before(gap.From) && candle.Time.Before(gap.To) {
+				records = append(records, newBarRecord(candle))
+			}
+		}
+		if err := <-errs; err != nil {
+			return err
+		}
+
+		if err := store.writeBars(dir, gap, records); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (store *Store) writeBars(dir string, gap Range, records []barRecord) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	from, to := toMillis(gap.From), toMillis(gap.To)
+	for start := monthStart(gap.From); start.Before(gap.To); {
+		path, end := barChunk(dir, start)
+		var inChunk []barRecord
+		for _, v := range records {
+			if v.Time >= toMillis(start) && v.Time < toMillis(end) {
+				inChunk = append(inChunk, v)
+			}
+		}
+
+		var chunk []barRecord
+		if err := readChunk(path, &chunk, barRecordSize); err != nil {
+			return err
+		}
+		if len(chunk) > 0 || len(inChunk) > 0 {
+			if err := writeChunk(path, mergeBars(chunk, from, to, inChunk)); err != nil {
+				return err
+			}
+		}
+
+		start = end
+	}
+
+	return addCoverage(dir, gap)
+}
+
+// ReadBars returns the stored candles of [from, to) without downloading.
+func (store *Store) ReadBars(symbolId int64, period openapi.ProtoOATrendbarPeriod, from, to time.Time) ([]ctrader.Candle, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	dir := store.barDir(symbolId, period)
+	var candles []ctrader.Candle
+	for start := monthStart(from); start.Before(to); {
+		path, end := barChunk(dir, start)
+		var chunk []barRecord
+		if err := readChunk(path, &chunk, barRecordSize); err != nil {
+			return nil, err
+		}
+		for _, v := range chunk {
+			if v.Time >= toMillis(from) && v.Time < toMillis(to) {
+				candles = append(candles, v.candle(symbolId, period))
+			}
+		}
+
+		start = end
+	}
+
+	return candles, nil
+}
+
+// Ticks syncs [from, to) and returns the stored ticks of the range.
+func (store *Store) Ticks(ctx context.Context, source Source, symbolId int64, quoteType openapi.ProtoOAQuoteType, from, to time.Time) ([]ctrader.Tick, error) {
+	if err := store.SyncTicks(ctx, source, symbolId, quoteType, from, to); err != nil {
+		return nil, err
+	}
+
+	return store.ReadTicks(symbolId, quoteType, from, to)
+}
+
+// SyncTicks downloads the parts of [from, to) that are not stored yet.
+func (store *Store) SyncTicks(ctx context.Context, source Source, symbolId int64, quoteType openapi.ProtoOAQuoteType, from, to time.Time) error {
+	if now := store.now(); to.After(now) {
+		to = now
+	}
+
+	coverage, err := store.TickCoverage(symbolId, quoteType)
+	if err != nil {
+		return err
+	}
+
+	dir := store.tickDir(symbolId, quoteType)
+	for _, gap := range coverage.Missing(from, to) {
+		// DownloadTicks includes its end
+		ticks, errs := source.DownloadTicks(ctx, symbolId, quoteType, gap.From, gap.To.Add(-time.Millisecond))
+		var records []tickRecord
+		for tick := range ticks {
+			if !tick.Time.Before(gap.From) && tick.Time.Before(gap.To) {
+				records = append(records, newTickRecord(tick))
+			}
+		}
+		if err := <-errs; err != nil {
+			return err
+		}
+
+		if err := store.writeTicks(dir, gap, records); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (store *Store) writeTicks(dir string, gap Range, records []tickRecord) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	from, to := toMillis(gap.From), toMillis(gap.To)
+	for start := dayStart(gap.From); start.Before(gap.To); {
+		path, end := tickChunk(dir, start)
+		var inChunk []tickRecord
+		for _, v := range records {
+			if v.Time >= toMillis(start) && v.Time < toMillis(end) {
+				inChunk = append(inChunk, v)
+			}
+		}
+
+		var chunk []tickRecord
+		if err := readChunk(path, &chunk, tickRecordSize); err != nil {
+			return err
+		}
+		if len(chunk) > 0 || len(inChunk) > 0 {
+			if err := writeChunk(path, mergeTicks(chunk, from, to, inChunk)); err != nil {
+				return err
+			}
+		}
+
+		start = end
+	}
+
+	return addCoverage(dir, gap)
+}
+
+// ReadTicks returns the stored ticks of [from, to) without downloading.
+func (store *Store) ReadTicks(symbolId int64, quoteType openapi.ProtoOAQuoteType, from, to time.Time) ([]ctrader.Tick, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	dir := store.tickDir(symbolId, quoteType)
+	var ticks []ctrader.Tick
+	for start := dayStart(from); start.Before(to); {
+		path, end := tickChunk(dir, start)
+		var chunk []tickRecord
+		if err := readChunk(path, &chunk, tickRecordSize); err != nil {
+			return nil, err
+		}
+		for _, v := range chunk {
+			if v.Time >= toMillis(from) && v.Time < toMillis(to) {
+				ticks = append(ticks, v.tick(symbolId))
+			}
+		}
+
+		start = end
+	}
+
+	return ticks, nil
+}
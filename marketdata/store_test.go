@@ -0,0 +1,110 @@
+package marketdata
+
+import (
+	"context"
+	"github.com/shopspring/decimal"
+	. "github.com/smartystreets/goconvey/convey"
+	ctrader "github.com/ty2/ctrader-go"
+	"github.com/ty2/ctrader-go/proto/openapi"
+	"testing"
+	"time"
+)
+
+// fakeSource has a bar every minute and a tick every second, and records the requested ranges.
+type fakeSource struct {
+	requests []Range
+}
+
+func (source *fakeSource) DownloadBars(ctx context.Context, symbolId int64, period openapi.ProtoOATrendbarPeriod, from, to time.Time) (<-chan ctrader.Candle, <-chan error) {
+	source.requests = append(source.requests, Range{from, to})
+	candles, errs := make(chan ctrader.Candle, 10000), make(chan error)
+	for t := from; t.Before(to); t = t.Add(time.Minute) {
+		price := decimal.New(t.Unix()%1000+100000, -5)
+		candles <- ctrader.Candle{SymbolId: symbolId, Period: period, Time: t, Open: price, High: price, Low: price, Close: price, Volume: 1}
+	}
+	close(candles)
+	close(errs)
+	return candles, errs
+}
+
+func (source *fakeSource) DownloadTicks(ctx context.Context, symbolId int64, quoteType openapi.ProtoOAQuoteType, from, to time.Time) (<-chan ctrader.Tick, <-chan error) {
+	source.requests = append(source.requests, Range{from, to})
+	ticks, errs := make(chan ctrader.Tick, 100000), make(chan error)
+	for t := from; !t.After(to); t = t.Add(time.Second) {
+		ticks <- ctrader.Tick{SymbolId: symbolId, Time: t, Price: decimal.New(112345, -5)}
+	}
+	close(ticks)
+	close(errs)
+	return ticks, errs
+}
+
+func TestStore(t *testing.T) {
+	Convey("Store", t, func() {
+		store, err := Open(t.TempDir())
+		So(err, ShouldBeNil)
+		store.now = func() time.Time { return time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC) }
+
+		source := &fakeSource{}
+		ctx := context.Background()
+		period := openapi.ProtoOATrendbarPeriod_M1
+		at := func(day, hour int) time.Time {
+			return time.Date(2021, 1, day, hour, 0, 0, 0, time.UTC)
+		}
+
+		Convey("downloads bars once and fills gaps", func() {
+			candles, err := store.Bars(ctx, source, 1, period, at(31, 22), at(32, 2))
+			So(err, ShouldBeNil)
+			So(candles, ShouldHaveLength, 4*60)
+			So(candles[0].Time, ShouldEqual, at(31, 22))
+			So(candles[len(candles)-1].Time, ShouldEqual, at(32, 2).Add(-time.Minute))
+			So(candles[1].Close.Equal(decimal.New(at(31, 22).Unix()%1000+100060, -5)), ShouldBeTrue)
+
+			candles, err = store.Bars(ctx, source, 1, period, at(31, 20), at(32, 3))
+			So(err, ShouldBeNil)
+			So(candles, ShouldHaveLength, 7*60)
+			So(source.requests, ShouldResemble, []Range{{at(31, 22), at(32, 2)}, {at(31, 20), at(31, 22)}, {at(32, 2), at(32, 3)}})
+
+			coverage, err := store.BarCoverage(1, period)
+			So(err, ShouldBeNil)
+			So(coverage, ShouldResemble, Ranges{{at(31, 20), at(32, 3)}})
+
+			reopened, err := Open(store.dir)
+			So(err, ShouldBeNil)
+			candles, err = reopened.ReadBars(1, period, at(31, 20), at(32, 3))
+			So(err, ShouldBeNil)
+			So(candles, ShouldHaveLength, 7*60)
+		})
+
+		Convey("does not store bars that have not closed", func() {
+			store.now = func() time.Time { return at(2, 0).Add(30 * time.Second) }
+			candles, err := store.Bars(ctx, source, 1, period, at(1, 23), at(2, 1))
+			So(err, ShouldBeNil)
+			So(candles, ShouldHaveLength, 60)
+
+			coverage, err := store.BarCoverage(1, period)
+			So(err, ShouldBeNil)
+			So(coverage[0].To, ShouldEqual, at(2, 0).Add(-30*time.Second))
+		})
+
+		Convey("stores ticks by day", func() {
+			ticks, err := store.Ticks(ctx, source, 1, openapi.ProtoOAQuoteType_BID, at(1, 23), at(2, 1))
+			So(err, ShouldBeNil)
+			So(ticks, ShouldHaveLength, 2*3600)
+			So(ticks[0].Price.String(), ShouldEqual, "1.12345")
+
+			_, err = store.Ticks(ctx, source, 1, openapi.ProtoOAQuoteType_BID, at(1, 23), at(2, 1))
+			So(err, ShouldBeNil)
+			So(source.requests, ShouldHaveLength, 1)
+		})
+	})
+}
+
+func TestMergeTicks(t *testing.T) {
+	Convey("mergeTicks replaces the range and drops repeated ticks of a millisecond", t, func() {
+		chunk := []tickRecord{{Time: 1, Price: 10}, {Time: 5, Price: 11}, {Time: 9, Price: 12}}
+		records := []tickRecord{{Time: 5, Price: 2}, {Time: 5, Price: 3}, {Time: 5, Price: 1}, {Time: 5, Price: 2}, {Time: 5, Price: 3}, {Time: 6, Price: 2}}
+		So(mergeTicks(chunk, 5, 9, records), ShouldResemble, []tickRecord{
+			{Time: 1, Price: 10}, {Time: 5, Price: 2}, {Time: 5, Price: 3}, {Time: 5, Price: 1}, {Time: 6, Price: 2}, {Time: 9, Price: 12},
+		})
+	})
+}
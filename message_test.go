@@ -0,0 +1,42 @@
+package ctrader
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ty2/ctrader-go/proto/openapi"
+	"github.com/vmware/transport-go/model"
+	"google.golang.org/protobuf/proto"
+	"testing"
+	"time"
+)
+
+func TestHandleMessage(t *testing.T) {
+	Convey("handleMessage", t, func() {
+		client := NewClient(NewConn(""), "", "", "")
+
+		Convey("decodes trader updates", func() {
+			handler, err := client.On(openapi.ProtoOAPayloadType_PROTO_OA_TRADER_UPDATE_EVENT)
+			So(err, ShouldBeNil)
+			defer handler.Close()
+			payloads := make(chan interface{}, 1)
+			handler.Handle(func(msg *model.Message) { payloads <- msg.Payload }, func(err error) {})
+
+			event := &openapi.ProtoOATraderUpdatedEvent{
+				CtidTraderAccountId: proto.Int64(1),
+				Trader:              &openapi.ProtoOATrader{CtidTraderAccountId: proto.Int64(1), Balance: proto.Int64(100000), DepositAssetId: proto.Int64(1)},
+			}
+			_, message := RequestMessageToProtoMessage(uint32(openapi.ProtoOAPayloadType_PROTO_OA_TRADER_UPDATE_EVENT), event, nil)
+			b, err := proto.Marshal(message)
+			So(err, ShouldBeNil)
+			So(client.handleMessage(b), ShouldBeNil)
+
+			select {
+			case payload := <-payloads:
+				update, ok := payload.(*openapi.ProtoOATraderUpdatedEvent)
+				So(ok, ShouldBeTrue)
+				So(update.GetTrader().GetBalance(), ShouldEqual, 100000)
+			case <-time.After(time.Second):
+				So("no trader update", ShouldBeEmpty)
+			}
+		})
+	})
+}
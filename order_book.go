@@ -0,0 +1,251 @@
+package ctrader
+
+import (
+	"errors"
+	"github.com/shopspring/decimal"
+	"github.com/ty2/ctrader-go/proto/openapi"
+	"github.com/vmware/transport-go/bus"
+	"github.com/vmware/transport-go/model"
+	"sort"
+	"sync"
+	"time"
+)
+
+const orderBookChannel = "order-book"
+
+var ErrInsufficientDepth = errors.New("not enough depth to fill the volume")
+
+// BookLevel is the total size at one price; Cumulative adds the sizes of all better levels.
+// Sizes are in units of the base asset.
+type BookLevel struct {
+	Price      decimal.Decimal
+	Size       decimal.Decimal
+	Cumulative decimal.Decimal
+}
+
+// OrderBookSnapshot is a copy of a book; Bids are sorted best (highest) first, Asks lowest first.
+type OrderBookSnapshot struct {
+	SymbolId int64
+	Time     time.Time
+	Bids     []BookLevel
+	Asks     []BookLevel
+}
+
+type depthQuote struct {
+	price int64
+	size  uint64
+	bid   bool
+}
+
+// OrderBook maintains the depth of market of one symbol from incremental PROTO_OA_DEPTH_EVENTs.
+type OrderBook struct {
+	symbolId int64
+	eventBus bus.EventBus
+
+	mu       sync.RWMutex
+	quotes   map[uint64]depthQuote
+	snapshot OrderBookSnapshot
+
+	depthHandler bus.MessageHandler
+	subscription *Subscription
+}
+
+func NewOrderBook(symbolId int64) *OrderBook {
+	book := &OrderBook{
+		symbolId: symbolId,
+		eventBus: bus.NewEventBusInstance(),
+		quotes:   map[uint64]depthQuote{},
+		snapshot: OrderBookSnapshot{SymbolId: symbolId},
+	}
+	book.eventBus.GetChannelManager().CreateChannel(orderBookChannel)
+
+	return book
+}
+
+// OrderBook returns a book fed by the account depth events of symbolId. It holds a depth
+// subscription of the symbol until Close.
+func (account *Account) OrderBook(symbolId int64) (*OrderBook, error) {
+	book := NewOrderBook(symbolId)
+
+	depthHandler, err := account.OnDepth()
+	if err != nil {
+		return nil, err
+	}
+
+	depthHandler.Handle(
+		func(msg *model.Message) {
+			if v, ok := msg.Payload.(*openapi.ProtoOADepthEvent); ok && int64(v.GetSymbolId()) == symbolId {
+				book.Apply(v)
+			}
+		},
+		func(err error) {
+			logger.Warn(err.Error())
+		})
+	book.depthHandler = depthHandler
+
+	if book.subscription, err = account.subscriptions.Depth(symbolId); err != nil {
+		depthHandler.Close()
+		return nil, err
+	}
+
+	return book, nil
+}
+
+// Apply removes the deleted quotes, adds or replaces the new ones and publishes the new snapshot.
+func (book *OrderBook) Apply(event *openapi.ProtoOADepthEvent) {
+	book.mu.Lock()
+	for _, id := range event.DeletedQuotes {
+		delete(book.quotes, id)
+	}
+	for _, v := range event.NewQuotes {
+		quote := depthQuote{size: v.GetSize(), bid: v.Bid != nil}
+		if quote.bid {
+			quote.price = int64(v.GetBid())
+		} else {
+			quote.price = int64(v.GetAsk())
+		}
+		book.quotes[v.GetId()] = quote
+	}
+	book.rebuild()
+	snapshot := book.snapshot
+	book.mu.Unlock()
+
+	if err := book.eventBus.SendBroadcastMessage(orderBookChannel, snapshot); err != nil {
+		logger.Warn(err.Error())
+	}
+}
+
+// Reset empties the book, e.g. before subscribing again after a reconnect.
+func (book *OrderBook) Reset() {
+	book.mu.Lock()
+	defer book.mu.Unlock()
+	book.quotes = map[uint64]depthQuote{}
+	book.rebuild()
+}
+
+func (book *OrderBook) rebuild() {
+	bids, asks := map[int64]uint64{}, map[int64]uint64{}
+	for _, quote := range book.quotes {
+		if quote.bid {
+			bids[quote.price] += quote.size
+		} else {
+			asks[quote.price] += quote.size
+		}
+	}
+
+	book.snapshot = OrderBookSnapshot{
+		SymbolId: book.symbolId,
+		Time:     time.Now(),
+		Bids:     bookLevels(bids, true),
+		Asks:     bookLevels(asks, false),
+	}
+}
+
+func bookLevels(sizes map[int64]uint64, descending bool) []BookLevel {
+	prices := make([]int64, 0, len(sizes))
+	for price := range sizes {
+		prices = append(prices, price)
+	}
+	sort.Slice(prices, func(i, j int) bool {
+		if descending {
+			return prices[i] > prices[j]
+		}
+		return prices[i] < prices[j]
+	})
+
+	levels := make([]BookLevel, len(prices))
+	cumulative := decimal.Zero
+	for i, price := range prices {
+		size := decimal.New(int64(sizes[price]), -2)
+		cumulative = cumulative.Add(size)
+		levels[i] = BookLevel{Price: decimal.New(price, -PriceScale), Size: size, Cumulative: cumulative}
+	}
+
+	return levels
+}
+
+// Snapshot returns the current book. Level slices are shared between snapshots and must not be modified.
+func (book *OrderBook) Snapshot() OrderBookSnapshot {
+	book.mu.RLock()
+	defer book.mu.RUnlock()
+	return book.snapshot
+}
+
+func (book *OrderBook) Bids() []BookLevel {
+	return book.Snapshot().Bids
+}
+
+func (book *OrderBook) Asks() []BookLevel {
+	return book.Snapshot().Asks
+}
+
+func (book *OrderBook) BestBid() (BookLevel, bool) {
+	return book.Snapshot().BestBid()
+}
+
+func (book *OrderBook) BestAsk() (BookLevel, bool) {
+	return book.Snapshot().BestAsk()
+}
+
+// VWAPToFill is the average price of filling units against the book: asks for BUY, bids for SELL.
+func (book *OrderBook) VWAPToFill(side openapi.ProtoOATradeSide, units decimal.Decimal) (decimal.Decimal, error) {
+	return book.Snapshot().VWAPToFill(side, units)
+}
+
+func (snapshot OrderBookSnapshot) BestBid() (BookLevel, bool) {
+	if len(snapshot.Bids) == 0 {
+		return BookLevel{}, false
+	}
+
+	return snapshot.Bids[0], true
+}
+
+func (snapshot OrderBookSnapshot) BestAsk() (BookLevel, bool) {
+	if len(snapshot.Asks) == 0 {
+		return BookLevel{}, false
+	}
+
+	return snapshot.Asks[0], true
+}
+
+func (snapshot OrderBookSnapshot) VWAPToFill(side openapi.ProtoOATradeSide, units decimal.Decimal) (decimal.Decimal, error) {
+	if !units.IsPositive() {
+		return decimal.Zero, errors.New("units must be positive")
+	}
+
+	levels := snapshot.Asks
+	if side == openapi.ProtoOATradeSide_SELL {
+		levels = snapshot.Bids
+	}
+
+	remaining, cost := units, decimal.Zero
+	for _, level := range levels {
+		fill := level.Size
+		if fill.GreaterThan(remaining) {
+			fill = remaining
+		}
+		cost = cost.Add(fill.Mul(level.Price))
+		remaining = remaining.Sub(fill)
+		if remaining.IsZero() {
+			return cost.Div(units), nil
+		}
+	}
+
+	return decimal.Zero, ErrInsufficientDepth
+}
+
+// OnUpdate delivers an OrderBookSnapshot after every applied depth event.
+func (book *OrderBook) OnUpdate() (bus.MessageHandler, error) {
+	return book.eventBus.ListenFirehose(orderBookChannel)
+}
+
+func (book *OrderBook) Close() {
+	if book.depthHandler != nil {
+		book.depthHandler.Close()
+	}
+	if book.subscription != nil {
+		if err := book.subscription.Close(); err != nil {
+			logger.Warn(err.Error())
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package ctrader
+
+import (
+	"github.com/shopspring/decimal"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ty2/ctrader-go/proto/openapi"
+	"testing"
+)
+
+func depthQuoteOf(id, size, price uint64, bid bool) *openapi.ProtoOADepthQuote {
+	quote := &openapi.ProtoOADepthQuote{Id: &id, Size: &size}
+	if bid {
+		quote.Bid = &price
+	} else {
+		quote.Ask = &price
+	}
+	return quote
+}
+
+func TestOrderBook(t *testing.T) {
+	Convey("OrderBook", t, func() {
+		book := NewOrderBook(1)
+		book.Apply(&openapi.ProtoOADepthEvent{NewQuotes: []*openapi.ProtoOADepthQuote{
+			depthQuoteOf(1, 100000, 110000, true),
+			depthQuoteOf(2, 200000, 109990, true),
+			depthQuoteOf(3, 50000, 110000, true),
+			depthQuoteOf(4, 100000, 110010, false),
+			depthQuoteOf(5, 300000, 110020, false),
+		}})
+
+		Convey("aggregates and sorts levels", func() {
+			bids := book.Bids()
+			So(bids, ShouldHaveLength, 2)
+			So(bids[0].Price.String(), ShouldEqual, "1.1")
+			So(bids[0].Size.String(), ShouldEqual, "1500")
+			So(bids[1].Cumulative.String(), ShouldEqual, "3500")
+
+			ask, ok := book.BestAsk()
+			So(ok, ShouldBeTrue)
+			So(ask.Price.String(), ShouldEqual, "1.1001")
+		})
+
+		Convey("applies deletions and replacements", func() {
+			book.Apply(&openapi.ProtoOADepthEvent{
+				DeletedQuotes: []uint64{1, 3},
+				NewQuotes:     []*openapi.ProtoOADepthQuote{depthQuoteOf(4, 50000, 110010, false)},
+			})
+
+			bid, _ := book.BestBid()
+			So(bid.Price.String(), ShouldEqual, "1.0999")
+			ask, _ := book.BestAsk()
+			So(ask.Size.String(), ShouldEqual, "500")
+		})
+
+		Convey("computes the VWAP to fill", func() {
+			vwap, err := book.VWAPToFill(openapi.ProtoOATradeSide_BUY, decimal.NewFromInt(2000))
+			So(err, ShouldBeNil)
+			So(vwap.String(), ShouldEqual, "1.10015")
+
+			_, err = book.VWAPToFill(openapi.ProtoOATradeSide_SELL, decimal.NewFromInt(4000))
+			So(err, ShouldEqual, ErrInsufficientDepth)
+		})
+	})
+}
@@ -0,0 +1,315 @@
+package ctrader
+
+import (
+	"errors"
+	"github.com/shopspring/decimal"
+	"github.com/ty2/ctrader-go/proto/openapi"
+	"google.golang.org/protobuf/proto"
+	"strings"
+	"time"
+)
+
+const (
+	MaxLabelLength         = 100
+	MaxCommentLength       = 512
+	MaxClientOrderIdLength = 50
+)
+
+// OrderBuilder assembles a ProtoOANewOrderReq and checks field combinations locally in Build.
+type OrderBuilder struct {
+	symbol *openapi.ProtoOASymbol
+	lots   float64
+	volume int64
+	req    *openapi.ProtoOANewOrderReq
+}
+
+type OrderValidationError struct {
+	Problems []string
+}
+
+func (err *OrderValidationError) Error() string {
+	return "invalid order: " + strings.Join(err.Problems, "; ")
+}
+
+func newOrderBuilder(symbol *openapi.ProtoOASymbol, orderType openapi.ProtoOAOrderType, side openapi.ProtoOATradeSide, lots float64) *OrderBuilder {
+	req := &openapi.ProtoOANewOrderReq{
+		OrderType: orderType.Enum(),
+		TradeSide: side.Enum(),
+	}
+
+	if symbol != nil {
+		req.SymbolId = symbol.SymbolId
+	}
+
+	return &OrderBuilder{symbol: symbol, lots: lots, req: req}
+}
+
+func Market(symbol *openapi.ProtoOASymbol, side openapi.ProtoOATradeSide, lots float64) *OrderBuilder {
+	return newOrderBuilder(symbol, openapi.ProtoOAOrderType_MARKET, side, lots)
+}
+
+func Limit(symbol *openapi.ProtoOASymbol, side openapi.ProtoOATradeSide, lots float64, limitPrice float64) *OrderBuilder {
+	builder := newOrderBuilder(symbol, openapi.ProtoOAOrderType_LIMIT, side, lots)
+	builder.req.LimitPrice = &limitPrice
+	return builder
+}
+
+func Stop(symbol *openapi.ProtoOASymbol, side openapi.ProtoOATradeSide, lots float64, stopPrice float64) *OrderBuilder {
+	builder := newOrderBuilder(symbol, openapi.ProtoOAOrderType_STOP, side, lots)
+	builder.req.StopPrice = &stopPrice
+	return builder
+}
+
+func StopLimit(symbol *openapi.ProtoOASymbol, side openapi.ProtoOATradeSide, lots float64, stopPrice float64, slippageInPoints int32) *OrderBuilder {
+	builder := newOrderBuilder(symbol, openapi.ProtoOAOrderType_STOP_LIMIT, side, lots)
+	builder.req.StopPrice = &stopPrice
+	builder.req.SlippageInPoints = &slippageInPoints
+	return builder
+}
+
+func MarketRange(symbol *openapi.ProtoOASymbol, side openapi.ProtoOATradeSide, lots float64, baseSlippagePrice float64, slippageInPoints int32) *OrderBuilder {
+	builder := newOrderBuilder(symbol, openapi.ProtoOAOrderType_MARKET_RANGE, side, lots)
+	builder.req.BaseSlippagePrice = &baseSlippagePrice
+	builder.req.SlippageInPoints = &slippageInPoints
+	return builder
+}
+
+// Volume sets the volume in cents instead of lots, e.g. from a PositionSizer.
+func (builder *OrderBuilder) Volume(volume int64) *OrderBuilder {
+	builder.volume = volume
+	return builder
+}
+
+func (builder *OrderBuilder) WithSL(stopLoss float64) *OrderBuilder {
+	builder.req.StopLoss = &stopLoss
+	return builder
+}
+
+func (builder *OrderBuilder) WithTP(takeProfit float64) *OrderBuilder {
+	builder.req.TakeProfit = &takeProfit
+	return builder
+}
+
+// RelativeSL sets the stop loss distance from the entry price in 1/100000 of a price unit.
+func (builder *OrderBuilder) RelativeSL(distance int64) *OrderBuilder {
+	builder.req.RelativeStopLoss = &distance
+	return builder
+}
+
+// RelativeTP sets the take profit distance from the entry price in 1/100000 of a price unit.
+func (builder *OrderBuilder) RelativeTP(distance int64) *OrderBuilder {
+	builder.req.RelativeTakeProfit = &distance
+	return builder
+}
+
+func (builder *OrderBuilder) Trailing() *OrderBuilder {
+	trailing := true
+	builder.req.TrailingStopLoss = &trailing
+	return builder
+}
+
+func (builder *OrderBuilder) GuaranteedSL() *OrderBuilder {
+	guaranteed := true
+	builder.req.GuaranteedStopLoss = &guaranteed
+	return builder
+}
+
+func (builder *OrderBuilder) GTD(expiration time.Time) *OrderBuilder {
+	timestamp := expiration.UnixNano() / int64(time.Millisecond)
+	builder.req.TimeInForce = openapi.ProtoOATimeInForce_GOOD_TILL_DATE.Enum()
+	builder.req.ExpirationTimestamp = &timestamp
+	return builder
+}
+
+func (builder *OrderBuilder) TimeInForce(timeInForce openapi.ProtoOATimeInForce) *OrderBuilder {
+	builder.req.TimeInForce = timeInForce.Enum()
+	return builder
+}
+
+func (builder *OrderBuilder) TriggerMethod(method openapi.ProtoOAOrderTriggerMethod) *OrderBuilder {
+	builder.req.StopTriggerMethod = method.Enum()
+	return builder
+}
+
+func (builder *OrderBuilder) Label(label string) *OrderBuilder {
+	builder.req.Label = &label
+	return builder
+}
+
+func (builder *OrderBuilder) Comment(comment string) *OrderBuilder {
+	builder.req.Comment = &comment
+	return builder
+}
+
+func (builder *OrderBuilder) ClientOrderID(clientOrderId string) *OrderBuilder {
+	builder.req.ClientOrderId = &clientOrderId
+	return builder
+}
+
+func (builder *OrderBuilder) Position(positionId int64) *OrderBuilder {
+	builder.req.PositionId = &positionId
+	return builder
+}
+
+// Build validates the order and returns a new request ready for Account.NewOrder on every call.
+func (builder *OrderBuilder) Build() (*openapi.ProtoOANewOrderReq, error) {
+	var problems []string
+	problem := func(s string) {
+		problems = append(problems, s)
+	}
+
+	// a fresh request each time, as sending one sets its account id
+	req := proto.Clone(builder.req).(*openapi.ProtoOANewOrderReq)
+	orderType := req.GetOrderType()
+	side := req.GetTradeSide()
+	isMarket := orderType == openapi.ProtoOAOrderType_MARKET || orderType == openapi.ProtoOAOrderType_MARKET_RANGE
+
+	if builder.symbol == nil || builder.symbol.SymbolId == nil {
+		problem("symbol is required")
+	} else if builder.volume != 0 {
+		if err := NewSymbolInfo(builder.symbol, nil).ValidateVolume(builder.volume); err != nil {
+			problem(err.Error())
+		} else {
+			req.Volume = proto.Int64(builder.volume)
+		}
+	} else if volume, err := lotsToVolume(builder.symbol, builder.lots); err != nil {
+		problem(err.Error())
+	} else {
+		req.Volume = &volume
+	}
+
+	if side != openapi.ProtoOATradeSide_BUY && side != openapi.ProtoOATradeSide_SELL {
+		problem("trade side must be BUY or SELL")
+	}
+
+	var entry float64
+	switch orderType {
+	case openapi.ProtoOAOrderType_LIMIT:
+		entry = req.GetLimitPrice()
+		if entry <= 0 {
+			problem("limit price must be positive")
+		}
+	case openapi.ProtoOAOrderType_STOP:
+		entry = req.GetStopPrice()
+		if entry <= 0 {
+			problem("stop price must be positive")
+		}
+	case openapi.ProtoOAOrderType_STOP_LIMIT:
+		entry = req.GetStopPrice()
+		if entry <= 0 {
+			problem("stop price must be positive")
+		}
+		if req.GetSlippageInPoints() <= 0 {
+			problem("stop limit order requires positive slippage in points")
+		}
+	case openapi.ProtoOAOrderType_MARKET_RANGE:
+		if req.GetBaseSlippagePrice() <= 0 {
+			problem("market range order requires a positive base slippage price")
+		}
+		if req.GetSlippageInPoints() <= 0 {
+			problem("market range order requires positive slippage in points")
+		}
+	case openapi.ProtoOAOrderType_MARKET:
+	default:
+		problem("unsupported order type " + orderType.String())
+	}
+
+	if req.StopLoss != nil && req.RelativeStopLoss != nil {
+		problem("absolute and relative stop loss are mutually exclusive")
+	}
+	if req.TakeProfit != nil && req.RelativeTakeProfit != nil {
+		problem("absolute and relative take profit are mutually exclusive")
+	}
+	if isMarket && (req.StopLoss != nil || req.TakeProfit != nil) {
+		problem("market orders only accept relative stop loss and take profit")
+	}
+	if req.RelativeStopLoss != nil && req.GetRelativeStopLoss() <= 0 {
+		problem("relative stop loss must be positive")
+	}
+	if req.RelativeTakeProfit != nil && req.GetRelativeTakeProfit() <= 0 {
+		problem("relative take profit must be positive")
+	}
+
+	if entry > 0 {
+		if req.StopLoss != nil && !priceOnSide(req.GetStopLoss(), entry, side == openapi.ProtoOATradeSide_BUY) {
+			problem("stop loss must be on the losing side of the entry price")
+		}
+		if req.TakeProfit != nil && !priceOnSide(req.GetTakeProfit(), entry, side == openapi.ProtoOATradeSide_SELL) {
+			problem("take profit must be on the winning side of the entry price")
+		}
+	}
+
+	hasStopLoss := req.StopLoss != nil || req.RelativeStopLoss != nil
+	if req.GetTrailingStopLoss() && !hasStopLoss {
+		problem("trailing stop loss requires a stop loss")
+	}
+	if req.GetGuaranteedStopLoss() && !hasStopLoss {
+		problem("guaranteed stop loss requires a stop loss")
+	}
+
+	if req.TimeInForce != nil {
+		switch req.GetTimeInForce() {
+		case openapi.ProtoOATimeInForce_GOOD_TILL_DATE:
+			if isMarket {
+				problem("good till date is not valid for market orders")
+			} else if req.ExpirationTimestamp == nil || req.GetExpirationTimestamp() <= time.Now().UnixNano()/int64(time.Millisecond) {
+				problem("good till date requires an expiration in the future")
+			}
+		case openapi.ProtoOATimeInForce_GOOD_TILL_CANCEL:
+			if isMarket {
+				problem("good till cancel is not valid for market orders")
+			}
+		}
+	}
+	if req.ExpirationTimestamp != nil && req.GetTimeInForce() != openapi.ProtoOATimeInForce_GOOD_TILL_DATE {
+		problem("expiration is only valid for good till date orders")
+	}
+
+	if req.StopTriggerMethod != nil && orderType != openapi.ProtoOAOrderType_STOP && orderType != openapi.ProtoOAOrderType_STOP_LIMIT {
+		problem("trigger method is only valid for stop and stop limit orders")
+	}
+
+	if len(req.GetLabel()) > MaxLabelLength {
+		problem("label is too long")
+	}
+	if len(req.GetComment()) > MaxCommentLength {
+		problem("comment is too long")
+	}
+	if len(req.GetClientOrderId()) > MaxClientOrderIdLength {
+		problem("client order id is too long")
+	}
+
+	if len(problems) > 0 {
+		return nil, &OrderValidationError{Problems: problems}
+	}
+
+	return req, nil
+}
+
+// priceOnSide reports whether price is strictly below (below = true) or above the entry.
+func priceOnSide(price, entry float64, below bool) bool {
+	if below {
+		return price < entry
+	}
+
+	return price > entry
+}
+
+// lotsToVolume converts lots to volume in cents and checks it against the symbol volume limits.
+func lotsToVolume(symbol *openapi.ProtoOASymbol, lots float64) (int64, error) {
+	if lots <= 0 {
+		return 0, errors.New("lots must be positive")
+	}
+
+	if symbol.GetLotSize() <= 0 {
+		return 0, errors.New("symbol lot size is unknown, use a full ProtoOASymbol")
+	}
+
+	info := NewSymbolInfo(symbol, nil)
+	volume := info.LotsToVolume(decimal.NewFromFloat(lots))
+	if err := info.ValidateVolume(volume); err != nil {
+		return 0, err
+	}
+
+	return volume, nil
+}
@@ -0,0 +1,115 @@
+package ctrader
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ty2/ctrader-go/proto/openapi"
+	"testing"
+	"time"
+)
+
+func testSymbol() *openapi.ProtoOASymbol {
+	symbolId := int64(1)
+	digits := int32(5)
+	pipPosition := int32(4)
+	lotSize := int64(10000000)
+	minVolume := int64(100000)
+	maxVolume := int64(1000000000)
+	stepVolume := int64(100000)
+	return &openapi.ProtoOASymbol{
+		SymbolId:    &symbolId,
+		Digits:      &digits,
+		PipPosition: &pipPosition,
+		LotSize:     &lotSize,
+		MinVolume:   &minVolume,
+		MaxVolume:   &maxVolume,
+		StepVolume:  &stepVolume,
+	}
+}
+
+func TestOrderBuilder(t *testing.T) {
+	Convey("OrderBuilder", t, func() {
+		symbol := testSymbol()
+
+		Convey("Market converts lots to volume", func() {
+			req, err := Market(symbol, openapi.ProtoOATradeSide_BUY, 0.1).RelativeSL(500).Label("test").Build()
+			So(err, ShouldBeNil)
+			So(req.GetSymbolId(), ShouldEqual, 1)
+			So(req.GetVolume(), ShouldEqual, 1000000)
+			So(req.GetOrderType(), ShouldEqual, openapi.ProtoOAOrderType_MARKET)
+			So(req.GetRelativeStopLoss(), ShouldEqual, 500)
+			So(req.CtidTraderAccountId, ShouldBeNil)
+		})
+
+		Convey("Market rejects absolute SL/TP and good till cancel", func() {
+			_, err := Market(symbol, openapi.ProtoOATradeSide_BUY, 1).WithSL(1.1).TimeInForce(openapi.ProtoOATimeInForce_GOOD_TILL_CANCEL).Build()
+			So(err, ShouldNotBeNil)
+			So(err.(*OrderValidationError).Problems, ShouldHaveLength, 2)
+		})
+
+		Convey("Volume must respect symbol limits", func() {
+			_, err := Market(symbol, openapi.ProtoOATradeSide_BUY, 0.001).Build()
+			So(err, ShouldNotBeNil)
+			_, err = Market(symbol, openapi.ProtoOATradeSide_BUY, 0.015).Build()
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Volume replaces lots", func() {
+			req, err := Market(symbol, openapi.ProtoOATradeSide_BUY, 0).Volume(300000).Build()
+			So(err, ShouldBeNil)
+			So(req.GetVolume(), ShouldEqual, 300000)
+			_, err = Market(symbol, openapi.ProtoOATradeSide_BUY, 0).Volume(350000).Build()
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Build returns a new request every time", func() {
+			builder := Market(symbol, openapi.ProtoOATradeSide_BUY, 0.1)
+			first, err := builder.Build()
+			So(err, ShouldBeNil)
+			accountId := int64(1)
+			first.CtidTraderAccountId = &accountId
+
+			second, err := builder.Build()
+			So(err, ShouldBeNil)
+			So(second.CtidTraderAccountId, ShouldBeNil)
+		})
+
+		Convey("Limit checks SL/TP sides", func() {
+			_, err := Limit(symbol, openapi.ProtoOATradeSide_BUY, 1, 1.1).WithSL(1.09).WithTP(1.12).Build()
+			So(err, ShouldBeNil)
+			_, err = Limit(symbol, openapi.ProtoOATradeSide_SELL, 1, 1.1).WithSL(1.09).Build()
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Stop limit requires slippage", func() {
+			_, err := StopLimit(symbol, openapi.ProtoOATradeSide_BUY, 1, 1.1, 0).Build()
+			So(err, ShouldNotBeNil)
+			_, err = StopLimit(symbol, openapi.ProtoOATradeSide_BUY, 1, 1.1, 10).Build()
+			So(err, ShouldBeNil)
+		})
+
+		Convey("Market range requires base price", func() {
+			_, err := MarketRange(symbol, openapi.ProtoOATradeSide_SELL, 1, 0, 10).Build()
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Trailing requires a stop loss", func() {
+			_, err := Stop(symbol, openapi.ProtoOATradeSide_BUY, 1, 1.1).Trailing().Build()
+			So(err, ShouldNotBeNil)
+			_, err = Stop(symbol, openapi.ProtoOATradeSide_BUY, 1, 1.1).RelativeSL(100).Trailing().Build()
+			So(err, ShouldBeNil)
+		})
+
+		Convey("GTD requires a future expiration", func() {
+			_, err := Limit(symbol, openapi.ProtoOATradeSide_BUY, 1, 1.1).GTD(time.Now().Add(-time.Hour)).Build()
+			So(err, ShouldNotBeNil)
+			req, err := Limit(symbol, openapi.ProtoOATradeSide_BUY, 1, 1.1).GTD(time.Now().Add(time.Hour)).Build()
+			So(err, ShouldBeNil)
+			So(req.GetTimeInForce(), ShouldEqual, openapi.ProtoOATimeInForce_GOOD_TILL_DATE)
+		})
+
+		Convey("Client order id length is limited", func() {
+			_, err := Market(symbol, openapi.ProtoOATradeSide_BUY, 1).ClientOrderID("012345678901234567890123456789012345678901234567890").Build()
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
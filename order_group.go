@@ -0,0 +1,990 @@
+package ctrader
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/ty2/ctrader-go/internal/fsutil"
+	"github.com/ty2/ctrader-go/proto/openapi"
+	"github.com/vmware/transport-go/bus"
+	"github.com/vmware/transport-go/model"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const orderGroupChannel = "order-group"
+
+const (
+	// orderGroupRetryDelay is the wait before a leg that failed to reach the broker is sent again,
+	// doubled on every further failure.
+	orderGroupRetryDelay = time.Second
+	// orderGroupMaxRetryDelay caps the wait between attempts of a leg.
+	orderGroupMaxRetryDelay = time.Minute
+)
+
+var (
+	orderGroupMarshal   = protojson.MarshalOptions{AllowPartial: true}
+	orderGroupUnmarshal = protojson.UnmarshalOptions{AllowPartial: true}
+)
+
+// OrderGroupType selects how the legs of an OrderGroup are linked.
+type OrderGroupType string
+
+const (
+	// OCOGroup cancels the other legs once a leg fills, is cancelled, expires or is rejected.
+	OCOGroup OrderGroupType = "oco"
+	// BracketGroup places a stop loss and take profits on the position of a filled entry. Take
+	// profit fills reduce the stop loss volume; a stop loss fill cancels the take profits.
+	BracketGroup OrderGroupType = "bracket"
+	// ScaleInGroup places entries at several prices and cancels the unfilled ones once a position
+	// they opened is closed.
+	ScaleInGroup OrderGroupType = "scale-in"
+)
+
+// OrderLegRole tells the entry legs of a group from its exits.
+type OrderLegRole string
+
+const (
+	EntryLeg      OrderLegRole = "entry"
+	StopLossLeg   OrderLegRole = "stop-loss"
+	TakeProfitLeg OrderLegRole = "take-profit"
+)
+
+// OrderGroupLeg is one order of a group. Exit legs of a bracket are only sent after the entry
+// filled; Skipped is set on legs that will never be sent.
+type OrderGroupLeg struct {
+	Role          OrderLegRole
+	Request       *openapi.ProtoOANewOrderReq
+	ClientOrderId string
+	Sent          bool
+	Skipped       bool
+	OrderId       int64
+	PositionId    int64
+	Status        openapi.ProtoOAOrderStatus
+	Volume        int64
+	FilledVolume  int64
+	Updated       int64
+	Cancelling    bool
+	Amending      int64
+	Error         string
+
+	failures int
+	retryAt  time.Time
+}
+
+type orderGroupLegJSON struct {
+	Role          OrderLegRole               `json:"role"`
+	Request       json.RawMessage            `json:"request"`
+	ClientOrderId string                     `json:"clientOrderId"`
+	Sent          bool                       `json:"sent,omitempty"`
+	Skipped       bool                       `json:"skipped,omitempty"`
+	OrderId       int64                      `json:"orderId,omitempty"`
+	PositionId    int64                      `json:"positionId,omitempty"`
+	Status        openapi.ProtoOAOrderStatus `json:"status,omitempty"`
+	Volume        int64                      `json:"volume"`
+	FilledVolume  int64                      `json:"filledVolume,omitempty"`
+	Updated       int64                      `json:"updated,omitempty"`
+	Error         string                     `json:"error,omitempty"`
+}
+
+func (leg *OrderGroupLeg) MarshalJSON() ([]byte, error) {
+	request, err := orderGroupMarshal.Marshal(leg.Request)
+	if err != nil {
+		return nil, err
+	}
+
+	// pending cancels and amends are not saved; they are decided again after a restart
+	return json.Marshal(orderGroupLegJSON{
+		Role:          leg.Role,
+		Request:       request,
+		ClientOrderId: leg.ClientOrderId,
+		Sent:          leg.Sent,
+		Skipped:       leg.Skipped,
+		OrderId:       leg.OrderId,
+		PositionId:    leg.PositionId,
+		Status:        leg.Status,
+		Volume:        leg.Volume,
+		FilledVolume:  leg.FilledVolume,
+		Updated:       leg.Updated,
+		Error:         leg.Error,
+	})
+}
+
+func (leg *OrderGroupLeg) UnmarshalJSON(b []byte) error {
+	var v orderGroupLegJSON
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+
+	request := &openapi.ProtoOANewOrderReq{}
+	if err := orderGroupUnmarshal.Unmarshal(v.Request, request); err != nil {
+		return err
+	}
+
+	*leg = OrderGroupLeg{
+		Role:          v.Role,
+		Request:       request,
+		ClientOrderId: v.ClientOrderId,
+		Sent:          v.Sent,
+		Skipped:       v.Skipped,
+		OrderId:       v.OrderId,
+		PositionId:    v.PositionId,
+		Status:        v.Status,
+		Volume:        v.Volume,
+		FilledVolume:  v.FilledVolume,
+		Updated:       v.Updated,
+		Error:         v.Error,
+	}
+	return nil
+}
+
+func (leg *OrderGroupLeg) pending() bool {
+	return leg.OrderId != 0 && leg.Status == openapi.ProtoOAOrderStatus_ORDER_STATUS_ACCEPTED
+}
+
+func (leg *OrderGroupLeg) final() bool {
+	return leg.Skipped || isFinalOrderStatus(leg.Status)
+}
+
+// OrderGroup is a set of linked orders. Closed is set once a position opened by the group was
+// closed, Cancelled by OrderGroupEngine.Cancel and Done when every leg is final.
+type OrderGroup struct {
+	Id        string
+	Type      OrderGroupType
+	Created   time.Time
+	Legs      []*OrderGroupLeg
+	Closed    bool
+	Cancelled bool
+	Done      bool
+}
+
+func (group *OrderGroup) copy() OrderGroup {
+	c := *group
+	c.Legs = make([]*OrderGroupLeg, len(group.Legs))
+	for i, leg := range group.Legs {
+		legCopy := *leg
+		legCopy.Request = proto.Clone(leg.Request).(*openapi.ProtoOANewOrderReq)
+		c.Legs[i] = &legCopy
+	}
+	return c
+}
+
+// TakeProfitLevel closes Volume of a bracket position at Price.
+type TakeProfitLevel struct {
+	Price  float64
+	Volume int64
+}
+
+type orderGroupFile struct {
+	SavedAt time.Time     `json:"savedAt"`
+	Groups  []*OrderGroup `json:"groups"`
+}
+
+type orderGroupActionType int
+
+const (
+	placeLeg orderGroupActionType = iota
+	cancelLeg
+	amendLeg
+)
+
+type orderGroupAction struct {
+	actionType orderGroupActionType
+	leg        *OrderGroupLeg
+	request    *openapi.ProtoOANewOrderReq
+	orderId    int64
+	volume     int64
+}
+
+// OrderGroupEngine places linked orders and keeps them consistent with the executions of the
+// account. Groups that are not done are saved to a file after every change and resumed from it,
+// reconciled with the orders and positions of the account.
+type OrderGroupEngine struct {
+	eventBus  bus.EventBus
+	path      string
+	submit    func(req *openapi.ProtoOANewOrderReq) (*openapi.ProtoOAOrder, error)
+	cancel    func(orderId int64) error
+	amend     func(req *openapi.ProtoOAAmendOrderReq) error
+	reconcile func() (*openapi.ProtoOAReconcileRes, error)
+	lookup    func(clientOrderId string, since time.Time) (*openapi.ProtoOAOrder, error)
+	now       func() time.Time
+
+	mu         sync.Mutex
+	groups     map[string]*OrderGroup
+	legs       map[string]*OrderGroup
+	orderIds   map[int64]*OrderGroup
+	retryDelay time.Duration
+	retries    map[string]*time.Timer
+	closed     bool
+
+	handler bus.MessageHandler
+}
+
+// newOrderGroupEngine saves to path unless it is empty.
+func newOrderGroupEngine(path string) *OrderGroupEngine {
+	engine := &OrderGroupEngine{
+		eventBus:   bus.NewEventBusInstance(),
+		path:       path,
+		now:        time.Now,
+		groups:     map[string]*OrderGroup{},
+		legs:       map[string]*OrderGroup{},
+		orderIds:   map[int64]*OrderGroup{},
+		retryDelay: orderGroupRetryDelay,
+		retries:    map[string]*time.Timer{},
+	}
+	engine.eventBus.GetChannelManager().CreateChannel(orderGroupChannel)
+
+	return engine
+}
+
+// OrderGroups returns an engine saving its groups to path, resuming the groups already saved
+// there. Orders are sent with SubmitOrder.
+func (account *Account) OrderGroups(path string) (*OrderGroupEngine, error) {
+	tracker, err := account.OrderTracker()
+	if err != nil {
+		return nil, err
+	}
+
+	engine := newOrderGroupEngine(path)
+	engine.submit = func(req *openapi.ProtoOANewOrderReq) (*openapi.ProtoOAOrder, error) {
+		handle, err := tracker.Submit(req)
+		if err != nil {
+			return nil, err
+		}
+		return handle.Order(), nil
+	}
+	engine.cancel = func(orderId int64) error {
+		_, err := account.CancelOrder(orderId)
+		return err
+	}
+	engine.amend = func(req *openapi.ProtoOAAmendOrderReq) error {
+		_, err := account.AmendOrder(req)
+		return err
+	}
+	engine.reconcile = account.Reconcile
+	engine.lookup = tracker.lookup
+
+	handler, err := account.OnExecution()
+	if err != nil {
+		return nil, err
+	}
+	handler.Handle(
+		func(msg *model.Message) {
+			if v, ok := msg.Payload.(*openapi.ProtoOAExecutionEvent); ok {
+				engine.Apply(v)
+			}
+		},
+		func(err error) {
+			logger.Warn(err.Error())
+		})
+	engine.handler = handler
+
+	if err := engine.Load(); err != nil {
+		engine.Close()
+		return nil, err
+	}
+	if err := engine.Resume(); err != nil {
+		engine.Close()
+		return nil, err
+	}
+
+	return engine, nil
+}
+
+func (engine *OrderGroupEngine) newGroup(groupType OrderGroupType, legs []*OrderGroupLeg) *OrderGroup {
+	group := &OrderGroup{
+		Id:      uuid.New().String(),
+		Type:    groupType,
+		Created: engine.now(),
+		Legs:    legs,
+	}
+	for i, leg := range legs {
+		leg.ClientOrderId = fmt.Sprintf("%s-%d", group.Id, i)
+		leg.Request.ClientOrderId = proto.String(leg.ClientOrderId)
+		leg.Volume = leg.Request.GetVolume()
+	}
+
+	return group
+}
+
+// add registers and saves a new group, then sends its first orders.
+func (engine *OrderGroupEngine) add(group *OrderGroup) (OrderGroup, error) {
+	engine.mu.Lock()
+	engine.index(group)
+	if err := engine.save(); err != nil {
+		engine.unindex(group)
+		engine.mu.Unlock()
+		return OrderGroup{}, err
+	}
+	engine.mu.Unlock()
+
+	engine.update(group)
+	return engine.snapshot(group), nil
+}
+
+// index registers group; the caller holds the lock.
+func (engine *OrderGroupEngine) index(group *OrderGroup) {
+	engine.groups[group.Id] = group
+	for _, leg := range group.Legs {
+		engine.legs[leg.ClientOrderId] = group
+		if leg.OrderId != 0 {
+			engine.orderIds[leg.OrderId] = group
+		}
+	}
+}
+
+// unindex forgets group; the caller holds the lock.
+func (engine *OrderGroupEngine) unindex(group *OrderGroup) {
+	delete(engine.groups, group.Id)
+	for _, leg := range group.Legs {
+		delete(engine.legs, leg.ClientOrderId)
+		delete(engine.orderIds, leg.OrderId)
+	}
+}
+
+func checkGroupRequest(req *openapi.ProtoOANewOrderReq) error {
+	if req == nil {
+		return errors.New("order request is nil")
+	}
+	if req.GetVolume() <= 0 {
+		return errors.New("order volume must be positive")
+	}
+	if req.ClientOrderId != nil {
+		return errors.New("client order id of a group order must be empty")
+	}
+	return nil
+}
+
+// OCO places orders cancelling each other.
+func (engine *OrderGroupEngine) OCO(reqs ...*openapi.ProtoOANewOrderReq) (OrderGroup, error) {
+	if len(reqs) < 2 {
+		return OrderGroup{}, errors.New("oco group needs at least two orders")
+	}
+
+	legs := make([]*OrderGroupLeg, len(reqs))
+	for i, req := range reqs {
+		if err := checkGroupRequest(req); err != nil {
+			return OrderGroup{}, err
+		}
+		legs[i] = &OrderGroupLeg{Role: EntryLeg, Request: proto.Clone(req).(*openapi.ProtoOANewOrderReq)}
+	}
+
+	return engine.add(engine.newGroup(OCOGroup, legs))
+}
+
+// Bracket places entry and, once it filled, a stop order at stopLoss for the filled volume and a
+// limit order per take profit level on its position. Levels are served in order from the filled
+// volume.
+func (engine *OrderGroupEngine) Bracket(entry *openapi.ProtoOANewOrderReq, stopLoss float64, takeProfits ...TakeProfitLevel) (OrderGroup, error) {
+	if err := checkGroupRequest(entry); err != nil {
+		return OrderGroup{}, err
+	}
+	if entry.GetTradeSide() != openapi.ProtoOATradeSide_BUY && entry.GetTradeSide() != openapi.ProtoOATradeSide_SELL {
+		return OrderGroup{}, errors.New("trade side must be BUY or SELL")
+	}
+
+	exitSide := openapi.ProtoOATradeSide_SELL
+	if entry.GetTradeSide() == openapi.ProtoOATradeSide_SELL {
+		exitSide = openapi.ProtoOATradeSide_BUY
+	}
+
+	legs := []*OrderGroupLeg{
+		{Role: EntryLeg, Request: proto.Clone(entry).(*openapi.ProtoOANewOrderReq)},
+		{Role: StopLossLeg, Request: &openapi.ProtoOANewOrderReq{
+			SymbolId:  entry.SymbolId,
+			OrderType: openapi.ProtoOAOrderType_STOP.Enum(),
+			TradeSide: exitSide.Enum(),
+			Volume:    entry.Volume,
+			StopPrice: proto.Float64(stopLoss),
+		}},
+	}
+
+	var total int64
+	for _, level := range takeProfits {
+		if level.Volume <= 0 {
+			return OrderGroup{}, errors.New("take profit volume must be positive")
+		}
+		total += level.Volume
+		legs = append(legs, &OrderGroupLeg{Role: TakeProfitLeg, Request: &openapi.ProtoOANewOrderReq{
+			SymbolId:   entry.SymbolId,
+			OrderType:  openapi.ProtoOAOrderType_LIMIT.Enum(),
+			TradeSide:  exitSide.Enum(),
+			Volume:     proto.Int64(level.Volume),
+			LimitPrice: proto.Float64(level.Price),
+		}})
+	}
+	if total > entry.GetVolume() {
+		return OrderGroup{}, errors.New("take profit volume is above the entry volume")
+	}
+
+	return engine.add(engine.newGroup(BracketGroup, legs))
+}
+
+// ScaleIn places entries that are cancelled once a position opened by one of them is closed.
+func (engine *OrderGroupEngine) ScaleIn(entries ...*openapi.ProtoOANewOrderReq) (OrderGroup, error) {
+	if len(entries) == 0 {
+		return OrderGroup{}, errors.New("scale in group needs an order")
+	}
+
+	legs := make([]*OrderGroupLeg, len(entries))
+	for i, req := range entries {
+		if err := checkGroupRequest(req); err != nil {
+			return OrderGroup{}, err
+		}
+		legs[i] = &OrderGroupLeg{Role: EntryLeg, Request: proto.Clone(req).(*openapi.ProtoOANewOrderReq)}
+	}
+
+	return engine.add(engine.newGroup(ScaleInGroup, legs))
+}
+
+// Cancel cancels the pending orders of a group and sends no more.
+func (engine *OrderGroupEngine) Cancel(groupId string) error {
+	engine.mu.Lock()
+	group, ok := engine.groups[groupId]
+	if ok {
+		group.Cancelled = true
+	}
+	engine.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("order group %s not found", groupId)
+	}
+
+	engine.update(group)
+	return nil
+}
+
+func (engine *OrderGroupEngine) Group(groupId string) (OrderGroup, bool) {
+	engine.mu.Lock()
+	group, ok := engine.groups[groupId]
+	engine.mu.Unlock()
+	if !ok {
+		return OrderGroup{}, false
+	}
+
+	return engine.snapshot(group), true
+}
+
+// Groups returns all groups sorted by creation.
+func (engine *OrderGroupEngine) Groups() []OrderGroup {
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+
+	groups := make([]OrderGroup, 0, len(engine.groups))
+	for _, group := range engine.groups {
+		groups = append(groups, group.copy())
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Created.Before(groups[j].Created) })
+	return groups
+}
+
+func (engine *OrderGroupEngine) snapshot(group *OrderGroup) OrderGroup {
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+	return group.copy()
+}
+
+// Apply updates the legs of an execution event and acts on the changes.
+func (engine *OrderGroupEngine) Apply(event *openapi.ProtoOAExecutionEvent) {
+	var changed []*OrderGroup
+
+	engine.mu.Lock()
+	if position := event.GetPosition(); position.GetPositionStatus() == openapi.ProtoOAPositionStatus_POSITION_STATUS_CLOSED {
+		for _, group := range engine.groups {
+			if !group.Done && !group.Closed && group.opened(position.GetPositionId()) {
+				group.Closed = true
+				changed = append(changed, group)
+			}
+		}
+	}
+
+	if order := event.GetOrder(); order != nil {
+		group, ok := engine.orderIds[order.GetOrderId()]
+		if !ok {
+			group, ok = engine.legs[order.GetClientOrderId()]
+		}
+		if ok && engine.applyOrder(group, order) {
+			changed = append(changed, group)
+		}
+	}
+
+	if len(changed) > 0 {
+		if err := engine.save(); err != nil {
+			logger.Warn(err.Error())
+		}
+	}
+	engine.mu.Unlock()
+
+	for _, group := range changed {
+		engine.update(group)
+	}
+}
+
+// opened reports whether positionId was opened by an entry of the group.
+func (group *OrderGroup) opened(positionId int64) bool {
+	for _, leg := range group.Legs {
+		if leg.Role == EntryLeg && leg.FilledVolume > 0 && leg.PositionId == positionId {
+			return true
+		}
+	}
+	return false
+}
+
+// applyOrder updates the leg of order; the caller holds the lock.
+func (engine *OrderGroupEngine) applyOrder(group *OrderGroup, order *openapi.ProtoOAOrder) bool {
+	var leg *OrderGroupLeg
+	for _, l := range group.Legs {
+		if (l.OrderId != 0 && l.OrderId == order.GetOrderId()) || (order.GetClientOrderId() != "" && l.ClientOrderId == order.GetClientOrderId()) {
+			leg = l
+		}
+	}
+	if leg == nil || leg.final() || order.GetUtcLastUpdateTimestamp() < leg.Updated {
+		return false
+	}
+
+	if leg.OrderId == 0 {
+		leg.OrderId = order.GetOrderId()
+		engine.orderIds[leg.OrderId] = group
+	}
+	leg.Sent = true
+	leg.Updated = order.GetUtcLastUpdateTimestamp()
+	if order.OrderStatus != nil {
+		leg.Status = order.GetOrderStatus()
+	}
+	if volume := order.GetTradeData().GetVolume(); volume > 0 {
+		leg.Volume = volume
+		if leg.Amending == volume {
+			leg.Amending = 0
+		}
+	}
+	if order.GetExecutedVolume() > leg.FilledVolume {
+		leg.FilledVolume = order.GetExecutedVolume()
+	}
+	if order.GetPositionId() != 0 {
+		leg.PositionId = order.GetPositionId()
+	}
+	if leg.final() {
+		leg.Cancelling = false
+	}
+
+	return true
+}
+
+// update runs the actions the state of group calls for.
+func (engine *OrderGroupEngine) update(group *OrderGroup) {
+	for {
+		engine.mu.Lock()
+		actions := engine.step(group)
+		// sent legs are saved first so a restart looks them up instead of sending them again
+		if err := engine.save(); err != nil {
+			logger.Warn(err.Error())
+		}
+		engine.mu.Unlock()
+		if len(actions) == 0 {
+			return
+		}
+
+		placed := false
+		for _, action := range actions {
+			var err error
+			switch action.actionType {
+			case placeLeg:
+				var order *openapi.ProtoOAOrder
+				if order, err = engine.submit(action.request); err == nil && order != nil {
+					engine.mu.Lock()
+					engine.applyOrder(group, order)
+					action.leg.failures, action.leg.retryAt = 0, time.Time{}
+					engine.mu.Unlock()
+					placed = true
+				}
+			case cancelLeg:
+				err = engine.cancel(action.orderId)
+			case amendLeg:
+				err = engine.amend(&openapi.ProtoOAAmendOrderReq{
+					OrderId:   proto.Int64(action.orderId),
+					Volume:    proto.Int64(action.volume),
+					StopPrice: action.leg.Request.StopPrice,
+				})
+			}
+			if err != nil {
+				logger.Warn(fmt.Sprintf("order group %s: %s", group.Id, err.Error()))
+				engine.mu.Lock()
+				action.leg.Error = err.Error()
+				switch action.actionType {
+				case placeLeg:
+					switch {
+					case rejected(err):
+						action.leg.Status = openapi.ProtoOAOrderStatus_ORDER_STATUS_REJECTED
+					case errors.Is(err, ErrOrderUnknown):
+						// Resume looks it up
+					default:
+						// not placed, the leg stays pending and is sent again
+						action.leg.Sent = false
+						engine.retry(group, action.leg)
+					}
+				case cancelLeg:
+					action.leg.Cancelling = false
+				case amendLeg:
+					action.leg.Amending = 0
+				}
+				engine.mu.Unlock()
+			}
+		}
+
+		engine.mu.Lock()
+		if err := engine.save(); err != nil {
+			logger.Warn(err.Error())
+		}
+		snapshot := group.copy()
+		engine.mu.Unlock()
+		engine.publish(snapshot)
+
+		// new orders may call for more actions, failed ones wait for a retry or the next event
+		if !placed {
+			return
+		}
+	}
+}
+
+// rejected reports whether the broker or the local checks refused an order, unlike failures on
+// the way to the broker such as a lost connection or the rate limit.
+func rejected(err error) bool {
+	var resErr *ResponseMessageHandlerError
+	var riskErr *RiskError
+	var validationErr *OrderValidationError
+	switch {
+	case errors.As(err, &riskErr), errors.As(err, &validationErr):
+		return true
+	case errors.As(err, &resErr):
+		return !isFrequencyExceeded(resErr)
+	}
+	return false
+}
+
+// retry sends leg again after a growing delay; the caller holds the lock.
+func (engine *OrderGroupEngine) retry(group *OrderGroup, leg *OrderGroupLeg) {
+	delay := engine.retryDelay << uint(leg.failures)
+	if delay >= orderGroupMaxRetryDelay {
+		delay = orderGroupMaxRetryDelay
+	} else {
+		leg.failures++
+	}
+	leg.retryAt = engine.now().Add(delay)
+
+	if engine.closed || engine.retries[group.Id] != nil {
+		return
+	}
+	engine.retries[group.Id] = time.AfterFunc(delay, func() {
+		engine.mu.Lock()
+		delete(engine.retries, group.Id)
+		closed := engine.closed
+		engine.mu.Unlock()
+		if !closed {
+			engine.update(group)
+		}
+	})
+}
+
+// step decides the next actions of group and marks them on its legs; the caller holds the lock.
+func (engine *OrderGroupEngine) step(group *OrderGroup) []orderGroupAction {
+	if group.Done {
+		return nil
+	}
+
+	var actions []orderGroupAction
+	place := func(leg *OrderGroupLeg) {
+		if engine.now().Before(leg.retryAt) {
+			return
+		}
+		leg.Sent = true
+		actions = append(actions, orderGroupAction{actionType: placeLeg, leg: leg, request: proto.Clone(leg.Request).(*openapi.ProtoOANewOrderReq)})
+	}
+	cancel := func(leg *OrderGroupLeg) {
+		if !leg.Sent && !leg.final() {
+			leg.Skipped = true
+		}
+		if leg.pending() && !leg.Cancelling {
+			leg.Cancelling = true
+			actions = append(actions, orderGroupAction{actionType: cancelLeg, leg: leg, orderId: leg.OrderId})
+		}
+	}
+
+	switch group.Type {
+	case OCOGroup:
+		triggered := group.Cancelled
+		for _, leg := range group.Legs {
+			if leg.FilledVolume > 0 || leg.final() {
+				triggered = true
+			}
+		}
+		for _, leg := range group.Legs {
+			switch {
+			case triggered && leg.FilledVolume == 0:
+				cancel(leg)
+			case !triggered && !leg.Sent:
+				place(leg)
+			}
+		}
+
+	case BracketGroup:
+		entry, stopLoss, takeProfits := group.Legs[0], group.Legs[1], group.Legs[2:]
+		exits := group.Legs[1:]
+
+		switch {
+		case !entry.final():
+			if group.Cancelled {
+				cancel(entry)
+				for _, leg := range exits {
+					cancel(leg)
+				}
+			} else if !entry.Sent {
+				place(entry)
+			}
+
+		case entry.FilledVolume == 0 || group.Cancelled || group.Closed:
+			for _, leg := range exits {
+				cancel(leg)
+			}
+
+		default:
+			remaining := entry.FilledVolume - stopLoss.FilledVolume
+			for _, leg := range takeProfits {
+				remaining -= leg.FilledVolume
+			}
+
+			unsent := false
+			for _, leg := range exits {
+				if !leg.Sent && !leg.final() {
+					unsent = true
+				}
+			}
+			if unsent && remaining > 0 && stopLoss.FilledVolume == 0 {
+				// size the exits to the filled volume, the same way on every retry
+				available := entry.FilledVolume
+				for _, leg := range takeProfits {
+					volume := leg.Request.GetVolume()
+					if volume > available {
+						volume = available
+					}
+					available -= volume
+					if leg.Sent || leg.final() {
+						continue
+					}
+					if volume == 0 {
+						leg.Skipped = true
+						continue
+					}
+					leg.Request.Volume = proto.Int64(volume)
+					leg.Request.PositionId = proto.Int64(entry.PositionId)
+					leg.Volume = volume
+					place(leg)
+				}
+				if !stopLoss.Sent && !stopLoss.final() {
+					stopLoss.Request.Volume = proto.Int64(entry.FilledVolume)
+					stopLoss.Request.PositionId = proto.Int64(entry.PositionId)
+					stopLoss.Volume = entry.FilledVolume
+					place(stopLoss)
+				}
+				break
+			}
+
+			if remaining <= 0 {
+				for _, leg := range exits {
+					cancel(leg)
+				}
+				break
+			}
+			if stopLoss.FilledVolume > 0 {
+				// the stop loss keeps closing the rest
+				for _, leg := range takeProfits {
+					if leg.FilledVolume == 0 {
+						cancel(leg)
+					}
+				}
+				break
+			}
+			if stopLoss.pending() && !stopLoss.Cancelling && stopLoss.Amending == 0 && stopLoss.Volume != remaining {
+				stopLoss.Amending = remaining
+				actions = append(actions, orderGroupAction{actionType: amendLeg, leg: stopLoss, orderId: stopLoss.OrderId, volume: remaining})
+			}
+		}
+
+	case ScaleInGroup:
+		for _, leg := range group.Legs {
+			switch {
+			case group.Cancelled || group.Closed:
+				cancel(leg)
+			case !leg.Sent:
+				place(leg)
+			}
+		}
+	}
+
+	done := true
+	for _, leg := range group.Legs {
+		if !leg.final() {
+			done = false
+		}
+	}
+	if done && len(actions) == 0 {
+		group.Done = true
+	}
+
+	return actions
+}
+
+// Resume catches up with executions missed while the groups were not watched: legs without a
+// known order are looked up by ClientOrderId, pending legs missing from the account are looked
+// up in the order history and closed positions are noticed.
+func (engine *OrderGroupEngine) Resume() error {
+	engine.mu.Lock()
+	var groups []*OrderGroup
+	for _, group := range engine.groups {
+		if !group.Done {
+			groups = append(groups, group)
+		}
+	}
+	engine.mu.Unlock()
+	if len(groups) == 0 {
+		return nil
+	}
+
+	res, err := engine.reconcile()
+	if err != nil {
+		return err
+	}
+	pending := map[int64]*openapi.ProtoOAOrder{}
+	for _, order := range res.GetOrder() {
+		pending[order.GetOrderId()] = order
+	}
+	open := map[int64]bool{}
+	for _, position := range res.GetPosition() {
+		open[position.GetPositionId()] = true
+	}
+
+	for _, group := range groups {
+		for _, leg := range group.Legs {
+			engine.mu.Lock()
+			order, isPending := pending[leg.OrderId]
+			missing := (leg.Sent && leg.OrderId == 0) || (leg.pending() && !isPending)
+			engine.mu.Unlock()
+
+			if !missing {
+				if isPending {
+					engine.mu.Lock()
+					engine.applyOrder(group, order)
+					engine.mu.Unlock()
+				}
+				continue
+			}
+
+			order, err := engine.lookup(leg.ClientOrderId, group.Created)
+			if err != nil {
+				return err
+			}
+
+			engine.mu.Lock()
+			if order != nil {
+				engine.applyOrder(group, order)
+			} else if leg.OrderId == 0 {
+				// never reached the broker
+				leg.Sent = false
+			}
+			engine.mu.Unlock()
+		}
+
+		engine.mu.Lock()
+		for _, leg := range group.Legs {
+			if leg.Role == EntryLeg && leg.FilledVolume > 0 && leg.PositionId != 0 && !open[leg.PositionId] {
+				group.Closed = true
+			}
+		}
+		if err := engine.save(); err != nil {
+			logger.Warn(err.Error())
+		}
+		engine.mu.Unlock()
+
+		engine.update(group)
+	}
+
+	return nil
+}
+
+// save writes the groups that are not done; the caller holds the lock.
+func (engine *OrderGroupEngine) save() error {
+	if engine.path == "" {
+		return nil
+	}
+
+	file := orderGroupFile{SavedAt: engine.now().UTC(), Groups: []*OrderGroup{}}
+	for _, group := range engine.groups {
+		if !group.Done {
+			file.Groups = append(file.Groups, group)
+		}
+	}
+	sort.Slice(file.Groups, func(i, j int) bool { return file.Groups[i].Created.Before(file.Groups[j].Created) })
+
+	b, err := json.Marshal(file)
+	if err != nil {
+		return err
+	}
+
+	return fsutil.WriteFile(engine.path, b)
+}
+
+// Load reads the groups saved at the engine path; a missing file is empty.
+func (engine *OrderGroupEngine) Load() error {
+	if engine.path == "" {
+		return nil
+	}
+
+	b, err := os.ReadFile(engine.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var file orderGroupFile
+	if err := json.Unmarshal(b, &file); err != nil {
+		return err
+	}
+
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+	for _, group := range file.Groups {
+		engine.index(group)
+	}
+
+	return nil
+}
+
+func (engine *OrderGroupEngine) publish(group OrderGroup) {
+	if err := engine.eventBus.SendBroadcastMessage(orderGroupChannel, group); err != nil {
+		logger.Warn(err.Error())
+	}
+}
+
+// OnUpdate delivers an OrderGroup after its orders changed.
+func (engine *OrderGroupEngine) OnUpdate() (bus.MessageHandler, error) {
+	return engine.eventBus.ListenFirehose(orderGroupChannel)
+}
+
+func (engine *OrderGroupEngine) Close() {
+	if engine.handler != nil {
+		engine.handler.Close()
+	}
+
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+	engine.closed = true
+	for _, timer := range engine.retries {
+		timer.Stop()
+	}
+	engine.retries = map[string]*time.Timer{}
+}
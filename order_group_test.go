@@ -0,0 +1,285 @@
+package ctrader
+
+import (
+	"errors"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ty2/ctrader-go/proto/openapi"
+	"github.com/vmware/transport-go/model"
+	"google.golang.org/protobuf/proto"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testBroker keeps the orders sent by an OrderGroupEngine.
+type testBroker struct {
+	mu        sync.Mutex
+	orders    map[int64]*openapi.ProtoOAOrder
+	requests  []*openapi.ProtoOANewOrderReq
+	cancelled []int64
+	amended   map[int64]int64
+	positions []*openapi.ProtoOAPosition
+	submitErr error
+}
+
+func newTestOrderGroupEngine(path string, broker *testBroker) *OrderGroupEngine {
+	engine := newOrderGroupEngine(path)
+	engine.submit = func(req *openapi.ProtoOANewOrderReq) (*openapi.ProtoOAOrder, error) {
+		broker.mu.Lock()
+		defer broker.mu.Unlock()
+
+		broker.requests = append(broker.requests, req)
+		if broker.submitErr != nil {
+			return nil, broker.submitErr
+		}
+		order := &openapi.ProtoOAOrder{
+			OrderId:                proto.Int64(int64(100 + len(broker.orders))),
+			ClientOrderId:          req.ClientOrderId,
+			OrderType:              req.OrderType,
+			OrderStatus:            openapi.ProtoOAOrderStatus_ORDER_STATUS_ACCEPTED.Enum(),
+			TradeData:              &openapi.ProtoOATradeData{SymbolId: req.SymbolId, Volume: req.Volume, TradeSide: req.TradeSide},
+			PositionId:             req.PositionId,
+			UtcLastUpdateTimestamp: proto.Int64(1),
+		}
+		broker.orders[order.GetOrderId()] = order
+		return order, nil
+	}
+	engine.cancel = func(orderId int64) error {
+		broker.mu.Lock()
+		defer broker.mu.Unlock()
+		broker.cancelled = append(broker.cancelled, orderId)
+		return nil
+	}
+	engine.amend = func(req *openapi.ProtoOAAmendOrderReq) error {
+		broker.mu.Lock()
+		defer broker.mu.Unlock()
+		broker.amended[req.GetOrderId()] = req.GetVolume()
+		return nil
+	}
+	engine.reconcile = func() (*openapi.ProtoOAReconcileRes, error) {
+		broker.mu.Lock()
+		defer broker.mu.Unlock()
+		res := &openapi.ProtoOAReconcileRes{Position: broker.positions}
+		for _, order := range broker.orders {
+			if order.GetOrderStatus() == openapi.ProtoOAOrderStatus_ORDER_STATUS_ACCEPTED {
+				res.Order = append(res.Order, order)
+			}
+		}
+		return res, nil
+	}
+	engine.lookup = func(clientOrderId string, since time.Time) (*openapi.ProtoOAOrder, error) {
+		broker.mu.Lock()
+		defer broker.mu.Unlock()
+		for _, order := range broker.orders {
+			if order.GetClientOrderId() == clientOrderId {
+				return order, nil
+			}
+		}
+		return nil, nil
+	}
+
+	return engine
+}
+
+// update changes an order at the broker and returns its execution event.
+func (broker *testBroker) update(orderId int64, status openapi.ProtoOAOrderStatus, executed int64, positionId int64) *openapi.ProtoOAExecutionEvent {
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+
+	order := proto.Clone(broker.orders[orderId]).(*openapi.ProtoOAOrder)
+	order.OrderStatus = status.Enum()
+	order.ExecutedVolume = proto.Int64(executed)
+	order.UtcLastUpdateTimestamp = proto.Int64(order.GetUtcLastUpdateTimestamp() + 1)
+	if positionId != 0 {
+		order.PositionId = proto.Int64(positionId)
+	}
+	broker.orders[orderId] = order
+
+	executionType := openapi.ProtoOAExecutionType_ORDER_ACCEPTED
+	switch {
+	case status == openapi.ProtoOAOrderStatus_ORDER_STATUS_FILLED:
+		executionType = openapi.ProtoOAExecutionType_ORDER_FILLED
+	case status == openapi.ProtoOAOrderStatus_ORDER_STATUS_CANCELLED:
+		executionType = openapi.ProtoOAExecutionType_ORDER_CANCELLED
+	case executed > 0:
+		executionType = openapi.ProtoOAExecutionType_ORDER_PARTIAL_FILL
+	}
+	return execution(executionType, nil, order)
+}
+
+func (broker *testBroker) requestCount() int {
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+	return len(broker.requests)
+}
+
+func (broker *testBroker) fail(err error) {
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+	broker.submitErr = err
+}
+
+func (broker *testBroker) cancelledOrders() []int64 {
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+	return append([]int64{}, broker.cancelled...)
+}
+
+func groupLimit(side openapi.ProtoOATradeSide, volume int64, price float64) *openapi.ProtoOANewOrderReq {
+	return &openapi.ProtoOANewOrderReq{
+		SymbolId:   proto.Int64(1),
+		OrderType:  openapi.ProtoOAOrderType_LIMIT.Enum(),
+		TradeSide:  side.Enum(),
+		Volume:     proto.Int64(volume),
+		LimitPrice: proto.Float64(price),
+	}
+}
+
+func TestOrderGroupEngine(t *testing.T) {
+	Convey("OrderGroupEngine", t, func() {
+		broker := &testBroker{orders: map[int64]*openapi.ProtoOAOrder{}, amended: map[int64]int64{}}
+		path := filepath.Join(t.TempDir(), "groups.json")
+		engine := newTestOrderGroupEngine(path, broker)
+		buy, sell := openapi.ProtoOATradeSide_BUY, openapi.ProtoOATradeSide_SELL
+		filled, cancelled := openapi.ProtoOAOrderStatus_ORDER_STATUS_FILLED, openapi.ProtoOAOrderStatus_ORDER_STATUS_CANCELLED
+
+		Convey("OCO cancels the other orders after a fill", func() {
+			group, err := engine.OCO(groupLimit(buy, 100000, 1.09), groupLimit(sell, 100000, 1.12))
+			So(err, ShouldBeNil)
+			So(broker.requests, ShouldHaveLength, 2)
+			So(broker.requests[0].GetClientOrderId(), ShouldEqual, group.Id+"-0")
+			So(group.Legs[1].OrderId, ShouldEqual, 101)
+
+			engine.Apply(broker.update(100, filled, 100000, 5))
+			So(broker.cancelledOrders(), ShouldResemble, []int64{101})
+
+			engine.Apply(broker.update(101, cancelled, 0, 0))
+			group, _ = engine.Group(group.Id)
+			So(group.Done, ShouldBeTrue)
+		})
+
+		Convey("Bracket places exits on the entry position", func() {
+			group, err := engine.Bracket(groupLimit(buy, 100000, 1.1), 1.09,
+				TakeProfitLevel{Price: 1.12, Volume: 50000}, TakeProfitLevel{Price: 1.13, Volume: 50000})
+			So(err, ShouldBeNil)
+			So(broker.requests, ShouldHaveLength, 1)
+
+			// partially filled and then cancelled, the exits cover the filled volume
+			engine.Apply(broker.update(100, openapi.ProtoOAOrderStatus_ORDER_STATUS_ACCEPTED, 70000, 5))
+			So(broker.requests, ShouldHaveLength, 1)
+			engine.Apply(broker.update(100, cancelled, 70000, 5))
+			So(broker.requests, ShouldHaveLength, 4)
+			tp1, tp2, sl := broker.requests[1], broker.requests[2], broker.requests[3]
+			So(tp1.GetVolume(), ShouldEqual, 50000)
+			So(tp2.GetVolume(), ShouldEqual, 20000)
+			So(sl.GetVolume(), ShouldEqual, 70000)
+			So(sl.GetTradeSide(), ShouldEqual, sell)
+			So(sl.GetPositionId(), ShouldEqual, 5)
+
+			group, _ = engine.Group(group.Id)
+			slId, tp1Id, tp2Id := group.Legs[1].OrderId, group.Legs[2].OrderId, group.Legs[3].OrderId
+
+			Convey("take profits reduce the stop loss", func() {
+				engine.Apply(broker.update(tp1Id, filled, 50000, 5))
+				So(broker.amended[slId], ShouldEqual, 20000)
+
+				engine.Apply(broker.update(tp2Id, filled, 20000, 5))
+				So(broker.cancelledOrders(), ShouldResemble, []int64{slId})
+			})
+
+			Convey("the stop loss cancels the take profits", func() {
+				engine.Apply(broker.update(slId, filled, 70000, 5))
+				So(broker.cancelledOrders(), ShouldHaveLength, 2)
+			})
+		})
+
+		Convey("ScaleIn cancels the rest once a position is closed", func() {
+			_, err := engine.ScaleIn(groupLimit(buy, 100000, 1.1), groupLimit(buy, 100000, 1.09), groupLimit(buy, 100000, 1.08))
+			So(err, ShouldBeNil)
+
+			engine.Apply(broker.update(100, filled, 100000, 7))
+			So(broker.cancelledOrders(), ShouldBeEmpty)
+
+			closed := testPosition(7, 0, openapi.ProtoOAPositionStatus_POSITION_STATUS_CLOSED, 2)
+			engine.Apply(execution(openapi.ProtoOAExecutionType_ORDER_FILLED, closed, nil))
+			So(broker.cancelledOrders(), ShouldResemble, []int64{101, 102})
+		})
+
+		Convey("Cancel cancels a group", func() {
+			group, err := engine.Bracket(groupLimit(sell, 100000, 1.1), 1.11)
+			So(err, ShouldBeNil)
+			So(engine.Cancel(group.Id), ShouldBeNil)
+			So(broker.cancelledOrders(), ShouldResemble, []int64{100})
+			So(engine.Cancel("missing"), ShouldNotBeNil)
+		})
+
+		Convey("resumes saved groups", func() {
+			group, err := engine.OCO(groupLimit(buy, 100000, 1.09), groupLimit(sell, 100000, 1.12))
+			So(err, ShouldBeNil)
+			engine.Close()
+
+			// filled while nobody watched
+			broker.update(100, filled, 100000, 5)
+
+			resumed := newTestOrderGroupEngine(path, broker)
+			So(resumed.Load(), ShouldBeNil)
+			So(resumed.Groups(), ShouldHaveLength, 1)
+			So(resumed.Resume(), ShouldBeNil)
+			So(broker.cancelledOrders(), ShouldResemble, []int64{101})
+
+			saved, _ := resumed.Group(group.Id)
+			So(saved.Legs[0].Request.GetLimitPrice(), ShouldEqual, 1.09)
+			So(saved.Legs[0].FilledVolume, ShouldEqual, 100000)
+		})
+
+		Convey("retries orders that did not reach the broker", func() {
+			engine.retryDelay = 10 * time.Millisecond
+			broker.fail(errors.New("connection closed"))
+			group, err := engine.OCO(groupLimit(buy, 100000, 1.09), groupLimit(sell, 100000, 1.12))
+			So(err, ShouldBeNil)
+			So(broker.requestCount(), ShouldEqual, 2)
+			group, _ = engine.Group(group.Id)
+			So(group.Legs[0].Status, ShouldNotEqual, openapi.ProtoOAOrderStatus_ORDER_STATUS_REJECTED)
+			So(group.Legs[0].Sent, ShouldBeFalse)
+
+			broker.fail(nil)
+			for i := 0; i < 100 && broker.requestCount() < 4; i++ {
+				time.Sleep(10 * time.Millisecond)
+			}
+			So(broker.requestCount(), ShouldEqual, 4)
+			So(broker.cancelledOrders(), ShouldBeEmpty)
+			group, _ = engine.Group(group.Id)
+			So(group.Legs[0].OrderId, ShouldNotEqual, 0)
+			So(group.Legs[1].OrderId, ShouldNotEqual, 0)
+		})
+
+		Convey("rejects legs the broker refused", func() {
+			broker.fail(&ResponseMessageHandlerError{&model.Message{Payload: &openapi.ProtoOAOrderErrorEvent{ErrorCode: proto.String("NOT_ENOUGH_MONEY")}}})
+			group, err := engine.ScaleIn(groupLimit(buy, 100000, 1.1))
+			So(err, ShouldBeNil)
+			group, _ = engine.Group(group.Id)
+			So(group.Legs[0].Status, ShouldEqual, openapi.ProtoOAOrderStatus_ORDER_STATUS_REJECTED)
+			So(engine.retries, ShouldBeEmpty)
+		})
+
+		Convey("sends orders again only if they never arrived", func() {
+			broker.submitErr = ErrOrderUnknown
+			_, err := engine.ScaleIn(groupLimit(buy, 100000, 1.1))
+			So(err, ShouldBeNil)
+			So(broker.requests, ShouldHaveLength, 1)
+
+			broker.submitErr = nil
+			resumed := newTestOrderGroupEngine(path, broker)
+			So(resumed.Load(), ShouldBeNil)
+			So(resumed.Resume(), ShouldBeNil)
+			So(broker.requests, ShouldHaveLength, 2)
+
+			// known to the broker now, nothing is sent
+			again := newTestOrderGroupEngine(path, broker)
+			So(again.Load(), ShouldBeNil)
+			So(again.Resume(), ShouldBeNil)
+			So(broker.requests, ShouldHaveLength, 2)
+		})
+	})
+}
@@ -0,0 +1,610 @@
+package ctrader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/ty2/ctrader-go/proto/openapi"
+	"github.com/vmware/transport-go/bus"
+	"github.com/vmware/transport-go/model"
+	"google.golang.org/protobuf/proto"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrOrderDone is returned by OrderHandle.Wait when the order ended in another status.
+var ErrOrderDone = errors.New("order is done")
+
+// ErrOrderUnknown is returned by Submit when the request failed without a response and the order
+// could not be looked up either. Submitting the same request again repeats the lookup before it
+// sends anything.
+var ErrOrderUnknown = errors.New("order state is unknown")
+
+// maxOrphanOrders bounds the orders whose events are kept until a handle claims them.
+const maxOrphanOrders = 256
+
+// maxCompletedOrders bounds the finished orders whose ClientOrderIds Submit still recognizes.
+const maxCompletedOrders = 1024
+
+const (
+	// submitLookupDelay gives an order sent before a timeout the time to reach the broker.
+	submitLookupDelay = 2 * time.Second
+	// submitLookupWindow widens the order history searched around a submission.
+	submitLookupWindow = time.Minute
+)
+
+func isFinalOrderStatus(status openapi.ProtoOAOrderStatus) bool {
+	switch status {
+	case openapi.ProtoOAOrderStatus_ORDER_STATUS_FILLED,
+		openapi.ProtoOAOrderStatus_ORDER_STATUS_REJECTED,
+		openapi.ProtoOAOrderStatus_ORDER_STATUS_EXPIRED,
+		openapi.ProtoOAOrderStatus_ORDER_STATUS_CANCELLED:
+		return true
+	}
+	return false
+}
+
+// OrderHandle follows one order from submission to its final status. Events may arrive twice
+// and out of order; fills are kept once per deal and status changes never go back in time.
+type OrderHandle struct {
+	clientOrderId string
+
+	mu        sync.Mutex
+	orderId   int64
+	order     *openapi.ProtoOAOrder
+	status    openapi.ProtoOAOrderStatus
+	fills     []*openapi.ProtoOADeal
+	deals     map[int64]bool
+	errorCode string
+	changed   chan struct{}
+	done      chan struct{}
+}
+
+func newOrderHandle(clientOrderId string) *OrderHandle {
+	return &OrderHandle{
+		clientOrderId: clientOrderId,
+		deals:         map[int64]bool{},
+		changed:       make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+}
+
+func (handle *OrderHandle) ClientOrderId() string {
+	return handle.clientOrderId
+}
+
+// OrderId is zero until the order was accepted.
+func (handle *OrderHandle) OrderId() int64 {
+	handle.mu.Lock()
+	defer handle.mu.Unlock()
+	return handle.orderId
+}
+
+// Order is the order of the latest event.
+func (handle *OrderHandle) Order() *openapi.ProtoOAOrder {
+	handle.mu.Lock()
+	defer handle.mu.Unlock()
+	return handle.order
+}
+
+func (handle *OrderHandle) Status() openapi.ProtoOAOrderStatus {
+	handle.mu.Lock()
+	defer handle.mu.Unlock()
+	return handle.status
+}
+
+// Fills returns the deals of the order sorted by execution time.
+func (handle *OrderHandle) Fills() []*openapi.ProtoOADeal {
+	handle.mu.Lock()
+	defer handle.mu.Unlock()
+	return append([]*openapi.ProtoOADeal{}, handle.fills...)
+}
+
+// ErrorCode is the error code of a rejection or an order error event.
+func (handle *OrderHandle) ErrorCode() string {
+	handle.mu.Lock()
+	defer handle.mu.Unlock()
+	return handle.errorCode
+}
+
+// Done is closed when the order is filled, rejected, expired or cancelled.
+func (handle *OrderHandle) Done() <-chan struct{} {
+	return handle.done
+}
+
+// Wait blocks until the order has status. It fails with ErrOrderDone if the order ends in another
+// status first.
+func (handle *OrderHandle) Wait(ctx context.Context, status openapi.ProtoOAOrderStatus) error {
+	for {
+		handle.mu.Lock()
+		current, changed := handle.status, handle.changed
+		handle.mu.Unlock()
+
+		if current == status {
+			return nil
+		}
+		if isFinalOrderStatus(current) {
+			return fmt.Errorf("%w: order %d is %s", ErrOrderDone, handle.OrderId(), current)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// notify wakes up waiters; the caller holds the lock.
+func (handle *OrderHandle) notify() {
+	close(handle.changed)
+	handle.changed = make(chan struct{})
+}
+
+func (handle *OrderHandle) apply(event *openapi.ProtoOAExecutionEvent) {
+	handle.mu.Lock()
+	defer handle.mu.Unlock()
+
+	order := event.GetOrder()
+	if handle.orderId == 0 {
+		handle.orderId = order.GetOrderId()
+	}
+
+	updated := false
+	executionType := event.GetExecutionType()
+	if deal := event.GetDeal(); deal != nil && !handle.deals[deal.GetDealId()] &&
+		(executionType == openapi.ProtoOAExecutionType_ORDER_FILLED || executionType == openapi.ProtoOAExecutionType_ORDER_PARTIAL_FILL) {
+		handle.deals[deal.GetDealId()] = true
+		handle.fills = append(handle.fills, deal)
+		sort.Slice(handle.fills, func(i, j int) bool {
+			if handle.fills[i].GetExecutionTimestamp() != handle.fills[j].GetExecutionTimestamp() {
+				return handle.fills[i].GetExecutionTimestamp() < handle.fills[j].GetExecutionTimestamp()
+			}
+			return handle.fills[i].GetDealId() < handle.fills[j].GetDealId()
+		})
+		updated = true
+	}
+	if executionType == openapi.ProtoOAExecutionType_ORDER_REJECTED && event.ErrorCode != nil {
+		handle.errorCode = event.GetErrorCode()
+	}
+
+	final := isFinalOrderStatus(handle.status)
+	stale := handle.order != nil && order.GetUtcLastUpdateTimestamp() < handle.order.GetUtcLastUpdateTimestamp()
+	if !final && !stale && order.OrderStatus != nil {
+		handle.order = order
+		if handle.status != order.GetOrderStatus() {
+			handle.status = order.GetOrderStatus()
+			updated = true
+		}
+		if isFinalOrderStatus(handle.status) {
+			close(handle.done)
+		}
+	}
+
+	if updated {
+		handle.notify()
+	}
+}
+
+func (handle *OrderHandle) applyError(event *openapi.ProtoOAOrderErrorEvent) {
+	handle.mu.Lock()
+	defer handle.mu.Unlock()
+	handle.errorCode = event.GetErrorCode()
+	handle.notify()
+}
+
+type orphanOrder struct {
+	events []*openapi.ProtoOAExecutionEvent
+	errors []*openapi.ProtoOAOrderErrorEvent
+}
+
+// OrderTracker routes execution and order error events to OrderHandles by order id, or by
+// ClientOrderId before the order id is known. Events of untracked orders are kept for a while so
+// a handle created after its first events still sees them.
+type OrderTracker struct {
+	newOrder      func(req *openapi.ProtoOANewOrderReq) (*openapi.ProtoOAExecutionEvent, error)
+	pendingOrders func() ([]*openapi.ProtoOAOrder, error)
+	orderList     func(from, to time.Time) ([]*openapi.ProtoOAOrder, error)
+	now           func() time.Time
+	sleep         func(d time.Duration)
+
+	mu             sync.Mutex
+	byOrderId      map[int64]*OrderHandle
+	byClientId     map[string]*OrderHandle
+	orphans        map[int64]*orphanOrder
+	orphanOrderIds []int64
+	completed      map[string]*OrderHandle
+	completedIds   []string
+
+	handlers []bus.MessageHandler
+}
+
+func NewOrderTracker() *OrderTracker {
+	return &OrderTracker{
+		now:        time.Now,
+		sleep:      time.Sleep,
+		byOrderId:  map[int64]*OrderHandle{},
+		byClientId: map[string]*OrderHandle{},
+		orphans:    map[int64]*orphanOrder{},
+		completed:  map[string]*OrderHandle{},
+	}
+}
+
+// OrderTracker returns the tracker of the account, listening to its execution and order error
+// events. It is created on first use and shared.
+func (account *Account) OrderTracker() (*OrderTracker, error) {
+	account.ordersMu.Lock()
+	defer account.ordersMu.Unlock()
+
+	if account.orders != nil {
+		return account.orders, nil
+	}
+
+	tracker := NewOrderTracker()
+	tracker.newOrder = account.NewOrder
+	tracker.pendingOrders = func() ([]*openapi.ProtoOAOrder, error) {
+		res, err := account.Reconcile()
+		if err != nil {
+			return nil, err
+		}
+		return res.GetOrder(), nil
+	}
+	tracker.orderList = func(from, to time.Time) ([]*openapi.ProtoOAOrder, error) {
+		res, err := account.OrderList(from.UnixNano()/int64(time.Millisecond), to.UnixNano()/int64(time.Millisecond))
+		if err != nil {
+			return nil, err
+		}
+		return res.GetOrder(), nil
+	}
+
+	listeners := []struct {
+		on    func() (bus.MessageHandler, error)
+		apply func(payload interface{})
+	}{
+		{account.OnExecution, func(payload interface{}) {
+			if v, ok := payload.(*openapi.ProtoOAExecutionEvent); ok {
+				tracker.Apply(v)
+			}
+		}},
+		{account.OnOrderError, func(payload interface{}) {
+			if v, ok := payload.(*openapi.ProtoOAOrderErrorEvent); ok {
+				tracker.ApplyError(v)
+			}
+		}},
+	}
+	for _, listener := range listeners {
+		handler, err := listener.on()
+		if err != nil {
+			tracker.Close()
+			return nil, err
+		}
+
+		apply := listener.apply
+		handler.Handle(
+			func(msg *model.Message) {
+				apply(msg.Payload)
+			},
+			func(err error) {
+				logger.Warn(err.Error())
+			})
+		tracker.handlers = append(tracker.handlers, handler)
+	}
+
+	account.orders = tracker
+	return tracker, nil
+}
+
+// SubmitOrder sends a new order with OrderTracker.Submit.
+func (account *Account) SubmitOrder(req *openapi.ProtoOANewOrderReq) (*OrderHandle, error) {
+	tracker, err := account.OrderTracker()
+	if err != nil {
+		return nil, err
+	}
+
+	return tracker.Submit(req)
+}
+
+func newClientOrderId() string {
+	return uuid.New().String()
+}
+
+// uncertain reports whether a failed request may still have placed the order: it timed out or
+// failed while being written to the connection.
+func uncertain(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, ErrTimeout) || errors.As(err, &netErr)
+}
+
+// Submit sends a new order and returns a handle following it. Requests without a ClientOrderId
+// get a unique one, set on req, which makes them safe to submit again: a ClientOrderId the
+// tracker or the broker already knows is not sent twice. req itself is not sent, so it can be
+// submitted again as is. When a request times out or fails on the connection, the pending orders
+// and the order history are searched for it; the error is only returned if the order was not
+// placed. Other errors are returned right away.
+func (tracker *OrderTracker) Submit(req *openapi.ProtoOANewOrderReq) (*OrderHandle, error) {
+	if req.GetClientOrderId() == "" {
+		clientOrderId := newClientOrderId()
+		req.ClientOrderId = &clientOrderId
+	}
+	clientOrderId := req.GetClientOrderId()
+
+	handle, ok := tracker.claim(clientOrderId)
+	if ok {
+		if handle.OrderId() != 0 {
+			return handle, nil
+		}
+
+		// an earlier submission ended unknown
+		order, err := tracker.lookup(clientOrderId, tracker.now())
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrOrderUnknown, err)
+		}
+		if order != nil {
+			tracker.Bind(handle, order.GetOrderId())
+			tracker.deliver(handle, &openapi.ProtoOAExecutionEvent{Order: order})
+			return handle, nil
+		}
+	} else {
+		var err error
+		if handle, err = tracker.Track(clientOrderId); err != nil {
+			return nil, err
+		}
+	}
+
+	submitted := tracker.now()
+	res, err := tracker.newOrder(proto.Clone(req).(*openapi.ProtoOANewOrderReq))
+	if err != nil {
+		if !uncertain(err) {
+			tracker.Forget(handle)
+			return nil, err
+		}
+
+		tracker.sleep(submitLookupDelay)
+		order, lookupErr := tracker.lookup(clientOrderId, submitted)
+		if lookupErr != nil {
+			// keep the handle so late events and the next Submit still find it
+			return nil, fmt.Errorf("%w: %v after %v", ErrOrderUnknown, lookupErr, err)
+		}
+		if order == nil {
+			tracker.Forget(handle)
+			return nil, fmt.Errorf("order %s was not placed: %w", clientOrderId, err)
+		}
+
+		tracker.Bind(handle, order.GetOrderId())
+		tracker.deliver(handle, &openapi.ProtoOAExecutionEvent{Order: order})
+		return handle, nil
+	}
+
+	tracker.Bind(handle, res.GetOrder().GetOrderId())
+	tracker.deliver(handle, res)
+	return handle, nil
+}
+
+// claim returns the tracked or completed handle of clientOrderId, or a new handle for an untracked
+// order whose events carried it.
+func (tracker *OrderTracker) claim(clientOrderId string) (*OrderHandle, bool) {
+	tracker.mu.Lock()
+	if handle, ok := tracker.byClientId[clientOrderId]; ok {
+		tracker.mu.Unlock()
+		return handle, true
+	}
+	if handle, ok := tracker.completed[clientOrderId]; ok {
+		tracker.mu.Unlock()
+		return handle, true
+	}
+
+	var orderId int64
+	for id, orphan := range tracker.orphans {
+		for _, event := range orphan.events {
+			if event.GetOrder().GetClientOrderId() == clientOrderId {
+				orderId = id
+			}
+		}
+	}
+	tracker.mu.Unlock()
+	if orderId == 0 {
+		return nil, false
+	}
+
+	handle, err := tracker.Track(clientOrderId)
+	if err != nil {
+		// tracked concurrently
+		return tracker.HandleByClientOrderId(clientOrderId)
+	}
+	tracker.Bind(handle, orderId)
+	return handle, true
+}
+
+// lookup searches the pending orders and the order history around submitted for clientOrderId.
+func (tracker *OrderTracker) lookup(clientOrderId string, submitted time.Time) (*openapi.ProtoOAOrder, error) {
+	pending, err := tracker.pendingOrders()
+	if err != nil {
+		return nil, err
+	}
+	for _, order := range pending {
+		if order.GetClientOrderId() == clientOrderId {
+			return order, nil
+		}
+	}
+
+	history, err := tracker.orderList(submitted.Add(-submitLookupWindow), tracker.now().Add(submitLookupWindow))
+	if err != nil {
+		return nil, err
+	}
+	for _, order := range history {
+		if order.GetClientOrderId() == clientOrderId {
+			return order, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// Track returns a handle for an order about to be sent. An empty clientOrderId tracks nothing
+// until Bind.
+func (tracker *OrderTracker) Track(clientOrderId string) (*OrderHandle, error) {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	if clientOrderId != "" {
+		if _, ok := tracker.byClientId[clientOrderId]; ok {
+			return nil, fmt.Errorf("client order id %s is already tracked", clientOrderId)
+		}
+	}
+
+	handle := newOrderHandle(clientOrderId)
+	if clientOrderId != "" {
+		tracker.byClientId[clientOrderId] = handle
+	}
+
+	return handle, nil
+}
+
+// Bind routes the events of orderId to handle, including the ones that arrived before.
+func (tracker *OrderTracker) Bind(handle *OrderHandle, orderId int64) {
+	if orderId == 0 {
+		return
+	}
+
+	tracker.mu.Lock()
+	tracker.byOrderId[orderId] = handle
+	orphan := tracker.orphans[orderId]
+	delete(tracker.orphans, orderId)
+	tracker.mu.Unlock()
+
+	if orphan != nil {
+		for _, event := range orphan.events {
+			tracker.deliver(handle, event)
+		}
+		for _, event := range orphan.errors {
+			handle.applyError(event)
+		}
+	}
+}
+
+// Forget stops tracking handle.
+func (tracker *OrderTracker) Forget(handle *OrderHandle) {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	if handle.clientOrderId != "" && tracker.byClientId[handle.clientOrderId] == handle {
+		delete(tracker.byClientId, handle.clientOrderId)
+	}
+	if orderId := handle.OrderId(); tracker.byOrderId[orderId] == handle {
+		delete(tracker.byOrderId, orderId)
+	}
+}
+
+// Handle returns the tracked handle of an order.
+func (tracker *OrderTracker) Handle(orderId int64) (*OrderHandle, bool) {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+	handle, ok := tracker.byOrderId[orderId]
+	return handle, ok
+}
+
+// HandleByClientOrderId returns the tracked handle of a ClientOrderId.
+func (tracker *OrderTracker) HandleByClientOrderId(clientOrderId string) (*OrderHandle, bool) {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+	handle, ok := tracker.byClientId[clientOrderId]
+	return handle, ok
+}
+
+// orphan keeps an event of an untracked order; the caller holds the lock.
+func (tracker *OrderTracker) orphan(orderId int64) *orphanOrder {
+	orphan, ok := tracker.orphans[orderId]
+	if !ok {
+		orphan = &orphanOrder{}
+		tracker.orphans[orderId] = orphan
+		tracker.orphanOrderIds = append(tracker.orphanOrderIds, orderId)
+		if len(tracker.orphanOrderIds) > maxOrphanOrders {
+			delete(tracker.orphans, tracker.orphanOrderIds[0])
+			tracker.orphanOrderIds = tracker.orphanOrderIds[1:]
+		}
+	}
+	return orphan
+}
+
+func (tracker *OrderTracker) Apply(event *openapi.ProtoOAExecutionEvent) {
+	order := event.GetOrder()
+	if order == nil {
+		return
+	}
+
+	tracker.mu.Lock()
+	handle, ok := tracker.byOrderId[order.GetOrderId()]
+	if !ok && order.GetClientOrderId() != "" {
+		if handle, ok = tracker.byClientId[order.GetClientOrderId()]; ok && order.GetOrderId() != 0 {
+			tracker.byOrderId[order.GetOrderId()] = handle
+		}
+	}
+	if !ok {
+		if order.GetOrderId() != 0 {
+			orphan := tracker.orphan(order.GetOrderId())
+			orphan.events = append(orphan.events, event)
+		}
+		tracker.mu.Unlock()
+		return
+	}
+	tracker.mu.Unlock()
+
+	tracker.deliver(handle, event)
+}
+
+func (tracker *OrderTracker) deliver(handle *OrderHandle, event *openapi.ProtoOAExecutionEvent) {
+	handle.apply(event)
+
+	select {
+	case <-handle.Done():
+		tracker.complete(handle)
+	default:
+	}
+}
+
+// complete stops tracking a finished handle but remembers its ClientOrderId.
+func (tracker *OrderTracker) complete(handle *OrderHandle) {
+	tracker.Forget(handle)
+	if handle.clientOrderId == "" {
+		return
+	}
+
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+	if _, ok := tracker.completed[handle.clientOrderId]; ok {
+		return
+	}
+	tracker.completed[handle.clientOrderId] = handle
+	tracker.completedIds = append(tracker.completedIds, handle.clientOrderId)
+	if len(tracker.completedIds) > maxCompletedOrders {
+		delete(tracker.completed, tracker.completedIds[0])
+		tracker.completedIds = tracker.completedIds[1:]
+	}
+}
+
+func (tracker *OrderTracker) ApplyError(event *openapi.ProtoOAOrderErrorEvent) {
+	if event.GetOrderId() == 0 {
+		return
+	}
+
+	tracker.mu.Lock()
+	handle, ok := tracker.byOrderId[event.GetOrderId()]
+	if !ok {
+		orphan := tracker.orphan(event.GetOrderId())
+		orphan.errors = append(orphan.errors, event)
+	}
+	tracker.mu.Unlock()
+
+	if ok {
+		handle.applyError(event)
+	}
+}
+
+func (tracker *OrderTracker) Close() {
+	for _, handler := range tracker.handlers {
+		handler.Close()
+	}
+}
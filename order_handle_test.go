@@ -0,0 +1,242 @@
+package ctrader
+
+import (
+	"context"
+	"errors"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ty2/ctrader-go/proto/openapi"
+	"google.golang.org/protobuf/proto"
+	"testing"
+	"time"
+)
+
+func testFill(executionType openapi.ProtoOAExecutionType, order *openapi.ProtoOAOrder, dealId, executed int64) *openapi.ProtoOAExecutionEvent {
+	event := execution(executionType, nil, order)
+	event.Deal = &openapi.ProtoOADeal{DealId: proto.Int64(dealId), OrderId: order.OrderId, ExecutionTimestamp: proto.Int64(executed)}
+	return event
+}
+
+func TestOrderTracker(t *testing.T) {
+	Convey("OrderTracker", t, func() {
+		tracker := NewOrderTracker()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		accepted := testOrder(1, openapi.ProtoOAOrderType_LIMIT, openapi.ProtoOAOrderStatus_ORDER_STATUS_ACCEPTED, 1)
+		accepted.ClientOrderId = proto.String("abc")
+		partial := proto.Clone(accepted).(*openapi.ProtoOAOrder)
+		partial.UtcLastUpdateTimestamp = proto.Int64(2)
+		filled := proto.Clone(accepted).(*openapi.ProtoOAOrder)
+		filled.OrderStatus = openapi.ProtoOAOrderStatus_ORDER_STATUS_FILLED.Enum()
+		filled.UtcLastUpdateTimestamp = proto.Int64(3)
+
+		handle, err := tracker.Track("abc")
+		So(err, ShouldBeNil)
+		_, err = tracker.Track("abc")
+		So(err, ShouldNotBeNil)
+
+		Convey("correlates by ClientOrderId and follows fills", func() {
+			tracker.Apply(execution(openapi.ProtoOAExecutionType_ORDER_ACCEPTED, nil, accepted))
+			So(handle.OrderId(), ShouldEqual, 1)
+			So(handle.Wait(ctx, openapi.ProtoOAOrderStatus_ORDER_STATUS_ACCEPTED), ShouldBeNil)
+
+			tracker.Apply(testFill(openapi.ProtoOAExecutionType_ORDER_PARTIAL_FILL, partial, 10, 2))
+			tracker.Apply(testFill(openapi.ProtoOAExecutionType_ORDER_PARTIAL_FILL, partial, 10, 2))
+			So(handle.Fills(), ShouldHaveLength, 1)
+
+			done := make(chan error)
+			go func() { done <- handle.Wait(ctx, openapi.ProtoOAOrderStatus_ORDER_STATUS_FILLED) }()
+			tracker.Apply(testFill(openapi.ProtoOAExecutionType_ORDER_FILLED, filled, 11, 3))
+			So(<-done, ShouldBeNil)
+			<-handle.Done()
+
+			fills := handle.Fills()
+			So(fills, ShouldHaveLength, 2)
+			So(fills[1].GetDealId(), ShouldEqual, 11)
+
+			_, ok := tracker.HandleByClientOrderId("abc")
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("ignores events older than the current status", func() {
+			tracker.Apply(testFill(openapi.ProtoOAExecutionType_ORDER_FILLED, filled, 11, 3))
+			tracker.Apply(execution(openapi.ProtoOAExecutionType_ORDER_ACCEPTED, nil, accepted))
+			So(handle.Status(), ShouldEqual, openapi.ProtoOAOrderStatus_ORDER_STATUS_FILLED)
+
+			err := handle.Wait(ctx, openapi.ProtoOAOrderStatus_ORDER_STATUS_CANCELLED)
+			So(errors.Is(err, ErrOrderDone), ShouldBeTrue)
+		})
+
+		Convey("replays events that arrived before the handle was bound", func() {
+			other, err := tracker.Track("")
+			So(err, ShouldBeNil)
+			pending := testOrder(2, openapi.ProtoOAOrderType_STOP, openapi.ProtoOAOrderStatus_ORDER_STATUS_ACCEPTED, 1)
+			cancelled := proto.Clone(pending).(*openapi.ProtoOAOrder)
+			cancelled.OrderStatus = openapi.ProtoOAOrderStatus_ORDER_STATUS_CANCELLED.Enum()
+			cancelled.UtcLastUpdateTimestamp = proto.Int64(2)
+
+			tracker.Apply(execution(openapi.ProtoOAExecutionType_ORDER_CANCELLED, nil, cancelled))
+			tracker.ApplyError(&openapi.ProtoOAOrderErrorEvent{OrderId: proto.Int64(2), ErrorCode: proto.String("TRADING_DISABLED")})
+			tracker.Bind(other, 2)
+			tracker.Apply(execution(openapi.ProtoOAExecutionType_ORDER_ACCEPTED, nil, pending))
+
+			So(other.Status(), ShouldEqual, openapi.ProtoOAOrderStatus_ORDER_STATUS_CANCELLED)
+			So(other.ErrorCode(), ShouldEqual, "TRADING_DISABLED")
+		})
+
+		Convey("records rejections", func() {
+			rejected := proto.Clone(accepted).(*openapi.ProtoOAOrder)
+			rejected.OrderStatus = openapi.ProtoOAOrderStatus_ORDER_STATUS_REJECTED.Enum()
+			event := execution(openapi.ProtoOAExecutionType_ORDER_REJECTED, nil, rejected)
+			event.ErrorCode = proto.String("NOT_ENOUGH_MONEY")
+			tracker.Apply(event)
+
+			<-handle.Done()
+			So(handle.ErrorCode(), ShouldEqual, "NOT_ENOUGH_MONEY")
+		})
+
+		Convey("stops waiting with the context", func() {
+			short, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+			defer cancel()
+			So(handle.Wait(short, openapi.ProtoOAOrderStatus_ORDER_STATUS_FILLED), ShouldResemble, context.DeadlineExceeded)
+		})
+	})
+}
+
+func TestOrderTrackerSubmit(t *testing.T) {
+	Convey("OrderTracker.Submit", t, func() {
+		tracker := NewOrderTracker()
+		tracker.sleep = func(time.Duration) {}
+
+		sent := 0
+		var sendErr error
+		var placed *openapi.ProtoOAOrder
+		tracker.newOrder = func(req *openapi.ProtoOANewOrderReq) (*openapi.ProtoOAExecutionEvent, error) {
+			// like Account.NewOrder
+			if req.CtidTraderAccountId != nil {
+				return nil, errors.New("account id must be empty")
+			}
+			req.CtidTraderAccountId = proto.Int64(1)
+			sent++
+			order := testOrder(int64(sent), openapi.ProtoOAOrderType_MARKET, openapi.ProtoOAOrderStatus_ORDER_STATUS_ACCEPTED, 1)
+			order.ClientOrderId = req.ClientOrderId
+			if sendErr != nil {
+				return nil, sendErr
+			}
+			return execution(openapi.ProtoOAExecutionType_ORDER_ACCEPTED, nil, order), nil
+		}
+		var pending, history []*openapi.ProtoOAOrder
+		var lookupErr error
+		tracker.pendingOrders = func() ([]*openapi.ProtoOAOrder, error) { return pending, lookupErr }
+		tracker.orderList = func(from, to time.Time) ([]*openapi.ProtoOAOrder, error) { return history, lookupErr }
+
+		req := &openapi.ProtoOANewOrderReq{}
+
+		Convey("assigns a ClientOrderId", func() {
+			handle, err := tracker.Submit(req)
+			So(err, ShouldBeNil)
+			So(req.GetClientOrderId(), ShouldHaveLength, 36)
+			So(handle.ClientOrderId(), ShouldEqual, req.GetClientOrderId())
+			So(handle.OrderId(), ShouldEqual, 1)
+
+			// submitting again returns the same order
+			again, err := tracker.Submit(req)
+			So(err, ShouldBeNil)
+			So(again, ShouldEqual, handle)
+			So(sent, ShouldEqual, 1)
+		})
+
+		Convey("finds orders placed before a timeout", func() {
+			sendErr = ErrTimeout
+			req.ClientOrderId = proto.String("abc")
+			placed = testOrder(7, openapi.ProtoOAOrderType_MARKET, openapi.ProtoOAOrderStatus_ORDER_STATUS_FILLED, 2)
+			placed.ClientOrderId = proto.String("abc")
+
+			Convey("among pending orders", func() {
+				pending = []*openapi.ProtoOAOrder{placed}
+				handle, err := tracker.Submit(req)
+				So(err, ShouldBeNil)
+				So(handle.OrderId(), ShouldEqual, 7)
+			})
+
+			Convey("in the order history", func() {
+				history = []*openapi.ProtoOAOrder{placed}
+				handle, err := tracker.Submit(req)
+				So(err, ShouldBeNil)
+				So(handle.Status(), ShouldEqual, openapi.ProtoOAOrderStatus_ORDER_STATUS_FILLED)
+			})
+		})
+
+		Convey("reports orders that were not placed", func() {
+			sendErr = ErrTimeout
+			_, err := tracker.Submit(req)
+			So(errors.Is(err, ErrTimeout), ShouldBeTrue)
+
+			sendErr = nil
+			_, err = tracker.Submit(req)
+			So(err, ShouldBeNil)
+			So(sent, ShouldEqual, 2)
+		})
+
+		Convey("looks up unknown orders again before resending", func() {
+			sendErr, lookupErr = ErrTimeout, errors.New("disconnected")
+			_, err := tracker.Submit(req)
+			So(errors.Is(err, ErrOrderUnknown), ShouldBeTrue)
+
+			lookupErr = nil
+			placed = testOrder(7, openapi.ProtoOAOrderType_LIMIT, openapi.ProtoOAOrderStatus_ORDER_STATUS_ACCEPTED, 2)
+			placed.ClientOrderId = req.ClientOrderId
+			pending = []*openapi.ProtoOAOrder{placed}
+			handle, err := tracker.Submit(req)
+			So(err, ShouldBeNil)
+			So(handle.OrderId(), ShouldEqual, 7)
+			So(sent, ShouldEqual, 1)
+		})
+
+		Convey("does not resend finished orders", func() {
+			req.ClientOrderId = proto.String("abc")
+			handle, err := tracker.Submit(req)
+			So(err, ShouldBeNil)
+			filled := testOrder(1, openapi.ProtoOAOrderType_MARKET, openapi.ProtoOAOrderStatus_ORDER_STATUS_FILLED, 2)
+			filled.ClientOrderId = proto.String("abc")
+			tracker.Apply(execution(openapi.ProtoOAExecutionType_ORDER_FILLED, nil, filled))
+			<-handle.Done()
+
+			again, err := tracker.Submit(req)
+			So(err, ShouldBeNil)
+			So(again, ShouldEqual, handle)
+			So(sent, ShouldEqual, 1)
+		})
+
+		Convey("does not look up rejected requests", func() {
+			lookups := 0
+			tracker.pendingOrders = func() ([]*openapi.ProtoOAOrder, error) {
+				lookups++
+				return nil, nil
+			}
+
+			sendErr = &RiskError{Rule: RuleRestrictedSymbol}
+			_, err := tracker.Submit(req)
+			So(err, ShouldEqual, sendErr)
+			_, ok := tracker.HandleByClientOrderId(req.GetClientOrderId())
+			So(ok, ShouldBeFalse)
+
+			sendErr = errors.New("connection is not established")
+			_, err = tracker.Submit(req)
+			So(err, ShouldEqual, sendErr)
+			So(lookups, ShouldEqual, 0)
+		})
+
+		Convey("does not resend orders seen in events", func() {
+			req.ClientOrderId = proto.String("abc")
+			seen := testOrder(9, openapi.ProtoOAOrderType_LIMIT, openapi.ProtoOAOrderStatus_ORDER_STATUS_ACCEPTED, 1)
+			seen.ClientOrderId = proto.String("abc")
+			tracker.Apply(execution(openapi.ProtoOAExecutionType_ORDER_ACCEPTED, nil, seen))
+
+			handle, err := tracker.Submit(req)
+			So(err, ShouldBeNil)
+			So(handle.OrderId(), ShouldEqual, 9)
+			So(sent, ShouldEqual, 0)
+		})
+	})
+}
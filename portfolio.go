@@ -0,0 +1,583 @@
+package ctrader
+
+import (
+	"errors"
+	"fmt"
+	"github.com/shopspring/decimal"
+	"github.com/ty2/ctrader-go/proto/openapi"
+	"github.com/vmware/transport-go/bus"
+	"github.com/vmware/transport-go/model"
+	"google.golang.org/protobuf/proto"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	portfolioChangeChannel = "portfolio-change"
+	portfolioDriftChannel  = "portfolio-drift"
+)
+
+// DefaultPortfolioReconcileInterval is how often an account portfolio is compared with the server.
+const DefaultPortfolioReconcileInterval = time.Minute
+
+// ErrPortfolioChanged is returned by Portfolio.Reconcile when execution events were applied while
+// the reconcile request was in flight, so the server state may be older than the local one.
+var ErrPortfolioChanged = errors.New("portfolio changed during reconcile")
+
+// reconcileAttempts bounds how often the reconcile loop retries after ErrPortfolioChanged.
+const reconcileAttempts = 3
+
+// PortfolioSnapshot is a copy of the portfolio state. Positions and Orders are sorted by id and
+// share their messages with the portfolio; they must not be modified.
+type PortfolioSnapshot struct {
+	Time      time.Time
+	Trader    *openapi.ProtoOATrader
+	Positions []*openapi.ProtoOAPosition
+	Orders    []*openapi.ProtoOAOrder
+}
+
+// PortfolioChange is published after every event that changed the portfolio. Event is the applied
+// message, e.g. a *openapi.ProtoOAExecutionEvent or the *openapi.ProtoOAReconcileRes that fixed a drift.
+type PortfolioChange struct {
+	Event    interface{}
+	Snapshot PortfolioSnapshot
+}
+
+// PortfolioDrift lists what the local state got wrong compared to a reconcile: positions and
+// orders that were missing, unknown to the server or different, and whether the balance differed.
+type PortfolioDrift struct {
+	Time      time.Time
+	Positions []int64
+	Orders    []int64
+	Balance   bool
+}
+
+func (drift PortfolioDrift) Empty() bool {
+	return len(drift.Positions) == 0 && len(drift.Orders) == 0 && !drift.Balance
+}
+
+func (drift PortfolioDrift) String() string {
+	return fmt.Sprintf("portfolio drift: positions %v, orders %v, balance %t", drift.Positions, drift.Orders, drift.Balance)
+}
+
+// Portfolio keeps the open positions, pending orders and balance of an account from a reconcile
+// and the execution events that follow it. Handlers run concurrently, so events are ordered by
+// their last update timestamps and balance versions rather than by arrival.
+type Portfolio struct {
+	eventBus  bus.EventBus
+	reconcile func() (*openapi.ProtoOATrader, *openapi.ProtoOAReconcileRes, error)
+
+	mu        sync.RWMutex
+	trader    *openapi.ProtoOATrader
+	positions map[int64]*openapi.ProtoOAPosition
+	orders    map[int64]*openapi.ProtoOAOrder
+	// removed holds the last update timestamps of closed positions and finished orders so that
+	// late events do not bring them back
+	removedPositions map[int64]int64
+	removedOrders    map[int64]int64
+	// applied counts seeds and execution events; margin and trader updates do not race with the
+	// positions and orders of a reconcile
+	applied  uint64
+	interval time.Duration
+
+	handlers []bus.MessageHandler
+	stop     chan struct{}
+	stopOnce sync.Once
+	closed   func()
+}
+
+func NewPortfolio() *Portfolio {
+	portfolio := &Portfolio{
+		eventBus:         bus.NewEventBusInstance(),
+		trader:           &openapi.ProtoOATrader{},
+		positions:        map[int64]*openapi.ProtoOAPosition{},
+		orders:           map[int64]*openapi.ProtoOAOrder{},
+		removedPositions: map[int64]int64{},
+		removedOrders:    map[int64]int64{},
+		interval:         DefaultPortfolioReconcileInterval,
+		stop:             make(chan struct{}),
+	}
+
+	cm := portfolio.eventBus.GetChannelManager()
+	cm.CreateChannel(portfolioChangeChannel)
+	cm.CreateChannel(portfolioDriftChannel)
+
+	return portfolio
+}
+
+// Portfolio returns the account portfolio, creating it on first use: it listens to execution,
+// margin and trader events, seeds itself from Trader and Reconcile and then re-reconciles every
+// DefaultPortfolioReconcileInterval, publishing any drift it finds. Closing it makes the next call
+// create a new one.
+func (account *Account) Portfolio() (*Portfolio, error) {
+	account.portfolioMu.Lock()
+	defer account.portfolioMu.Unlock()
+
+	if portfolio, ok := account.loadedPortfolio(); ok {
+		return portfolio, nil
+	}
+
+	portfolio := NewPortfolio()
+	portfolio.reconcile = func() (*openapi.ProtoOATrader, *openapi.ProtoOAReconcileRes, error) {
+		trader, err := account.Trader()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		res, err := account.Reconcile()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return trader.GetTrader(), res, nil
+	}
+
+	listeners := []struct {
+		on    func() (bus.MessageHandler, error)
+		apply func(payload interface{})
+	}{
+		{account.OnExecution, func(payload interface{}) {
+			if v, ok := payload.(*openapi.ProtoOAExecutionEvent); ok {
+				portfolio.Apply(v)
+			}
+		}},
+		{account.OnMarginChanged, func(payload interface{}) {
+			if v, ok := payload.(*openapi.ProtoOAMarginChangedEvent); ok {
+				portfolio.ApplyMarginChanged(v)
+			}
+		}},
+		{account.OnTraderUpdate, func(payload interface{}) {
+			if v, ok := payload.(*openapi.ProtoOATraderUpdatedEvent); ok {
+				portfolio.ApplyTrader(v.GetTrader())
+			}
+		}},
+	}
+	for _, listener := range listeners {
+		handler, err := listener.on()
+		if err != nil {
+			portfolio.Close()
+			return nil, err
+		}
+
+		apply := listener.apply
+		handler.Handle(
+			func(msg *model.Message) {
+				apply(msg.Payload)
+			},
+			func(err error) {
+				logger.Warn(err.Error())
+			})
+		portfolio.handlers = append(portfolio.handlers, handler)
+	}
+
+	trader, res, err := portfolio.reconcile()
+	if err != nil {
+		portfolio.Close()
+		return nil, err
+	}
+	portfolio.Seed(trader, res)
+
+	go portfolio.reconcileLoop()
+
+	portfolio.closed = func() {
+		account.loadedMu.Lock()
+		defer account.loadedMu.Unlock()
+		if account.portfolio == portfolio {
+			account.portfolio = nil
+		}
+	}
+	account.loadedMu.Lock()
+	account.portfolio = portfolio
+	account.loadedMu.Unlock()
+	return portfolio, nil
+}
+
+// loadedPortfolio returns the account portfolio if Portfolio has seeded one, without creating it
+// or waiting for one being seeded.
+func (account *Account) loadedPortfolio() (*Portfolio, bool) {
+	account.loadedMu.RLock()
+	defer account.loadedMu.RUnlock()
+	return account.portfolio, account.portfolio != nil
+}
+
+// SetReconcileInterval changes how often the portfolio is re-reconciled; zero disables it.
+func (portfolio *Portfolio) SetReconcileInterval(interval time.Duration) {
+	portfolio.mu.Lock()
+	defer portfolio.mu.Unlock()
+	portfolio.interval = interval
+}
+
+func (portfolio *Portfolio) reconcileInterval() time.Duration {
+	portfolio.mu.RLock()
+	defer portfolio.mu.RUnlock()
+	return portfolio.interval
+}
+
+func (portfolio *Portfolio) reconcileLoop() {
+	for {
+		interval := portfolio.reconcileInterval()
+		if interval <= 0 {
+			interval = DefaultPortfolioReconcileInterval
+		}
+
+		select {
+		case <-portfolio.stop:
+			return
+		case <-time.After(interval):
+		}
+
+		if portfolio.reconcileInterval() <= 0 {
+			continue
+		}
+		for attempt := 1; ; attempt++ {
+			_, err := portfolio.Reconcile()
+			if err == ErrPortfolioChanged && attempt < reconcileAttempts {
+				continue
+			}
+			if err == ErrPortfolioChanged {
+				logger.Warn(fmt.Sprintf("portfolio reconcile skipped after %d attempts: %v", attempt, err))
+			} else if err != nil {
+				logger.Warn(err.Error())
+			}
+			break
+		}
+	}
+}
+
+// Reconcile fetches the server state, replaces the local one with it and returns the drift
+// between them. Drift is also published to OnDrift listeners.
+func (portfolio *Portfolio) Reconcile() (PortfolioDrift, error) {
+	if portfolio.reconcile == nil {
+		return PortfolioDrift{}, errors.New("portfolio is not attached to an account")
+	}
+
+	portfolio.mu.RLock()
+	applied := portfolio.applied
+	portfolio.mu.RUnlock()
+
+	trader, res, err := portfolio.reconcile()
+	if err != nil {
+		return PortfolioDrift{}, err
+	}
+
+	portfolio.mu.Lock()
+	if portfolio.applied != applied {
+		portfolio.mu.Unlock()
+		return PortfolioDrift{}, ErrPortfolioChanged
+	}
+	if trader != nil && !newer(trader.BalanceVersion, portfolio.trader.BalanceVersion) {
+		// a trader update arrived in the meantime
+		trader = nil
+	}
+	drift := portfolio.diff(trader, res)
+	if !drift.Empty() {
+		portfolio.seed(trader, res)
+	} else if trader != nil {
+		portfolio.trader = trader
+	}
+	snapshot := portfolio.snapshot()
+	portfolio.mu.Unlock()
+
+	if !drift.Empty() {
+		logger.Warn(drift.String())
+		portfolio.publish(portfolioDriftChannel, drift)
+		portfolio.publish(portfolioChangeChannel, PortfolioChange{Event: res, Snapshot: snapshot})
+	}
+
+	return drift, nil
+}
+
+// Seed replaces the state with a trader and a reconcile response.
+func (portfolio *Portfolio) Seed(trader *openapi.ProtoOATrader, res *openapi.ProtoOAReconcileRes) {
+	portfolio.mu.Lock()
+	portfolio.seed(trader, res)
+	snapshot := portfolio.snapshot()
+	portfolio.mu.Unlock()
+
+	portfolio.publish(portfolioChangeChannel, PortfolioChange{Event: res, Snapshot: snapshot})
+}
+
+func (portfolio *Portfolio) seed(trader *openapi.ProtoOATrader, res *openapi.ProtoOAReconcileRes) {
+	if trader != nil {
+		portfolio.trader = trader
+	}
+	portfolio.positions = map[int64]*openapi.ProtoOAPosition{}
+	for _, position := range res.GetPosition() {
+		portfolio.positions[position.GetPositionId()] = position
+	}
+	portfolio.orders = map[int64]*openapi.ProtoOAOrder{}
+	for _, order := range res.GetOrder() {
+		portfolio.orders[order.GetOrderId()] = order
+	}
+	portfolio.removedPositions = map[int64]int64{}
+	portfolio.removedOrders = map[int64]int64{}
+	portfolio.applied++
+}
+
+func (portfolio *Portfolio) diff(trader *openapi.ProtoOATrader, res *openapi.ProtoOAReconcileRes) PortfolioDrift {
+	drift := PortfolioDrift{Time: time.Now()}
+
+	server := map[int64]bool{}
+	for _, position := range res.GetPosition() {
+		id := position.GetPositionId()
+		server[id] = true
+		if local, ok := portfolio.positions[id]; !ok || positionDrifted(local, position) {
+			drift.Positions = append(drift.Positions, id)
+		}
+	}
+	for id := range portfolio.positions {
+		if !server[id] {
+			drift.Positions = append(drift.Positions, id)
+		}
+	}
+
+	server = map[int64]bool{}
+	for _, order := range res.GetOrder() {
+		id := order.GetOrderId()
+		server[id] = true
+		if local, ok := portfolio.orders[id]; !ok || orderDrifted(local, order) {
+			drift.Orders = append(drift.Orders, id)
+		}
+	}
+	for id := range portfolio.orders {
+		if !server[id] {
+			drift.Orders = append(drift.Orders, id)
+		}
+	}
+
+	sort.Slice(drift.Positions, func(i, j int) bool { return drift.Positions[i] < drift.Positions[j] })
+	sort.Slice(drift.Orders, func(i, j int) bool { return drift.Orders[i] < drift.Orders[j] })
+	drift.Balance = trader != nil && trader.GetBalance() != portfolio.trader.GetBalance()
+
+	return drift
+}
+
+func positionDrifted(local, server *openapi.ProtoOAPosition) bool {
+	return local.GetTradeData().GetVolume() != server.GetTradeData().GetVolume() ||
+		local.GetTradeData().GetTradeSide() != server.GetTradeData().GetTradeSide() ||
+		local.GetPrice() != server.GetPrice() ||
+		local.GetStopLoss() != server.GetStopLoss() ||
+		local.GetTakeProfit() != server.GetTakeProfit()
+}
+
+func orderDrifted(local, server *openapi.ProtoOAOrder) bool {
+	return local.GetTradeData().GetVolume() != server.GetTradeData().GetVolume() ||
+		local.GetExecutedVolume() != server.GetExecutedVolume() ||
+		local.GetOrderType() != server.GetOrderType() ||
+		local.GetLimitPrice() != server.GetLimitPrice() ||
+		local.GetStopPrice() != server.GetStopPrice() ||
+		local.GetStopLoss() != server.GetStopLoss() ||
+		local.GetTakeProfit() != server.GetTakeProfit()
+}
+
+// Apply updates the position, order and balance carried by an execution event.
+func (portfolio *Portfolio) Apply(event *openapi.ProtoOAExecutionEvent) {
+	portfolio.mu.Lock()
+	changed := false
+	if position := event.GetPosition(); position != nil {
+		changed = portfolio.applyPosition(position) || changed
+	}
+	if order := event.GetOrder(); order != nil {
+		changed = portfolio.applyOrder(order) || changed
+	}
+	if detail := event.GetDeal().GetClosePositionDetail(); detail != nil {
+		changed = portfolio.applyBalance(detail.GetBalance(), detail.BalanceVersion) || changed
+	}
+	if v := event.GetDepositWithdraw(); v != nil {
+		changed = portfolio.applyBalance(v.GetBalance(), v.BalanceVersion) || changed
+	}
+	if changed {
+		portfolio.applied++
+	}
+	snapshot := portfolio.snapshot()
+	portfolio.mu.Unlock()
+
+	if changed {
+		portfolio.publish(portfolioChangeChannel, PortfolioChange{Event: event, Snapshot: snapshot})
+	}
+}
+
+// newer reports whether an update stamped updated may replace state stamped current.
+func newer(updated, current *int64) bool {
+	return updated == nil || current == nil || *updated >= *current
+}
+
+func (portfolio *Portfolio) applyPosition(position *openapi.ProtoOAPosition) bool {
+	id := position.GetPositionId()
+	if removed, ok := portfolio.removedPositions[id]; ok && !newer(position.UtcLastUpdateTimestamp, &removed) {
+		return false
+	}
+	if current, ok := portfolio.positions[id]; ok && !newer(position.UtcLastUpdateTimestamp, current.UtcLastUpdateTimestamp) {
+		return false
+	}
+
+	switch position.GetPositionStatus() {
+	case openapi.ProtoOAPositionStatus_POSITION_STATUS_OPEN:
+		portfolio.positions[id] = position
+		return true
+	case openapi.ProtoOAPositionStatus_POSITION_STATUS_CREATED:
+		// empty position of a pending order
+		return false
+	default:
+		_, ok := portfolio.positions[id]
+		delete(portfolio.positions, id)
+		portfolio.removedPositions[id] = position.GetUtcLastUpdateTimestamp()
+		return ok
+	}
+}
+
+// applyOrder keeps pending orders only; market orders are filled or rejected right away.
+func (portfolio *Portfolio) applyOrder(order *openapi.ProtoOAOrder) bool {
+	id := order.GetOrderId()
+	if removed, ok := portfolio.removedOrders[id]; ok && !newer(order.UtcLastUpdateTimestamp, &removed) {
+		return false
+	}
+	if current, ok := portfolio.orders[id]; ok && !newer(order.UtcLastUpdateTimestamp, current.UtcLastUpdateTimestamp) {
+		return false
+	}
+
+	orderType := order.GetOrderType()
+	if order.GetOrderStatus() == openapi.ProtoOAOrderStatus_ORDER_STATUS_ACCEPTED &&
+		orderType != openapi.ProtoOAOrderType_MARKET && orderType != openapi.ProtoOAOrderType_MARKET_RANGE {
+		portfolio.orders[id] = order
+		return true
+	}
+
+	_, ok := portfolio.orders[id]
+	delete(portfolio.orders, id)
+	portfolio.removedOrders[id] = order.GetUtcLastUpdateTimestamp()
+	return ok
+}
+
+func (portfolio *Portfolio) applyBalance(balance int64, version *int64) bool {
+	if !newer(version, portfolio.trader.BalanceVersion) {
+		return false
+	}
+
+	trader := proto.Clone(portfolio.trader).(*openapi.ProtoOATrader)
+	trader.Balance = &balance
+	if version != nil {
+		trader.BalanceVersion = version
+	}
+	portfolio.trader = trader
+	return true
+}
+
+// ApplyMarginChanged updates the used margin of a position.
+func (portfolio *Portfolio) ApplyMarginChanged(event *openapi.ProtoOAMarginChangedEvent) {
+	portfolio.mu.Lock()
+	position, ok := portfolio.positions[int64(event.GetPositionId())]
+	if ok {
+		position = proto.Clone(position).(*openapi.ProtoOAPosition)
+		position.UsedMargin = event.UsedMargin
+		if event.MoneyDigits != nil {
+			position.MoneyDigits = event.MoneyDigits
+		}
+		portfolio.positions[position.GetPositionId()] = position
+	}
+	snapshot := portfolio.snapshot()
+	portfolio.mu.Unlock()
+
+	if ok {
+		portfolio.publish(portfolioChangeChannel, PortfolioChange{Event: event, Snapshot: snapshot})
+	}
+}
+
+// ApplyTrader replaces the trader unless its balance version is older than the current one.
+func (portfolio *Portfolio) ApplyTrader(trader *openapi.ProtoOATrader) {
+	portfolio.mu.Lock()
+	ok := trader != nil && newer(trader.BalanceVersion, portfolio.trader.BalanceVersion)
+	if ok {
+		portfolio.trader = trader
+	}
+	snapshot := portfolio.snapshot()
+	portfolio.mu.Unlock()
+
+	if ok {
+		portfolio.publish(portfolioChangeChannel, PortfolioChange{Event: trader, Snapshot: snapshot})
+	}
+}
+
+func (portfolio *Portfolio) snapshot() PortfolioSnapshot {
+	snapshot := PortfolioSnapshot{
+		Time:      time.Now(),
+		Trader:    portfolio.trader,
+		Positions: make([]*openapi.ProtoOAPosition, 0, len(portfolio.positions)),
+		Orders:    make([]*openapi.ProtoOAOrder, 0, len(portfolio.orders)),
+	}
+	for _, position := range portfolio.positions {
+		snapshot.Positions = append(snapshot.Positions, position)
+	}
+	for _, order := range portfolio.orders {
+		snapshot.Orders = append(snapshot.Orders, order)
+	}
+	sort.Slice(snapshot.Positions, func(i, j int) bool {
+		return snapshot.Positions[i].GetPositionId() < snapshot.Positions[j].GetPositionId()
+	})
+	sort.Slice(snapshot.Orders, func(i, j int) bool {
+		return snapshot.Orders[i].GetOrderId() < snapshot.Orders[j].GetOrderId()
+	})
+
+	return snapshot
+}
+
+func (portfolio *Portfolio) Snapshot() PortfolioSnapshot {
+	portfolio.mu.RLock()
+	defer portfolio.mu.RUnlock()
+	return portfolio.snapshot()
+}
+
+func (portfolio *Portfolio) Position(positionId int64) (*openapi.ProtoOAPosition, bool) {
+	portfolio.mu.RLock()
+	defer portfolio.mu.RUnlock()
+	position, ok := portfolio.positions[positionId]
+	return position, ok
+}
+
+func (portfolio *Portfolio) Order(orderId int64) (*openapi.ProtoOAOrder, bool) {
+	portfolio.mu.RLock()
+	defer portfolio.mu.RUnlock()
+	order, ok := portfolio.orders[orderId]
+	return order, ok
+}
+
+func (portfolio *Portfolio) Trader() *openapi.ProtoOATrader {
+	portfolio.mu.RLock()
+	defer portfolio.mu.RUnlock()
+	return portfolio.trader
+}
+
+// Balance is the account balance in the deposit currency.
+func (portfolio *Portfolio) Balance() decimal.Decimal {
+	trader := portfolio.Trader()
+	return MoneyToDecimal(trader.GetBalance(), moneyDigits(trader.MoneyDigits))
+}
+
+func (portfolio *Portfolio) publish(channel string, payload interface{}) {
+	if err := portfolio.eventBus.SendBroadcastMessage(channel, payload); err != nil {
+		logger.Warn(err.Error())
+	}
+}
+
+// OnChange delivers a PortfolioChange after every applied event.
+func (portfolio *Portfolio) OnChange() (bus.MessageHandler, error) {
+	return portfolio.eventBus.ListenFirehose(portfolioChangeChannel)
+}
+
+// OnDrift delivers a PortfolioDrift whenever a reconcile disagrees with the local state.
+func (portfolio *Portfolio) OnDrift() (bus.MessageHandler, error) {
+	return portfolio.eventBus.ListenFirehose(portfolioDriftChannel)
+}
+
+func (portfolio *Portfolio) Close() {
+	portfolio.stopOnce.Do(func() {
+		close(portfolio.stop)
+		for _, handler := range portfolio.handlers {
+			handler.Close()
+		}
+		if portfolio.closed != nil {
+			portfolio.closed()
+		}
+	})
+}
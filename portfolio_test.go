@@ -0,0 +1,146 @@
+package ctrader
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ty2/ctrader-go/proto/openapi"
+	"google.golang.org/protobuf/proto"
+	"testing"
+)
+
+func testPosition(id int64, volume int64, status openapi.ProtoOAPositionStatus, updated int64) *openapi.ProtoOAPosition {
+	return &openapi.ProtoOAPosition{
+		PositionId:             proto.Int64(id),
+		TradeData:              &openapi.ProtoOATradeData{SymbolId: proto.Int64(1), Volume: proto.Int64(volume), TradeSide: openapi.ProtoOATradeSide_BUY.Enum()},
+		PositionStatus:         status.Enum(),
+		Swap:                   proto.Int64(0),
+		Price:                  proto.Float64(1.1),
+		UtcLastUpdateTimestamp: proto.Int64(updated),
+	}
+}
+
+func testOrder(id int64, orderType openapi.ProtoOAOrderType, status openapi.ProtoOAOrderStatus, updated int64) *openapi.ProtoOAOrder {
+	return &openapi.ProtoOAOrder{
+		OrderId:                proto.Int64(id),
+		TradeData:              &openapi.ProtoOATradeData{SymbolId: proto.Int64(1), Volume: proto.Int64(100000), TradeSide: openapi.ProtoOATradeSide_BUY.Enum()},
+		OrderType:              orderType.Enum(),
+		OrderStatus:            status.Enum(),
+		LimitPrice:             proto.Float64(1.05),
+		UtcLastUpdateTimestamp: proto.Int64(updated),
+	}
+}
+
+func execution(executionType openapi.ProtoOAExecutionType, position *openapi.ProtoOAPosition, order *openapi.ProtoOAOrder) *openapi.ProtoOAExecutionEvent {
+	return &openapi.ProtoOAExecutionEvent{ExecutionType: executionType.Enum(), Position: position, Order: order}
+}
+
+func TestPortfolio(t *testing.T) {
+	Convey("Portfolio", t, func() {
+		portfolio := NewPortfolio()
+		portfolio.Seed(&openapi.ProtoOATrader{Balance: proto.Int64(100000), BalanceVersion: proto.Int64(1), MoneyDigits: proto.Uint32(2)},
+			&openapi.ProtoOAReconcileRes{Position: []*openapi.ProtoOAPosition{testPosition(1, 100000, openapi.ProtoOAPositionStatus_POSITION_STATUS_OPEN, 10)}})
+		So(portfolio.Balance().String(), ShouldEqual, "1000")
+
+		Convey("tracks pending orders until they fill", func() {
+			portfolio.Apply(execution(openapi.ProtoOAExecutionType_ORDER_ACCEPTED,
+				testPosition(2, 0, openapi.ProtoOAPositionStatus_POSITION_STATUS_CREATED, 20),
+				testOrder(7, openapi.ProtoOAOrderType_LIMIT, openapi.ProtoOAOrderStatus_ORDER_STATUS_ACCEPTED, 20)))
+			_, ok := portfolio.Order(7)
+			So(ok, ShouldBeTrue)
+			_, ok = portfolio.Position(2)
+			So(ok, ShouldBeFalse)
+
+			portfolio.Apply(execution(openapi.ProtoOAExecutionType_ORDER_FILLED,
+				testPosition(2, 100000, openapi.ProtoOAPositionStatus_POSITION_STATUS_OPEN, 30),
+				testOrder(7, openapi.ProtoOAOrderType_LIMIT, openapi.ProtoOAOrderStatus_ORDER_STATUS_FILLED, 30)))
+			snapshot := portfolio.Snapshot()
+			So(snapshot.Orders, ShouldBeEmpty)
+			So(len(snapshot.Positions), ShouldEqual, 2)
+			So(snapshot.Positions[1].GetPositionId(), ShouldEqual, 2)
+		})
+
+		Convey("ignores market orders", func() {
+			portfolio.Apply(execution(openapi.ProtoOAExecutionType_ORDER_ACCEPTED, nil,
+				testOrder(8, openapi.ProtoOAOrderType_MARKET, openapi.ProtoOAOrderStatus_ORDER_STATUS_ACCEPTED, 20)))
+			So(portfolio.Snapshot().Orders, ShouldBeEmpty)
+		})
+
+		Convey("does not reopen a closed position from a late event", func() {
+			portfolio.Apply(execution(openapi.ProtoOAExecutionType_ORDER_FILLED,
+				testPosition(1, 100000, openapi.ProtoOAPositionStatus_POSITION_STATUS_CLOSED, 40), nil))
+			portfolio.Apply(execution(openapi.ProtoOAExecutionType_SWAP,
+				testPosition(1, 100000, openapi.ProtoOAPositionStatus_POSITION_STATUS_OPEN, 35), nil))
+			_, ok := portfolio.Position(1)
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("applies balances by version", func() {
+			event := &openapi.ProtoOAExecutionEvent{
+				ExecutionType: openapi.ProtoOAExecutionType_DEPOSIT_WITHDRAW.Enum(),
+				DepositWithdraw: &openapi.ProtoOADepositWithdraw{
+					Balance:        proto.Int64(150000),
+					BalanceVersion: proto.Int64(3),
+				},
+			}
+			portfolio.Apply(event)
+			So(portfolio.Balance().String(), ShouldEqual, "1500")
+
+			portfolio.ApplyTrader(&openapi.ProtoOATrader{Balance: proto.Int64(120000), BalanceVersion: proto.Int64(2)})
+			So(portfolio.Balance().String(), ShouldEqual, "1500")
+		})
+
+		Convey("updates used margin", func() {
+			portfolio.ApplyMarginChanged(&openapi.ProtoOAMarginChangedEvent{PositionId: proto.Uint64(1), UsedMargin: proto.Uint64(3300)})
+			position, _ := portfolio.Position(1)
+			So(position.GetUsedMargin(), ShouldEqual, 3300)
+		})
+
+		Convey("reports and fixes drift on reconcile", func() {
+			server := &openapi.ProtoOAReconcileRes{
+				Position: []*openapi.ProtoOAPosition{testPosition(1, 200000, openapi.ProtoOAPositionStatus_POSITION_STATUS_OPEN, 50)},
+				Order:    []*openapi.ProtoOAOrder{testOrder(9, openapi.ProtoOAOrderType_STOP, openapi.ProtoOAOrderStatus_ORDER_STATUS_ACCEPTED, 50)},
+			}
+			portfolio.reconcile = func() (*openapi.ProtoOATrader, *openapi.ProtoOAReconcileRes, error) {
+				return portfolio.Trader(), server, nil
+			}
+
+			drift, err := portfolio.Reconcile()
+			So(err, ShouldBeNil)
+			So(drift.Positions, ShouldResemble, []int64{1})
+			So(drift.Orders, ShouldResemble, []int64{9})
+			So(drift.Balance, ShouldBeFalse)
+			position, _ := portfolio.Position(1)
+			So(position.GetTradeData().GetVolume(), ShouldEqual, 200000)
+
+			drift, err = portfolio.Reconcile()
+			So(err, ShouldBeNil)
+			So(drift.Empty(), ShouldBeTrue)
+		})
+
+		Convey("skips a reconcile that raced with an execution", func() {
+			portfolio.reconcile = func() (*openapi.ProtoOATrader, *openapi.ProtoOAReconcileRes, error) {
+				portfolio.Apply(execution(openapi.ProtoOAExecutionType_ORDER_FILLED,
+					testPosition(1, 200000, openapi.ProtoOAPositionStatus_POSITION_STATUS_OPEN, 11), nil))
+				return nil, &openapi.ProtoOAReconcileRes{}, nil
+			}
+
+			_, err := portfolio.Reconcile()
+			So(err, ShouldEqual, ErrPortfolioChanged)
+			_, ok := portfolio.Position(1)
+			So(ok, ShouldBeTrue)
+		})
+
+		Convey("reconciles through margin and trader updates", func() {
+			portfolio.reconcile = func() (*openapi.ProtoOATrader, *openapi.ProtoOAReconcileRes, error) {
+				portfolio.ApplyMarginChanged(&openapi.ProtoOAMarginChangedEvent{PositionId: proto.Uint64(1), UsedMargin: proto.Uint64(1)})
+				portfolio.ApplyTrader(&openapi.ProtoOATrader{Balance: proto.Int64(200000), BalanceVersion: proto.Int64(3)})
+				return &openapi.ProtoOATrader{Balance: proto.Int64(150000), BalanceVersion: proto.Int64(2)}, &openapi.ProtoOAReconcileRes{}, nil
+			}
+
+			drift, err := portfolio.Reconcile()
+			So(err, ShouldBeNil)
+			So(drift.Positions, ShouldResemble, []int64{1})
+			So(drift.Balance, ShouldBeFalse)
+			So(portfolio.Trader().GetBalance(), ShouldEqual, 200000)
+		})
+	})
+}
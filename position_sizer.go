@@ -0,0 +1,142 @@
+package ctrader
+
+import (
+	"errors"
+	"github.com/shopspring/decimal"
+	"github.com/ty2/ctrader-go/proto/openapi"
+)
+
+// RiskAmount is percent of capital, e.g. 1% of the balance or equity.
+func RiskAmount(capital, percent decimal.Decimal) decimal.Decimal {
+	return capital.Mul(percent).Div(decimal.NewFromInt(100))
+}
+
+// PipValue is the profit of volume moving one pip, in the quote asset of the symbol.
+func (info *SymbolInfo) PipValue(volume int64) decimal.Decimal {
+	return info.VolumeToUnits(volume).Mul(info.PipSize())
+}
+
+// VolumeForRisk is the largest valid volume losing at most risk when the price moves by
+// stopDistance. quoteRate converts the quote asset of the symbol to the currency of risk. The
+// volume is rounded down to the volume step and capped at the maximum volume; a risk too small
+// for the minimum volume is an error.
+func (info *SymbolInfo) VolumeForRisk(risk, stopDistance, quoteRate decimal.Decimal) (int64, error) {
+	if !risk.IsPositive() {
+		return 0, errors.New("risk must be positive")
+	}
+	if !stopDistance.IsPositive() {
+		return 0, errors.New("stop distance must be positive")
+	}
+	if !quoteRate.IsPositive() {
+		return 0, errors.New("conversion rate must be positive")
+	}
+
+	units := risk.Div(stopDistance.Mul(quoteRate))
+	// truncate instead of rounding so the loss never exceeds the risk
+	volume := info.RoundVolume(units.Shift(2).Floor().IntPart())
+	if volume <= 0 || volume < info.symbol.GetMinVolume() {
+		return 0, errors.New("risk is too small for the symbol minimum volume")
+	}
+
+	return volume, nil
+}
+
+// PositionSizer sizes orders by the amount risked per trade, converting pip values to the deposit
+// currency with live rates.
+type PositionSizer struct {
+	converter      *Converter
+	symbolInfo     func(symbolId int64) (*SymbolInfo, error)
+	depositAssetId func() int64
+}
+
+func newPositionSizer(converter *Converter) *PositionSizer {
+	return &PositionSizer{converter: converter}
+}
+
+// PositionSizer returns a sizer using the deposit asset of the account portfolio.
+func (account *Account) PositionSizer() (*PositionSizer, error) {
+	portfolio, err := account.Portfolio()
+	if err != nil {
+		return nil, err
+	}
+
+	converter, err := account.Converter()
+	if err != nil {
+		return nil, err
+	}
+
+	sizer := newPositionSizer(converter)
+	sizer.symbolInfo = account.symbols.Info
+	sizer.depositAssetId = func() int64 {
+		return portfolio.Trader().GetDepositAssetId()
+	}
+
+	return sizer, nil
+}
+
+// quoteRate converts the quote asset of a symbol to the deposit asset at mid prices.
+func (sizer *PositionSizer) quoteRate(info *SymbolInfo) (decimal.Decimal, error) {
+	light := info.LightSymbol()
+	if light == nil {
+		return decimal.Zero, errors.New("symbol info requires a light symbol")
+	}
+
+	return sizer.converter.Rate(light.GetQuoteAssetId(), sizer.depositAssetId(), MidPrice)
+}
+
+// PipValue is the profit of volume moving one pip on a symbol in the deposit currency.
+func (sizer *PositionSizer) PipValue(symbolId, volume int64) (decimal.Decimal, error) {
+	info, err := sizer.symbolInfo(symbolId)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	rate, err := sizer.quoteRate(info)
+	if err != nil {
+		return decimal.Zero, err
+	}
+
+	return info.PipValue(volume).Mul(rate), nil
+}
+
+// Volume sizes an order on a symbol to lose percent of capital, e.g. Portfolio.Balance or
+// Equity.Equity, when the price moves by stopDistance.
+func (sizer *PositionSizer) Volume(symbolId int64, capital, percent, stopDistance decimal.Decimal) (int64, error) {
+	info, err := sizer.symbolInfo(symbolId)
+	if err != nil {
+		return 0, err
+	}
+	rate, err := sizer.quoteRate(info)
+	if err != nil {
+		return 0, err
+	}
+
+	return info.VolumeForRisk(RiskAmount(capital, percent), stopDistance, rate)
+}
+
+// VolumeForPips is Volume with the stop distance in pips.
+func (sizer *PositionSizer) VolumeForPips(symbolId int64, capital, percent, stopPips decimal.Decimal) (int64, error) {
+	info, err := sizer.symbolInfo(symbolId)
+	if err != nil {
+		return 0, err
+	}
+
+	return sizer.Volume(symbolId, capital, percent, info.PipsToPrice(stopPips))
+}
+
+// Market builds a market order sized by VolumeForPips with a relative stop loss of stopPips.
+func (sizer *PositionSizer) Market(symbolId int64, side openapi.ProtoOATradeSide, capital, percent, stopPips decimal.Decimal) (*openapi.ProtoOANewOrderReq, error) {
+	info, err := sizer.symbolInfo(symbolId)
+	if err != nil {
+		return nil, err
+	}
+	volume, err := sizer.VolumeForPips(symbolId, capital, percent, stopPips)
+	if err != nil {
+		return nil, err
+	}
+
+	return Market(info.Symbol(), side, 0).Volume(volume).RelativeSL(info.PipsToRelative(stopPips)).Build()
+}
+
+func (sizer *PositionSizer) Close() {
+	sizer.converter.Close()
+}
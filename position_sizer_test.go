@@ -0,0 +1,68 @@
+package ctrader
+
+import (
+	"github.com/shopspring/decimal"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ty2/ctrader-go/proto/openapi"
+	"testing"
+)
+
+func TestPositionSizer(t *testing.T) {
+	Convey("PositionSizer", t, func() {
+		// assets: 1 USD (deposit), 2 EUR, 3 GBP
+		gbpusd := testLightSymbol(11, 3, 1)
+		converter := newConverter(NewQuoteCache())
+		converter.conversionChain = func(fromAssetId, toAssetId int64) ([]*openapi.ProtoOALightSymbol, error) {
+			return []*openapi.ProtoOALightSymbol{gbpusd}, nil
+		}
+		converter.quotes.Apply(testSpot(11, 124990, 125010))
+
+		sizer := newPositionSizer(converter)
+		sizer.symbolInfo = func(symbolId int64) (*SymbolInfo, error) {
+			return NewSymbolInfo(testSymbol(), testLightSymbol(1, 2, 3)), nil
+		}
+		sizer.depositAssetId = func() int64 { return 1 }
+		capital := decimal.NewFromInt(10000)
+
+		Convey("converts pip values to the deposit currency", func() {
+			value, err := sizer.PipValue(1, 100000)
+			So(err, ShouldBeNil)
+			So(value.String(), ShouldEqual, "0.125")
+		})
+
+		Convey("sizes by risk and stop distance", func() {
+			volume, err := sizer.VolumeForPips(1, capital, decimal.NewFromInt(1), decimal.NewFromInt(20))
+			So(err, ShouldBeNil)
+			So(volume, ShouldEqual, 4000000)
+
+			// 42000 units round down to the volume step
+			volume, err = sizer.Volume(1, capital, decimal.NewFromInt(1), decimal.RequireFromString("0.0019"))
+			So(err, ShouldBeNil)
+			So(volume, ShouldEqual, 4200000)
+		})
+
+		Convey("caps at the maximum volume and rejects risks below the minimum", func() {
+			volume, err := sizer.VolumeForPips(1, capital, decimal.NewFromInt(100), decimal.RequireFromString("0.1"))
+			So(err, ShouldBeNil)
+			So(volume, ShouldEqual, 1000000000)
+
+			_, err = sizer.VolumeForPips(1, decimal.NewFromInt(100), decimal.NewFromInt(1), decimal.NewFromInt(20))
+			So(err, ShouldNotBeNil)
+			_, err = sizer.VolumeForPips(1, capital, decimal.NewFromInt(1), decimal.Zero)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("builds a market order with the stop loss", func() {
+			req, err := sizer.Market(1, openapi.ProtoOATradeSide_SELL, capital, decimal.NewFromInt(1), decimal.NewFromInt(20))
+			So(err, ShouldBeNil)
+			So(req.GetVolume(), ShouldEqual, 4000000)
+			So(req.GetRelativeStopLoss(), ShouldEqual, 200)
+		})
+
+		Convey("needs quotes for the conversion", func() {
+			converter.quotes.Forget(11)
+			_, err := sizer.PipValue(1, 100000)
+			So(err, ShouldHaveSameTypeAs, &MissingQuoteError{})
+		})
+	})
+}
@@ -0,0 +1,22 @@
+package ctrader
+
+import (
+	"github.com/shopspring/decimal"
+	"time"
+)
+
+// Quote is the bid and ask of a symbol at a point in time.
+type Quote struct {
+	SymbolId int64
+	Time     time.Time
+	Bid      decimal.Decimal
+	Ask      decimal.Decimal
+}
+
+func (quote Quote) Mid() decimal.Decimal {
+	return quote.Bid.Add(quote.Ask).Div(decimal.NewFromInt(2))
+}
+
+func (quote Quote) Spread() decimal.Decimal {
+	return quote.Ask.Sub(quote.Bid)
+}
@@ -0,0 +1,191 @@
+package ctrader
+
+import (
+	"context"
+	"github.com/shopspring/decimal"
+	"github.com/ty2/ctrader-go/proto/openapi"
+	"github.com/vmware/transport-go/bus"
+	"github.com/vmware/transport-go/model"
+	"sync"
+	"time"
+)
+
+// DefaultQuoteMaxAge is the age after which a cached quote is flagged as stale.
+const DefaultQuoteMaxAge = 30 * time.Second
+
+const quoteChannel = "quote"
+
+// CachedQuote is a complete quote from the QuoteCache. Stale is set when no spot event for the
+// symbol arrived within the cache max age.
+type CachedQuote struct {
+	Quote
+	Received time.Time
+	Stale    bool
+}
+
+type quoteState struct {
+	quote    Quote
+	hasBid   bool
+	hasAsk   bool
+	received time.Time
+}
+
+// QuoteCache merges the partial bid/ask updates of spot events into the latest quote per symbol.
+type QuoteCache struct {
+	spotHandler bus.MessageHandler
+	eventBus    bus.EventBus
+	now         func() time.Time
+
+	mu      sync.Mutex
+	maxAge  time.Duration
+	quotes  map[int64]*quoteState
+	updated map[int64]chan struct{}
+}
+
+func NewQuoteCache() *QuoteCache {
+	cache := &QuoteCache{
+		eventBus: bus.NewEventBusInstance(),
+		now:      time.Now,
+		maxAge:   DefaultQuoteMaxAge,
+		quotes:   map[int64]*quoteState{},
+		updated:  map[int64]chan struct{}{},
+	}
+	cache.eventBus.GetChannelManager().CreateChannel(quoteChannel)
+
+	return cache
+}
+
+func newAccountQuoteCache(account *Account) (*QuoteCache, error) {
+	cache := NewQuoteCache()
+
+	spotHandler, err := account.OnSpot()
+	if err != nil {
+		return nil, err
+	}
+
+	spotHandler.Handle(
+		func(msg *model.Message) {
+			if v, ok := msg.Payload.(*openapi.ProtoOASpotEvent); ok {
+				cache.Apply(v)
+			}
+		},
+		func(err error) {
+			logger.Warn(err.Error())
+		})
+	cache.spotHandler = spotHandler
+
+	return cache, nil
+}
+
+// Quotes returns the quote cache fed by the account spot events of subscribed symbols.
+func (account *Account) Quotes() *QuoteCache {
+	return account.quotes
+}
+
+func (cache *QuoteCache) SetMaxAge(maxAge time.Duration) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.maxAge = maxAge
+}
+
+// Apply merges a spot event into the cached quote of its symbol and publishes it to OnUpdate.
+func (cache *QuoteCache) Apply(event *openapi.ProtoOASpotEvent) {
+	cache.apply(event)
+
+	if err := cache.eventBus.SendBroadcastMessage(quoteChannel, event); err != nil {
+		logger.Warn(err.Error())
+	}
+}
+
+func (cache *QuoteCache) apply(event *openapi.ProtoOASpotEvent) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	symbolId := event.GetSymbolId()
+	state, ok := cache.quotes[symbolId]
+	if !ok {
+		state = &quoteState{quote: Quote{SymbolId: symbolId}}
+		cache.quotes[symbolId] = state
+	}
+
+	if event.Bid != nil {
+		state.quote.Bid, state.hasBid = decimal.New(int64(event.GetBid()), -PriceScale), true
+	}
+	if event.Ask != nil {
+		state.quote.Ask, state.hasAsk = decimal.New(int64(event.GetAsk()), -PriceScale), true
+	}
+
+	state.received = cache.now()
+	state.quote.Time = state.received
+	if event.Timestamp != nil {
+		state.quote.Time = time.Unix(0, event.GetTimestamp()*int64(time.Millisecond))
+	}
+
+	if ch, ok := cache.updated[symbolId]; ok {
+		close(ch)
+		delete(cache.updated, symbolId)
+	}
+}
+
+func (cache *QuoteCache) last(symbolId int64) (CachedQuote, bool) {
+	state, ok := cache.quotes[symbolId]
+	if !ok || !state.hasBid || !state.hasAsk {
+		return CachedQuote{}, false
+	}
+
+	return CachedQuote{
+		Quote:    state.quote,
+		Received: state.received,
+		Stale:    cache.maxAge > 0 && cache.now().Sub(state.received) > cache.maxAge,
+	}, true
+}
+
+// OnUpdate delivers each *openapi.ProtoOASpotEvent once it is applied, so that listeners reading
+// the cache see its quote, unlike listeners of Account.OnSpot.
+func (cache *QuoteCache) OnUpdate() (bus.MessageHandler, error) {
+	return cache.eventBus.ListenFirehose(quoteChannel)
+}
+
+// Last returns the latest quote of symbolId once both bid and ask are known.
+func (cache *QuoteCache) Last(symbolId int64) (CachedQuote, bool) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	return cache.last(symbolId)
+}
+
+// WaitForQuote returns the quote of symbolId as soon as it is complete and not stale.
+func (cache *QuoteCache) WaitForQuote(ctx context.Context, symbolId int64) (CachedQuote, error) {
+	for {
+		cache.mu.Lock()
+		if quote, ok := cache.last(symbolId); ok && !quote.Stale {
+			cache.mu.Unlock()
+			return quote, nil
+		}
+
+		ch, ok := cache.updated[symbolId]
+		if !ok {
+			ch = make(chan struct{})
+			cache.updated[symbolId] = ch
+		}
+		cache.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return CachedQuote{}, ctx.Err()
+		case <-ch:
+		}
+	}
+}
+
+// Forget drops the quote of symbolId, e.g. after unsubscribing.
+func (cache *QuoteCache) Forget(symbolId int64) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	delete(cache.quotes, symbolId)
+}
+
+func (cache *QuoteCache) Close() {
+	if cache.spotHandler != nil {
+		cache.spotHandler.Close()
+	}
+}
@@ -0,0 +1,99 @@
+package ctrader
+
+import (
+	"context"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ty2/ctrader-go/proto/openapi"
+	"github.com/vmware/transport-go/model"
+	"testing"
+	"time"
+)
+
+func spotEvent(symbolId int64, bid, ask uint64) *openapi.ProtoOASpotEvent {
+	event := &openapi.ProtoOASpotEvent{SymbolId: &symbolId}
+	if bid > 0 {
+		event.Bid = &bid
+	}
+	if ask > 0 {
+		event.Ask = &ask
+	}
+	return event
+}
+
+func TestQuoteCache(t *testing.T) {
+	Convey("QuoteCache", t, func() {
+		cache := NewQuoteCache()
+		now := time.Date(2022, 1, 3, 10, 0, 0, 0, time.UTC)
+		cache.now = func() time.Time { return now }
+
+		Convey("merges partial updates", func() {
+			cache.Apply(spotEvent(1, 110000, 0))
+			_, ok := cache.Last(1)
+			So(ok, ShouldBeFalse)
+
+			cache.Apply(spotEvent(1, 0, 110020))
+			cache.Apply(spotEvent(1, 110010, 0))
+			quote, ok := cache.Last(1)
+			So(ok, ShouldBeTrue)
+			So(quote.Bid.String(), ShouldEqual, "1.1001")
+			So(quote.Ask.String(), ShouldEqual, "1.1002")
+			So(quote.Stale, ShouldBeFalse)
+
+			now = now.Add(DefaultQuoteMaxAge + time.Second)
+			quote, _ = cache.Last(1)
+			So(quote.Stale, ShouldBeTrue)
+		})
+
+		Convey("waits for a complete quote", func() {
+			done := make(chan CachedQuote)
+			go func() {
+				quote, _ := cache.WaitForQuote(context.Background(), 2)
+				done <- quote
+			}()
+
+			cache.Apply(spotEvent(2, 110000, 0))
+			cache.Apply(spotEvent(2, 0, 110005))
+			select {
+			case quote := <-done:
+				So(quote.Spread().String(), ShouldEqual, "0.00005")
+			case <-time.After(time.Second):
+				So("timeout", ShouldBeEmpty)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+			_, err := cache.WaitForQuote(ctx, 3)
+			So(err, ShouldResemble, context.DeadlineExceeded)
+		})
+
+		Convey("is fed by account spot events", func() {
+			account := newTestAccount(1)
+			account.publish(openapi.ProtoOAPayloadType_PROTO_OA_SPOT_EVENT, spotEvent(5, 100000, 100010))
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			quote, err := account.Quotes().WaitForQuote(ctx, 5)
+			So(err, ShouldBeNil)
+			So(quote.Mid().String(), ShouldEqual, "1.00005")
+		})
+
+		Convey("publishes events after applying them", func() {
+			handler, err := cache.OnUpdate()
+			So(err, ShouldBeNil)
+			defer handler.Close()
+			mids := make(chan string, 1)
+			handler.Handle(func(msg *model.Message) {
+				quote, _ := cache.Last(msg.Payload.(*openapi.ProtoOASpotEvent).GetSymbolId())
+				mids <- quote.Mid().String()
+			}, func(err error) {})
+
+			cache.Apply(spotEvent(6, 100000, 100010))
+			select {
+			case mid := <-mids:
+				So(mid, ShouldEqual, "1.00005")
+			case <-time.After(time.Second):
+				So("no update", ShouldBeEmpty)
+			}
+		})
+	})
+}
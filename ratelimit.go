@@ -0,0 +1,79 @@
+package ctrader
+
+import (
+	"context"
+	"github.com/ty2/ctrader-go/proto/openapi"
+	"sync"
+	"time"
+)
+
+// HistoricalRequestsPerSecond is the server limit for historical data requests (trend bars, ticks, deals) per connection.
+const HistoricalRequestsPerSecond = 5
+
+// rateLimiter spaces calls evenly so that at most n start in any interval.
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+func newRateLimiter(n int, per time.Duration) *rateLimiter {
+	return &rateLimiter{interval: per / time.Duration(n)}
+}
+
+// Wait blocks until the next slot is free or ctx is done.
+func (limiter *rateLimiter) Wait(ctx context.Context) error {
+	limiter.mu.Lock()
+	now := time.Now()
+	at := limiter.next
+	if at.Before(now) {
+		at = now
+	}
+	limiter.next = at.Add(limiter.interval)
+	limiter.mu.Unlock()
+
+	d := time.Until(at)
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func isFrequencyExceeded(err error) bool {
+	v, ok := err.(*ResponseMessageHandlerError)
+	if !ok {
+		return false
+	}
+
+	res, ok := v.Payload.(*openapi.ProtoOAErrorRes)
+	return ok && res.GetErrorCode() == openapi.ProtoOAErrorCode_REQUEST_FREQUENCY_EXCEEDED.String()
+}
+
+// historicalRequest runs fn within the connection's historical rate limit and retries it
+// with a growing pause when the server still reports REQUEST_FREQUENCY_EXCEEDED.
+func (account *Account) historicalRequest(ctx context.Context, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		if err := account.client.historicalLimiter.Wait(ctx); err != nil {
+			return err
+		}
+
+		err := fn()
+		if err == nil || !isFrequencyExceeded(err) || attempt == 5 {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(attempt) * time.Second):
+		}
+	}
+}
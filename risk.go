@@ -0,0 +1,524 @@
+package ctrader
+
+import (
+	"errors"
+	"fmt"
+	"github.com/shopspring/decimal"
+	"github.com/ty2/ctrader-go/proto/openapi"
+	"sync"
+	"time"
+)
+
+// RiskRule names the pre-trade check that rejected an order.
+type RiskRule string
+
+const (
+	RuleMaxOrderVolume   RiskRule = "max-order-volume"
+	RuleMaxSymbolVolume  RiskRule = "max-symbol-volume"
+	RuleMaxTotalExposure RiskRule = "max-total-exposure"
+	RuleMaxOpenOrders    RiskRule = "max-open-orders"
+	RulePriceCollar      RiskRule = "price-collar"
+	RuleRestrictedSymbol RiskRule = "restricted-symbol"
+	RuleTradingHours     RiskRule = "trading-hours"
+)
+
+// RiskError is returned by NewOrder and AmendOrder when a pre-trade check rejects the order.
+// The request is not sent.
+type RiskError struct {
+	Rule   RiskRule
+	Order  RiskOrder
+	Reason string
+}
+
+func (err *RiskError) Error() string {
+	return fmt.Sprintf("risk check %s rejected order on symbol %d: %s", err.Rule, err.Order.SymbolId, err.Reason)
+}
+
+// RiskOrder is the order a check sees. Amendments carry the OrderId and the values the order will
+// have after the amendment. Price is the limit or stop price and zero for market orders.
+type RiskOrder struct {
+	OrderId    int64
+	SymbolId   int64
+	OrderType  openapi.ProtoOAOrderType
+	TradeSide  openapi.ProtoOATradeSide
+	Volume     int64
+	Price      decimal.Decimal
+	PositionId int64
+}
+
+func (order RiskOrder) Amendment() bool {
+	return order.OrderId != 0
+}
+
+// RiskCheck returns a *RiskError to reject an order. Other errors reject it as well.
+type RiskCheck func(order RiskOrder, state *RiskState) error
+
+// RiskState gives checks access to the cached state of the account. Checks never wait for the
+// server: state that is not loaded fails them, see newAccountRiskManager.
+type RiskState struct {
+	Time time.Time
+
+	risk     *RiskManager
+	snapshot *PortfolioSnapshot
+}
+
+func (state *RiskState) Portfolio() (PortfolioSnapshot, error) {
+	if state.snapshot == nil {
+		snapshot, err := state.risk.portfolio()
+		if err != nil {
+			return PortfolioSnapshot{}, err
+		}
+		state.snapshot = &snapshot
+	}
+
+	return *state.snapshot, nil
+}
+
+func (state *RiskState) Quote(symbolId int64) (CachedQuote, bool) {
+	return state.risk.quote(symbolId)
+}
+
+func (state *RiskState) Symbol(symbolId int64) (*SymbolInfo, error) {
+	return state.risk.symbolInfo(symbolId)
+}
+
+// Notional is the value of volume on a symbol in the deposit currency at mid prices. It is a
+// *MissingQuoteError until the conversion path is loaded, which then starts in the background, and
+// quoted.
+func (state *RiskState) Notional(symbolId, volume int64) (decimal.Decimal, error) {
+	if volume == 0 {
+		return decimal.Zero, nil
+	}
+
+	snapshot, err := state.Portfolio()
+	if err != nil {
+		return decimal.Zero, err
+	}
+	info, err := state.Symbol(symbolId)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	converter, err := state.risk.loadConverter()
+	if err != nil {
+		return decimal.Zero, err
+	}
+
+	fromAssetId, toAssetId := info.LightSymbol().GetBaseAssetId(), snapshot.Trader.GetDepositAssetId()
+	if !converter.Loaded(fromAssetId, toAssetId) {
+		go func() {
+			if _, err := converter.Path(fromAssetId, toAssetId); err != nil {
+				logger.Warn(fmt.Sprintf("load conversion path from asset %d to %d: %s", fromAssetId, toAssetId, err))
+			}
+		}()
+		return decimal.Zero, &MissingQuoteError{SymbolId: symbolId}
+	}
+
+	return converter.Convert(info.VolumeToUnits(volume), fromAssetId, toAssetId, MidPrice)
+}
+
+func reject(rule RiskRule, order RiskOrder, format string, a ...interface{}) error {
+	return &RiskError{Rule: rule, Order: order, Reason: fmt.Sprintf(format, a...)}
+}
+
+// MaxOrderVolume rejects orders above volume.
+func MaxOrderVolume(volume int64) RiskCheck {
+	return func(order RiskOrder, state *RiskState) error {
+		if order.Volume > volume {
+			return reject(RuleMaxOrderVolume, order, "volume %d is above %d", order.Volume, volume)
+		}
+		return nil
+	}
+}
+
+// netVolume is the buy minus the sell volume of the open positions on a symbol.
+func netVolume(positions []*openapi.ProtoOAPosition, symbolId int64) int64 {
+	var net int64
+	for _, position := range positions {
+		tradeData := position.GetTradeData()
+		if tradeData.GetSymbolId() != symbolId {
+			continue
+		}
+		if tradeData.GetTradeSide() == openapi.ProtoOATradeSide_SELL {
+			net -= tradeData.GetVolume()
+		} else {
+			net += tradeData.GetVolume()
+		}
+	}
+
+	return net
+}
+
+// MaxSymbolVolume rejects orders after which the net position on their symbol would be above
+// volume on either side. Other pending orders are not counted.
+func MaxSymbolVolume(volume int64) RiskCheck {
+	return func(order RiskOrder, state *RiskState) error {
+		snapshot, err := state.Portfolio()
+		if err != nil {
+			return err
+		}
+
+		net := netVolume(snapshot.Positions, order.SymbolId)
+		after := net + order.Volume
+		if order.TradeSide == openapi.ProtoOATradeSide_SELL {
+			after = net - order.Volume
+		}
+		if after < 0 {
+			after = -after
+		}
+		// reducing an oversized position is always allowed
+		if after > volume && after > abs(net) {
+			return reject(RuleMaxSymbolVolume, order, "position would be %d, above %d", after, volume)
+		}
+		return nil
+	}
+}
+
+func abs(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// MaxTotalExposure rejects orders after which the notional of the net positions per symbol would
+// be above limit in the deposit currency. Orders reducing the exposure are always allowed. Other
+// orders are rejected while the exposure cannot be valued because a symbol has no quote yet, e.g.
+// right after start.
+func MaxTotalExposure(limit decimal.Decimal) RiskCheck {
+	return func(order RiskOrder, state *RiskState) error {
+		snapshot, err := state.Portfolio()
+		if err != nil {
+			return err
+		}
+
+		// reducing orders pass without quotes
+		current := netVolume(snapshot.Positions, order.SymbolId)
+		next := current - order.Volume
+		if order.TradeSide != openapi.ProtoOATradeSide_SELL {
+			next = current + order.Volume
+		}
+		if abs(next) <= abs(current) {
+			return nil
+		}
+
+		before, after := decimal.Zero, decimal.Zero
+		symbolIds := map[int64]bool{order.SymbolId: true}
+		for _, position := range snapshot.Positions {
+			symbolIds[position.GetTradeData().GetSymbolId()] = true
+		}
+		for symbolId := range symbolIds {
+			net := netVolume(snapshot.Positions, symbolId)
+			notional, err := state.Notional(symbolId, abs(net))
+			if err != nil {
+				return exposureError(order, err)
+			}
+			before = before.Add(notional)
+
+			if symbolId == order.SymbolId {
+				if order.TradeSide == openapi.ProtoOATradeSide_SELL {
+					net -= order.Volume
+				} else {
+					net += order.Volume
+				}
+				if notional, err = state.Notional(symbolId, abs(net)); err != nil {
+					return exposureError(order, err)
+				}
+			}
+			after = after.Add(notional)
+		}
+
+		if after.GreaterThan(limit) && after.GreaterThan(before) {
+			return reject(RuleMaxTotalExposure, order, "exposure would be %s, above %s", after.StringFixed(2), limit)
+		}
+		return nil
+	}
+}
+
+// exposureError rejects an order whose exposure has no quote to be valued with.
+func exposureError(order RiskOrder, err error) error {
+	var missingQuote *MissingQuoteError
+	if errors.As(err, &missingQuote) {
+		return reject(RuleMaxTotalExposure, order, "exposure cannot be valued: %s", err)
+	}
+	return err
+}
+
+// MaxOpenOrders rejects new pending orders when count orders are already pending.
+func MaxOpenOrders(count int) RiskCheck {
+	return func(order RiskOrder, state *RiskState) error {
+		if order.Amendment() || isMarketOrder(order.OrderType) {
+			return nil
+		}
+
+		snapshot, err := state.Portfolio()
+		if err != nil {
+			return err
+		}
+		if len(snapshot.Orders) >= count {
+			return reject(RuleMaxOpenOrders, order, "%d orders are open", len(snapshot.Orders))
+		}
+		return nil
+	}
+}
+
+func isMarketOrder(orderType openapi.ProtoOAOrderType) bool {
+	return orderType == openapi.ProtoOAOrderType_MARKET || orderType == openapi.ProtoOAOrderType_MARKET_RANGE
+}
+
+// PriceCollar rejects pending orders priced further than fraction (0.05 is 5%) from the mid of
+// the last quote, and orders on symbols without a fresh quote.
+func PriceCollar(fraction decimal.Decimal) RiskCheck {
+	return func(order RiskOrder, state *RiskState) error {
+		if order.Price.IsZero() {
+			return nil
+		}
+
+		quote, ok := state.Quote(order.SymbolId)
+		if !ok || quote.Stale {
+			return reject(RulePriceCollar, order, "no fresh quote")
+		}
+
+		mid := quote.Mid()
+		if order.Price.Sub(mid).Abs().GreaterThan(mid.Mul(fraction)) {
+			return reject(RulePriceCollar, order, "price %s is too far from %s", order.Price, mid)
+		}
+		return nil
+	}
+}
+
+// RestrictedSymbols rejects orders on symbolIds.
+func RestrictedSymbols(symbolIds ...int64) RiskCheck {
+	restricted := map[int64]bool{}
+	for _, symbolId := range symbolIds {
+		restricted[symbolId] = true
+	}
+
+	return func(order RiskOrder, state *RiskState) error {
+		if restricted[order.SymbolId] {
+			return reject(RuleRestrictedSymbol, order, "symbol is restricted")
+		}
+		return nil
+	}
+}
+
+// TradingHours rejects orders while the symbol is closed, see SymbolInfo.IsOpen.
+func TradingHours() RiskCheck {
+	return func(order RiskOrder, state *RiskState) error {
+		info, err := state.Symbol(order.SymbolId)
+		if err != nil {
+			return err
+		}
+
+		open, err := info.IsOpen(state.Time)
+		if err != nil {
+			return err
+		}
+		if !open {
+			return reject(RuleTradingHours, order, "market is closed")
+		}
+		return nil
+	}
+}
+
+// RiskManager runs the pre-trade checks of an account. Without checks it does nothing. With the
+// override set, rejections are only logged so operators can trade through them.
+type RiskManager struct {
+	portfolio  func() (PortfolioSnapshot, error)
+	quote      func(symbolId int64) (CachedQuote, bool)
+	symbolInfo func(symbolId int64) (*SymbolInfo, error)
+	converter  func() (*Converter, error)
+	now        func() time.Time
+
+	mu              sync.Mutex
+	checks          []RiskCheck
+	override        bool
+	loadedConverter *Converter
+}
+
+func newRiskManager() *RiskManager {
+	return &RiskManager{now: time.Now}
+}
+
+// newAccountRiskManager reads the account caches only. Checks on the portfolio fail until
+// Account.Portfolio has been called, and symbols that are not cached fail them while they are
+// loaded in the background.
+func newAccountRiskManager(account *Account) *RiskManager {
+	risk := newRiskManager()
+	risk.portfolio = func() (PortfolioSnapshot, error) {
+		portfolio, ok := account.loadedPortfolio()
+		if !ok {
+			return PortfolioSnapshot{}, errors.New("portfolio is not loaded")
+		}
+		return portfolio.Snapshot(), nil
+	}
+	risk.quote = account.quotes.Last
+	risk.symbolInfo = func(symbolId int64) (*SymbolInfo, error) {
+		if info, ok := account.symbols.CachedInfo(symbolId); ok {
+			return info, nil
+		}
+		go func() {
+			if _, err := account.symbols.Info(symbolId); err != nil {
+				logger.Warn(fmt.Sprintf("load symbol %d: %s", symbolId, err))
+			}
+		}()
+		return nil, fmt.Errorf("symbol %d is not loaded", symbolId)
+	}
+	risk.converter = account.Converter
+
+	return risk
+}
+
+// Risk returns the pre-trade checks run by NewOrder and AmendOrder.
+func (account *Account) Risk() *RiskManager {
+	return account.risk
+}
+
+func (risk *RiskManager) Add(checks ...RiskCheck) {
+	risk.mu.Lock()
+	defer risk.mu.Unlock()
+	risk.checks = append(risk.checks, checks...)
+}
+
+// Reset removes all checks.
+func (risk *RiskManager) Reset() {
+	risk.mu.Lock()
+	defer risk.mu.Unlock()
+	risk.checks = nil
+}
+
+// SetOverride lets orders through that the checks reject.
+func (risk *RiskManager) SetOverride(override bool) {
+	risk.mu.Lock()
+	defer risk.mu.Unlock()
+	risk.override = override
+}
+
+func (risk *RiskManager) loadConverter() (*Converter, error) {
+	risk.mu.Lock()
+	defer risk.mu.Unlock()
+
+	if risk.loadedConverter == nil {
+		converter, err := risk.converter()
+		if err != nil {
+			return nil, err
+		}
+		risk.loadedConverter = converter
+	}
+
+	return risk.loadedConverter, nil
+}
+
+// Close releases the spot subscriptions of the converter loaded by the checks.
+func (risk *RiskManager) Close() {
+	risk.mu.Lock()
+	converter := risk.loadedConverter
+	risk.loadedConverter = nil
+	risk.mu.Unlock()
+
+	if converter != nil {
+		converter.Close()
+	}
+}
+
+// Check runs all checks against an order and returns the first rejection.
+func (risk *RiskManager) Check(order RiskOrder) error {
+	risk.mu.Lock()
+	checks, override := risk.checks, risk.override
+	risk.mu.Unlock()
+
+	state := &RiskState{Time: risk.now(), risk: risk}
+	for _, check := range checks {
+		if err := check(order, state); err != nil {
+			if override {
+				logger.Warn("risk check overridden: " + err.Error())
+				continue
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+// fail returns err unless the override is set.
+func (risk *RiskManager) fail(err error) error {
+	risk.mu.Lock()
+	override := risk.override
+	risk.mu.Unlock()
+
+	if override {
+		logger.Warn("risk check overridden: " + err.Error())
+		return nil
+	}
+	return err
+}
+
+// CheckNewOrder checks the order of a NewOrder request.
+func (risk *RiskManager) CheckNewOrder(req *openapi.ProtoOANewOrderReq) error {
+	order := RiskOrder{
+		SymbolId:   req.GetSymbolId(),
+		OrderType:  req.GetOrderType(),
+		TradeSide:  req.GetTradeSide(),
+		Volume:     req.GetVolume(),
+		PositionId: req.GetPositionId(),
+	}
+	if req.LimitPrice != nil {
+		order.Price = decimal.NewFromFloat(req.GetLimitPrice())
+	} else if req.StopPrice != nil {
+		order.Price = decimal.NewFromFloat(req.GetStopPrice())
+	}
+
+	return risk.Check(order)
+}
+
+// CheckAmendOrder checks a pending order of the portfolio with the changes of an AmendOrder
+// request applied.
+func (risk *RiskManager) CheckAmendOrder(req *openapi.ProtoOAAmendOrderReq) error {
+	risk.mu.Lock()
+	empty := len(risk.checks) == 0
+	risk.mu.Unlock()
+	if empty {
+		return nil
+	}
+
+	snapshot, err := risk.portfolio()
+	if err != nil {
+		return risk.fail(err)
+	}
+
+	var pending *openapi.ProtoOAOrder
+	for _, order := range snapshot.Orders {
+		if order.GetOrderId() == req.GetOrderId() {
+			pending = order
+			break
+		}
+	}
+	if pending == nil {
+		return risk.fail(fmt.Errorf("order %d is not pending", req.GetOrderId()))
+	}
+
+	tradeData := pending.GetTradeData()
+	order := RiskOrder{
+		OrderId:    pending.GetOrderId(),
+		SymbolId:   tradeData.GetSymbolId(),
+		OrderType:  pending.GetOrderType(),
+		TradeSide:  tradeData.GetTradeSide(),
+		Volume:     tradeData.GetVolume(),
+		PositionId: pending.GetPositionId(),
+	}
+	if req.Volume != nil {
+		order.Volume = req.GetVolume()
+	}
+	switch {
+	case req.LimitPrice != nil:
+		order.Price = decimal.NewFromFloat(req.GetLimitPrice())
+	case req.StopPrice != nil:
+		order.Price = decimal.NewFromFloat(req.GetStopPrice())
+	case pending.LimitPrice != nil:
+		order.Price = decimal.NewFromFloat(pending.GetLimitPrice())
+	case pending.StopPrice != nil:
+		order.Price = decimal.NewFromFloat(pending.GetStopPrice())
+	}
+
+	return risk.Check(order)
+}
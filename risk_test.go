@@ -0,0 +1,193 @@
+package ctrader
+
+import (
+	"errors"
+	"github.com/shopspring/decimal"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ty2/ctrader-go/proto/openapi"
+	"google.golang.org/protobuf/proto"
+	"testing"
+	"time"
+)
+
+func TestRiskManager(t *testing.T) {
+	Convey("RiskManager", t, func() {
+		// assets: 1 USD (deposit), 2 EUR
+		position := testPosition(1, 100000, openapi.ProtoOAPositionStatus_POSITION_STATUS_OPEN, 1)
+		pending := testOrder(2, openapi.ProtoOAOrderType_LIMIT, openapi.ProtoOAOrderStatus_ORDER_STATUS_ACCEPTED, 1)
+		portfolio := NewPortfolio()
+		portfolio.Seed(&openapi.ProtoOATrader{DepositAssetId: proto.Int64(1)},
+			&openapi.ProtoOAReconcileRes{Position: []*openapi.ProtoOAPosition{position}, Order: []*openapi.ProtoOAOrder{pending}})
+
+		quotes := NewQuoteCache()
+		quotes.Apply(testSpot(1, 110000, 110010))
+		converter := newConverter(quotes)
+		converter.conversionChain = func(fromAssetId, toAssetId int64) ([]*openapi.ProtoOALightSymbol, error) {
+			return []*openapi.ProtoOALightSymbol{testLightSymbol(1, 2, 1)}, nil
+		}
+		_, err := converter.Path(2, 1)
+		So(err, ShouldBeNil)
+
+		risk := newRiskManager()
+		risk.portfolio = func() (PortfolioSnapshot, error) { return portfolio.Snapshot(), nil }
+		risk.quote = quotes.Last
+		risk.symbolInfo = func(symbolId int64) (*SymbolInfo, error) {
+			return NewSymbolInfo(testSymbol(), testLightSymbol(1, 2, 1)), nil
+		}
+		risk.converter = func() (*Converter, error) { return converter, nil }
+		risk.now = func() time.Time { return time.Date(2021, 6, 7, 10, 0, 0, 0, time.UTC) }
+
+		order := RiskOrder{
+			SymbolId:  1,
+			OrderType: openapi.ProtoOAOrderType_LIMIT,
+			TradeSide: openapi.ProtoOATradeSide_BUY,
+			Volume:    100000,
+			Price:     decimal.RequireFromString("1.1"),
+		}
+		rule := func(err error) RiskRule {
+			var riskErr *RiskError
+			if errors.As(err, &riskErr) {
+				return riskErr.Rule
+			}
+			return ""
+		}
+
+		Convey("passes without checks", func() {
+			So(risk.Check(order), ShouldBeNil)
+		})
+
+		Convey("limits the order volume", func() {
+			risk.Add(MaxOrderVolume(100000))
+			So(risk.Check(order), ShouldBeNil)
+			order.Volume = 200000
+			So(rule(risk.Check(order)), ShouldEqual, RuleMaxOrderVolume)
+		})
+
+		Convey("limits the net position but allows reducing it", func() {
+			risk.Add(MaxSymbolVolume(150000))
+			So(rule(risk.Check(order)), ShouldEqual, RuleMaxSymbolVolume)
+			order.TradeSide = openapi.ProtoOATradeSide_SELL
+			order.Volume = 200000
+			So(risk.Check(order), ShouldBeNil)
+			order.Volume = 300000
+			So(rule(risk.Check(order)), ShouldEqual, RuleMaxSymbolVolume)
+		})
+
+		Convey("limits the total exposure in the deposit currency", func() {
+			// the position is 1000 EUR at 1.10005
+			risk.Add(MaxTotalExposure(decimal.NewFromInt(2000)))
+			So(rule(risk.Check(order)), ShouldEqual, RuleMaxTotalExposure)
+			order.TradeSide = openapi.ProtoOATradeSide_SELL
+			So(risk.Check(order), ShouldBeNil)
+		})
+
+		Convey("rejects exposure it cannot value yet but lets reducing orders through", func() {
+			released := 0
+			subscriptions := newSubscriptionManager(nil)
+			subscriptions.subscribeSpots = func(ids []int64) error { return nil }
+			subscriptions.unsubscribeSpots = func(ids []int64) error {
+				released += len(ids)
+				return nil
+			}
+			cold := newConverter(NewQuoteCache())
+			cold.conversionChain = converter.conversionChain
+			cold.subscribe = subscriptions.Spots
+			risk.converter = func() (*Converter, error) { return cold, nil }
+			risk.Add(MaxTotalExposure(decimal.NewFromInt(1000000)))
+
+			// the path is loaded in the background
+			So(rule(risk.Check(order)), ShouldEqual, RuleMaxTotalExposure)
+			for i := 0; i < 100 && !cold.Loaded(2, 1); i++ {
+				time.Sleep(10 * time.Millisecond)
+			}
+			So(cold.Loaded(2, 1), ShouldBeTrue)
+			So(rule(risk.Check(order)), ShouldEqual, RuleMaxTotalExposure)
+
+			order.TradeSide = openapi.ProtoOATradeSide_SELL
+			So(risk.Check(order), ShouldBeNil)
+
+			cold.quotes.Apply(testSpot(1, 110000, 110010))
+			order.TradeSide = openapi.ProtoOATradeSide_BUY
+			So(risk.Check(order), ShouldBeNil)
+
+			risk.Close()
+			So(released, ShouldEqual, 1)
+		})
+
+		Convey("limits the open orders", func() {
+			risk.Add(MaxOpenOrders(1))
+			So(rule(risk.Check(order)), ShouldEqual, RuleMaxOpenOrders)
+			order.OrderType = openapi.ProtoOAOrderType_MARKET
+			So(risk.Check(order), ShouldBeNil)
+		})
+
+		Convey("collars prices around the last quote", func() {
+			risk.Add(PriceCollar(decimal.RequireFromString("0.01")))
+			So(risk.Check(order), ShouldBeNil)
+			order.Price = decimal.RequireFromString("1.2")
+			So(rule(risk.Check(order)), ShouldEqual, RulePriceCollar)
+			order.SymbolId = 3
+			order.Price = decimal.RequireFromString("1.1")
+			So(rule(risk.Check(order)), ShouldEqual, RulePriceCollar)
+		})
+
+		Convey("rejects restricted symbols", func() {
+			risk.Add(RestrictedSymbols(1))
+			So(rule(risk.Check(order)), ShouldEqual, RuleRestrictedSymbol)
+		})
+
+		Convey("rejects closed symbols", func() {
+			risk.Add(TradingHours())
+			So(risk.Check(order), ShouldBeNil)
+			risk.symbolInfo = func(symbolId int64) (*SymbolInfo, error) {
+				symbol := testSymbol()
+				symbol.TradingMode = openapi.ProtoOATradingMode_DISABLED_WITHOUT_PENDINGS_EXECUTION.Enum()
+				return NewSymbolInfo(symbol, nil), nil
+			}
+			So(rule(risk.Check(order)), ShouldEqual, RuleTradingHours)
+		})
+
+		Convey("lets orders through with the override", func() {
+			risk.Add(RestrictedSymbols(1))
+			risk.SetOverride(true)
+			So(risk.Check(order), ShouldBeNil)
+			risk.SetOverride(false)
+			So(risk.Check(order), ShouldNotBeNil)
+			risk.Reset()
+			So(risk.Check(order), ShouldBeNil)
+		})
+
+		Convey("checks amendments against the pending order", func() {
+			risk.Add(MaxOrderVolume(150000), MaxOpenOrders(1))
+			So(risk.CheckAmendOrder(&openapi.ProtoOAAmendOrderReq{OrderId: proto.Int64(2), LimitPrice: proto.Float64(1.09)}), ShouldBeNil)
+			err := risk.CheckAmendOrder(&openapi.ProtoOAAmendOrderReq{OrderId: proto.Int64(2), Volume: proto.Int64(200000)})
+			So(rule(err), ShouldEqual, RuleMaxOrderVolume)
+			So(err.(*RiskError).Order.OrderId, ShouldEqual, 2)
+			So(risk.CheckAmendOrder(&openapi.ProtoOAAmendOrderReq{OrderId: proto.Int64(3)}), ShouldNotBeNil)
+		})
+
+		Convey("reads only the cached account state", func() {
+			account := newTestAccount(1)
+			account.Risk().Add(MaxOpenOrders(1))
+			_, err := account.Risk().portfolio()
+			So(err, ShouldNotBeNil)
+			So(account.Risk().Check(order), ShouldNotBeNil)
+
+			account.portfolio = portfolio
+			So(rule(account.Risk().Check(order)), ShouldEqual, RuleMaxOpenOrders)
+		})
+
+		Convey("rejects NewOrder locally", func() {
+			account := newTestAccount(1)
+			account.Risk().Add(RestrictedSymbols(1))
+			// the account has no client, sending would panic
+			_, err := account.NewOrder(&openapi.ProtoOANewOrderReq{
+				SymbolId:  proto.Int64(1),
+				OrderType: openapi.ProtoOAOrderType_MARKET.Enum(),
+				TradeSide: openapi.ProtoOATradeSide_BUY.Enum(),
+				Volume:    proto.Int64(100000),
+			})
+			So(rule(err), ShouldEqual, RuleRestrictedSymbol)
+		})
+	})
+}
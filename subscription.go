@@ -0,0 +1,201 @@
+package ctrader
+
+import (
+	"github.com/ty2/ctrader-go/proto/openapi"
+	"sync"
+)
+
+type trendbarKey struct {
+	symbolId int64
+	period   openapi.ProtoOATrendbarPeriod
+}
+
+// SubscriptionManager reference counts spot, depth and live trend bar subscriptions so that
+// components can share symbols. Wire requests are only sent when a count goes from zero to one
+// or back, and symbols acquired together are sent in one request.
+type SubscriptionManager struct {
+	subscribeSpots          func(symbolIds []int64) error
+	unsubscribeSpots        func(symbolIds []int64) error
+	subscribeDepth          func(symbolIds []int64) error
+	unsubscribeDepth        func(symbolIds []int64) error
+	subscribeLiveTrendbar   func(symbolId int64, period openapi.ProtoOATrendbarPeriod) error
+	unsubscribeLiveTrendbar func(symbolId int64, period openapi.ProtoOATrendbarPeriod) error
+
+	mu        sync.Mutex
+	spots     map[int64]int
+	depth     map[int64]int
+	trendbars map[trendbarKey]int
+}
+
+// Subscription is a handle returned by SubscriptionManager; Close releases it once.
+type Subscription struct {
+	release func() error
+	once    sync.Once
+	err     error
+}
+
+func (subscription *Subscription) Close() error {
+	subscription.once.Do(func() {
+		subscription.err = subscription.release()
+	})
+
+	return subscription.err
+}
+
+func newSubscriptionManager(account *Account) *SubscriptionManager {
+	return &SubscriptionManager{
+		subscribeSpots: func(symbolIds []int64) error {
+			_, err := account.SubscribeSpots(symbolIds)
+			return err
+		},
+		unsubscribeSpots: func(symbolIds []int64) error {
+			_, err := account.UnsubscribeSpots(symbolIds)
+			return err
+		},
+		subscribeDepth: func(symbolIds []int64) error {
+			_, err := account.SubscribeDepthQuotes(symbolIds)
+			return err
+		},
+		unsubscribeDepth: func(symbolIds []int64) error {
+			_, err := account.UnsubscribeDepthQuotes(symbolIds)
+			return err
+		},
+		subscribeLiveTrendbar: func(symbolId int64, period openapi.ProtoOATrendbarPeriod) error {
+			_, err := account.SubscribeLiveTrendbar(symbolId, period)
+			return err
+		},
+		unsubscribeLiveTrendbar: func(symbolId int64, period openapi.ProtoOATrendbarPeriod) error {
+			_, err := account.UnsubscribeLiveTrendbar(symbolId, period)
+			return err
+		},
+		spots:     map[int64]int{},
+		depth:     map[int64]int{},
+		trendbars: map[trendbarKey]int{},
+	}
+}
+
+// Subscriptions returns the account subscription manager. Mixing it with direct Subscribe and
+// Unsubscribe calls for the same symbols defeats the reference counting.
+func (account *Account) Subscriptions() *SubscriptionManager {
+	return account.subscriptions
+}
+
+// acquire increments the counts of ids and returns the ids that need a wire subscription.
+func acquire(counts map[int64]int, ids []int64) []int64 {
+	var first []int64
+	seen := map[int64]bool{}
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		counts[id]++
+		if counts[id] == 1 {
+			first = append(first, id)
+		}
+	}
+
+	return first
+}
+
+// release decrements the counts of ids and returns the ids that need a wire unsubscription.
+func release(counts map[int64]int, ids []int64) []int64 {
+	var last []int64
+	seen := map[int64]bool{}
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		counts[id]--
+		if counts[id] <= 0 {
+			delete(counts, id)
+			last = append(last, id)
+		}
+	}
+
+	return last
+}
+
+func (manager *SubscriptionManager) acquireSymbols(counts map[int64]int, subscribe, unsubscribe func([]int64) error, symbolIds []int64) (*Subscription, error) {
+	ids := append([]int64{}, symbolIds...)
+
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	if first := acquire(counts, ids); len(first) > 0 {
+		if err := subscribe(first); err != nil {
+			release(counts, ids)
+			return nil, err
+		}
+	}
+
+	return &Subscription{release: func() error {
+		manager.mu.Lock()
+		defer manager.mu.Unlock()
+
+		if last := release(counts, ids); len(last) > 0 {
+			return unsubscribe(last)
+		}
+		return nil
+	}}, nil
+}
+
+// Spots keeps spot events of symbolIds flowing until the returned subscription is closed.
+func (manager *SubscriptionManager) Spots(symbolIds ...int64) (*Subscription, error) {
+	return manager.acquireSymbols(manager.spots, manager.subscribeSpots, manager.unsubscribeSpots, symbolIds)
+}
+
+// Depth keeps depth events of symbolIds flowing until the returned subscription is closed.
+func (manager *SubscriptionManager) Depth(symbolIds ...int64) (*Subscription, error) {
+	return manager.acquireSymbols(manager.depth, manager.subscribeDepth, manager.unsubscribeDepth, symbolIds)
+}
+
+// LiveTrendbar subscribes to the live bars of period, which the server sends inside spot events,
+// so a spot subscription of the symbol is held as well.
+func (manager *SubscriptionManager) LiveTrendbar(symbolId int64, period openapi.ProtoOATrendbarPeriod) (*Subscription, error) {
+	spots, err := manager.Spots(symbolId)
+	if err != nil {
+		return nil, err
+	}
+
+	key := trendbarKey{symbolId: symbolId, period: period}
+
+	manager.mu.Lock()
+	manager.trendbars[key]++
+	if manager.trendbars[key] == 1 {
+		if err := manager.subscribeLiveTrendbar(symbolId, period); err != nil {
+			manager.trendbars[key]--
+			delete(manager.trendbars, key)
+			manager.mu.Unlock()
+			spots.Close()
+			return nil, err
+		}
+	}
+	manager.mu.Unlock()
+
+	return &Subscription{release: func() error {
+		manager.mu.Lock()
+		manager.trendbars[key]--
+		var err error
+		if manager.trendbars[key] <= 0 {
+			delete(manager.trendbars, key)
+			err = manager.unsubscribeLiveTrendbar(symbolId, period)
+		}
+		manager.mu.Unlock()
+
+		if spotErr := spots.Close(); err == nil {
+			err = spotErr
+		}
+		return err
+	}}, nil
+}
+
+// SpotCount is the number of open spot subscriptions of symbolId.
+func (manager *SubscriptionManager) SpotCount(symbolId int64) int {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+	return manager.spots[symbolId]
+}
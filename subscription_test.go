@@ -0,0 +1,61 @@
+package ctrader
+
+import (
+	"errors"
+	"fmt"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ty2/ctrader-go/proto/openapi"
+	"testing"
+)
+
+func TestSubscriptionManager(t *testing.T) {
+	Convey("SubscriptionManager", t, func() {
+		manager := newSubscriptionManager(nil)
+		var calls []string
+		record := func(name string) func([]int64) error {
+			return func(ids []int64) error {
+				calls = append(calls, fmt.Sprint(name, ids))
+				return nil
+			}
+		}
+		manager.subscribeSpots, manager.unsubscribeSpots = record("sub"), record("unsub")
+		manager.subscribeLiveTrendbar = func(symbolId int64, period openapi.ProtoOATrendbarPeriod) error {
+			calls = append(calls, "bars "+period.String())
+			return nil
+		}
+		manager.unsubscribeLiveTrendbar = func(symbolId int64, period openapi.ProtoOATrendbarPeriod) error {
+			calls = append(calls, "unbars "+period.String())
+			return nil
+		}
+
+		Convey("only crosses zero on the wire", func() {
+			a, err := manager.Spots(1, 2)
+			So(err, ShouldBeNil)
+			b, err := manager.Spots(2, 3, 3)
+			So(err, ShouldBeNil)
+			So(calls, ShouldResemble, []string{"sub[1 2]", "sub[3]"})
+			So(manager.SpotCount(2), ShouldEqual, 2)
+
+			So(a.Close(), ShouldBeNil)
+			So(a.Close(), ShouldBeNil)
+			So(calls[2:], ShouldResemble, []string{"unsub[1]"})
+			So(b.Close(), ShouldBeNil)
+			So(calls[3:], ShouldResemble, []string{"unsub[2 3]"})
+		})
+
+		Convey("rolls back counts when the request fails", func() {
+			manager.subscribeSpots = func([]int64) error { return errors.New("boom") }
+			_, err := manager.Spots(1)
+			So(err, ShouldNotBeNil)
+			So(manager.SpotCount(1), ShouldEqual, 0)
+		})
+
+		Convey("live trend bars hold the spot subscription", func() {
+			bars, err := manager.LiveTrendbar(1, openapi.ProtoOATrendbarPeriod_M5)
+			So(err, ShouldBeNil)
+			So(calls, ShouldResemble, []string{"sub[1]", "bars M5"})
+			So(bars.Close(), ShouldBeNil)
+			So(calls[2:], ShouldResemble, []string{"unbars M5", "unsub[1]"})
+		})
+	})
+}
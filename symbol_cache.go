@@ -0,0 +1,375 @@
+package ctrader
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ty2/ctrader-go/proto/openapi"
+	"github.com/vmware/transport-go/bus"
+	"github.com/vmware/transport-go/model"
+	"google.golang.org/protobuf/encoding/protojson"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SymbolCache keeps the light symbol list and full symbols of an account. Both are loaded lazily
+// and full symbols are refreshed when a PROTO_OA_SYMBOL_CHANGED_EVENT arrives.
+type SymbolCache struct {
+	account       *Account
+	changeHandler bus.MessageHandler
+
+	mu     sync.RWMutex
+	list   *openapi.ProtoOASymbolsListRes
+	light  map[int64]*openapi.ProtoOALightSymbol
+	byName map[string]int64
+	full   map[int64]*openapi.ProtoOASymbol
+}
+
+// Cached messages may lack required proto2 fields, so the file is written and read with AllowPartial.
+var (
+	symbolCacheMarshal   = protojson.MarshalOptions{AllowPartial: true}
+	symbolCacheUnmarshal = protojson.UnmarshalOptions{AllowPartial: true}
+)
+
+type symbolCacheFile struct {
+	SavedAt time.Time       `json:"savedAt"`
+	List    json.RawMessage `json:"list,omitempty"`
+	Symbols json.RawMessage `json:"symbols,omitempty"`
+}
+
+func newSymbolCache(account *Account) (*SymbolCache, error) {
+	cache := &SymbolCache{
+		account: account,
+		full:    map[int64]*openapi.ProtoOASymbol{},
+	}
+
+	changeHandler, err := account.OnSymbolChange()
+	if err != nil {
+		return nil, err
+	}
+
+	changeHandler.Handle(
+		func(msg *model.Message) {
+			if v, ok := msg.Payload.(*openapi.ProtoOASymbolChangedEvent); ok {
+				if err := cache.onSymbolChanged(v.SymbolId); err != nil {
+					logger.Warn(fmt.Sprintf("refresh changed symbols %v: %s", v.SymbolId, err))
+				}
+			}
+		},
+		func(err error) {
+			logger.Warn(err.Error())
+		})
+	cache.changeHandler = changeHandler
+
+	return cache, nil
+}
+
+func (account *Account) Symbols() *SymbolCache {
+	return account.symbols
+}
+
+func normalizeSymbolName(name string) string {
+	return strings.ToUpper(strings.ReplaceAll(name, "/", ""))
+}
+
+// onSymbolChanged fetches the changed full symbols that were cached. The light list is only dropped,
+// to be loaded again on next use, when a symbol is new to it.
+func (cache *SymbolCache) onSymbolChanged(ids []int64) error {
+	cache.mu.Lock()
+	var cached []int64
+	for _, id := range ids {
+		if _, ok := cache.full[id]; ok {
+			cached = append(cached, id)
+		}
+		delete(cache.full, id)
+		if _, ok := cache.light[id]; cache.list != nil && !ok {
+			cache.list = nil
+		}
+	}
+	cache.mu.Unlock()
+
+	if len(cached) > 0 {
+		_, err := cache.FullSymbols(cached)
+		return err
+	}
+
+	return nil
+}
+
+func (cache *SymbolCache) setList(list *openapi.ProtoOASymbolsListRes) {
+	light := make(map[int64]*openapi.ProtoOALightSymbol, len(list.Symbol))
+	byName := make(map[string]int64, len(list.Symbol))
+	for _, symbol := range list.Symbol {
+		light[symbol.GetSymbolId()] = symbol
+		byName[normalizeSymbolName(symbol.GetSymbolName())] = symbol.GetSymbolId()
+	}
+
+	cache.list = list
+	cache.light = light
+	cache.byName = byName
+}
+
+func (cache *SymbolCache) loadList() (*openapi.ProtoOASymbolsListRes, error) {
+	cache.mu.RLock()
+	list := cache.list
+	cache.mu.RUnlock()
+	if list != nil {
+		return list, nil
+	}
+
+	list, err := cache.account.SymbolList()
+	if err != nil {
+		return nil, err
+	}
+
+	cache.mu.Lock()
+	cache.setList(list)
+	cache.mu.Unlock()
+
+	return list, nil
+}
+
+func (cache *SymbolCache) LightSymbols() ([]*openapi.ProtoOALightSymbol, error) {
+	list, err := cache.loadList()
+	if err != nil {
+		return nil, err
+	}
+
+	return list.Symbol, nil
+}
+
+func (cache *SymbolCache) ArchivedSymbols() ([]*openapi.ProtoOAArchivedSymbol, error) {
+	list, err := cache.loadList()
+	if err != nil {
+		return nil, err
+	}
+
+	return list.ArchivedSymbol, nil
+}
+
+func (cache *SymbolCache) Light(symbolId int64) (*openapi.ProtoOALightSymbol, error) {
+	if _, err := cache.loadList(); err != nil {
+		return nil, err
+	}
+
+	cache.mu.RLock()
+	defer cache.mu.RUnlock()
+	symbol, ok := cache.light[symbolId]
+	if !ok {
+		return nil, fmt.Errorf("symbol %d not found", symbolId)
+	}
+
+	return symbol, nil
+}
+
+// ByName finds a symbol by name, ignoring case and "/" (EURUSD matches EUR/USD).
+func (cache *SymbolCache) ByName(name string) (*openapi.ProtoOALightSymbol, error) {
+	if _, err := cache.loadList(); err != nil {
+		return nil, err
+	}
+
+	cache.mu.RLock()
+	defer cache.mu.RUnlock()
+	id, ok := cache.byName[normalizeSymbolName(name)]
+	if !ok {
+		return nil, fmt.Errorf("symbol %q not found", name)
+	}
+
+	return cache.light[id], nil
+}
+
+// Resolve accepts a symbol id or name.
+func (cache *SymbolCache) Resolve(nameOrId string) (int64, error) {
+	if id, err := strconv.ParseInt(nameOrId, 10, 64); err == nil {
+		return id, nil
+	}
+
+	symbol, err := cache.ByName(nameOrId)
+	if err != nil {
+		return 0, err
+	}
+
+	return symbol.GetSymbolId(), nil
+}
+
+func (cache *SymbolCache) Full(symbolId int64) (*openapi.ProtoOASymbol, error) {
+	symbols, err := cache.FullSymbols([]int64{symbolId})
+	if err != nil {
+		return nil, err
+	}
+
+	return symbols[0], nil
+}
+
+// FullSymbols returns full symbols in the order of ids, fetching the missing ones in one request.
+func (cache *SymbolCache) FullSymbols(ids []int64) ([]*openapi.ProtoOASymbol, error) {
+	var missing []int64
+	cache.mu.RLock()
+	for _, id := range ids {
+		if _, ok := cache.full[id]; !ok {
+			missing = append(missing, id)
+		}
+	}
+	cache.mu.RUnlock()
+
+	if len(missing) > 0 {
+		res, err := cache.account.SymbolById(missing)
+		if err != nil {
+			return nil, err
+		}
+
+		cache.mu.Lock()
+		for _, symbol := range res.Symbol {
+			cache.full[symbol.GetSymbolId()] = symbol
+		}
+		cache.mu.Unlock()
+	}
+
+	cache.mu.RLock()
+	defer cache.mu.RUnlock()
+	symbols := make([]*openapi.ProtoOASymbol, len(ids))
+	for i, id := range ids {
+		symbol, ok := cache.full[id]
+		if !ok {
+			return nil, fmt.Errorf("symbol %d not found", id)
+		}
+		symbols[i] = symbol
+	}
+
+	return symbols, nil
+}
+
+func (cache *SymbolCache) Info(symbolId int64) (*SymbolInfo, error) {
+	symbol, err := cache.Full(symbolId)
+	if err != nil {
+		return nil, err
+	}
+
+	light, err := cache.Light(symbolId)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewSymbolInfo(symbol, light), nil
+}
+
+// CachedInfo is Info without loading: it reports false unless both symbols are cached.
+func (cache *SymbolCache) CachedInfo(symbolId int64) (*SymbolInfo, bool) {
+	cache.mu.RLock()
+	defer cache.mu.RUnlock()
+	symbol, ok := cache.full[symbolId]
+	if !ok {
+		return nil, false
+	}
+	light, ok := cache.light[symbolId]
+	if !ok {
+		return nil, false
+	}
+
+	return NewSymbolInfo(symbol, light), true
+}
+
+func (cache *SymbolCache) InfoByName(name string) (*SymbolInfo, error) {
+	light, err := cache.ByName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return cache.Info(light.GetSymbolId())
+}
+
+// Invalidate drops the given full symbols, or everything when no ids are given.
+func (cache *SymbolCache) Invalidate(ids ...int64) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if len(ids) == 0 {
+		cache.list = nil
+		cache.full = map[int64]*openapi.ProtoOASymbol{}
+		return
+	}
+
+	for _, id := range ids {
+		delete(cache.full, id)
+	}
+}
+
+// SaveFile writes the cached symbols to path so that a later run can LoadFile them.
+func (cache *SymbolCache) SaveFile(path string) error {
+	cache.mu.RLock()
+	file := symbolCacheFile{SavedAt: time.Now().UTC()}
+	var err error
+	if cache.list != nil {
+		if file.List, err = symbolCacheMarshal.Marshal(cache.list); err != nil {
+			cache.mu.RUnlock()
+			return err
+		}
+	}
+
+	symbols := &openapi.ProtoOASymbolByIdRes{CtidTraderAccountId: &cache.account.id}
+	for _, symbol := range cache.full {
+		symbols.Symbol = append(symbols.Symbol, symbol)
+	}
+	cache.mu.RUnlock()
+
+	if file.Symbols, err = symbolCacheMarshal.Marshal(symbols); err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(file)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, b, 0644)
+}
+
+// LoadFile fills the cache from a file written by SaveFile. Files older than maxAge are ignored
+// (a zero maxAge accepts any age); changes broadcast while offline are not reflected in the file.
+func (cache *SymbolCache) LoadFile(path string, maxAge time.Duration) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var file symbolCacheFile
+	if err := json.Unmarshal(b, &file); err != nil {
+		return err
+	}
+
+	if maxAge > 0 && time.Since(file.SavedAt) > maxAge {
+		return nil
+	}
+
+	var list *openapi.ProtoOASymbolsListRes
+	if len(file.List) > 0 {
+		list = &openapi.ProtoOASymbolsListRes{}
+		if err := symbolCacheUnmarshal.Unmarshal(file.List, list); err != nil {
+			return err
+		}
+	}
+
+	symbols := &openapi.ProtoOASymbolByIdRes{}
+	if len(file.Symbols) > 0 {
+		if err := symbolCacheUnmarshal.Unmarshal(file.Symbols, symbols); err != nil {
+			return err
+		}
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if list != nil {
+		cache.setList(list)
+	}
+	for _, symbol := range symbols.Symbol {
+		cache.full[symbol.GetSymbolId()] = symbol
+	}
+
+	return nil
+}
+
+func (cache *SymbolCache) Close() {
+	cache.changeHandler.Close()
+}
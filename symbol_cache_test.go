@@ -0,0 +1,128 @@
+package ctrader
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ty2/ctrader-go/proto/openapi"
+	"github.com/vmware/transport-go/bus"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// newTestAccount builds an account that is not connected to a client; events are injected with publish.
+func newTestAccount(id int64) *Account {
+	account := &Account{id: id, eventBus: bus.NewEventBusInstance()}
+	cm := account.eventBus.GetChannelManager()
+	for _, v := range openapi.ProtoOAPayloadType_value {
+		cm.CreateChannel(strconv.Itoa(int(v)))
+	}
+
+	symbols, err := newSymbolCache(account)
+	if err != nil {
+		panic(err)
+	}
+	account.symbols = symbols
+
+	quotes, err := newAccountQuoteCache(account)
+	if err != nil {
+		panic(err)
+	}
+	account.quotes = quotes
+	account.subscriptions = newSubscriptionManager(account)
+	account.risk = newAccountRiskManager(account)
+
+	return account
+}
+
+func (account *Account) publish(payloadType openapi.ProtoOAPayloadType, payload interface{}) {
+	if err := account.eventBus.SendBroadcastMessage(strconv.Itoa(int(payloadType)), payload); err != nil {
+		panic(err)
+	}
+}
+
+func TestSymbolCache(t *testing.T) {
+	Convey("SymbolCache", t, func() {
+		account := newTestAccount(1)
+		cache := account.Symbols()
+
+		id, name := int64(1), "EUR/USD"
+		archivedId, archivedName := int64(99), "OLD"
+		cache.mu.Lock()
+		cache.setList(&openapi.ProtoOASymbolsListRes{
+			Symbol:         []*openapi.ProtoOALightSymbol{{SymbolId: &id, SymbolName: &name}},
+			ArchivedSymbol: []*openapi.ProtoOAArchivedSymbol{{SymbolId: &archivedId, Name: &archivedName}},
+		})
+		cache.full[1] = testSymbol()
+		cache.mu.Unlock()
+
+		Convey("resolves by name and id", func() {
+			symbol, err := cache.ByName("eurusd")
+			So(err, ShouldBeNil)
+			So(symbol.GetSymbolId(), ShouldEqual, 1)
+
+			resolved, err := cache.Resolve("EURUSD")
+			So(err, ShouldBeNil)
+			So(resolved, ShouldEqual, 1)
+
+			resolved, err = cache.Resolve("42")
+			So(err, ShouldBeNil)
+			So(resolved, ShouldEqual, 42)
+
+			_, err = cache.ByName("GBPUSD")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("serves full symbols and infos from memory", func() {
+			info, err := cache.InfoByName("EURUSD")
+			So(err, ShouldBeNil)
+			So(info.Id(), ShouldEqual, 1)
+			So(info.Name(), ShouldEqual, "EUR/USD")
+		})
+
+		Convey("exposes archived symbols", func() {
+			archived, err := cache.ArchivedSymbols()
+			So(err, ShouldBeNil)
+			So(archived, ShouldHaveLength, 1)
+		})
+
+		Convey("refreshes only the changed symbols", func() {
+			// nothing is fetched for symbols that were not cached, the account has no client
+			cache.Invalidate(1)
+			So(cache.onSymbolChanged([]int64{1, 2}), ShouldBeNil)
+			So(cache.list, ShouldBeNil)
+
+			cache.mu.Lock()
+			cache.setList(&openapi.ProtoOASymbolsListRes{Symbol: []*openapi.ProtoOALightSymbol{{SymbolId: &id, SymbolName: &name}}})
+			cache.mu.Unlock()
+			So(cache.onSymbolChanged([]int64{1}), ShouldBeNil)
+			So(cache.list, ShouldNotBeNil)
+		})
+
+		Convey("stops listening when the account closes", func() {
+			account.Close()
+			account.publish(openapi.ProtoOAPayloadType_PROTO_OA_SYMBOL_CHANGED_EVENT, &openapi.ProtoOASymbolChangedEvent{SymbolId: []int64{1}})
+			account.publish(openapi.ProtoOAPayloadType_PROTO_OA_SPOT_EVENT, spotEvent(1, 100000, 100010))
+			time.Sleep(10 * time.Millisecond)
+
+			_, ok := cache.CachedInfo(1)
+			So(ok, ShouldBeTrue)
+			_, ok = account.Quotes().Last(1)
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("persists to disk", func() {
+			path := filepath.Join(t.TempDir(), "symbols.json")
+			So(cache.SaveFile(path), ShouldBeNil)
+
+			other := newTestAccount(1).Symbols()
+			So(other.LoadFile(path, 0), ShouldBeNil)
+			symbol, err := other.Full(1)
+			So(err, ShouldBeNil)
+			So(symbol.GetLotSize(), ShouldEqual, testSymbol().GetLotSize())
+			light, err := other.ByName("EURUSD")
+			So(err, ShouldBeNil)
+			So(light.GetSymbolId(), ShouldEqual, 1)
+		})
+	})
+}
@@ -0,0 +1,212 @@
+package ctrader
+
+import (
+	"errors"
+	"github.com/shopspring/decimal"
+	"github.com/ty2/ctrader-go/proto/openapi"
+	"time"
+)
+
+// PriceScale is the exponent of raw Open API prices (spots, trend bars, relative SL/TP): 1.23 -> 123000.
+const PriceScale = 5
+
+// SymbolInfo wraps a full ProtoOASymbol with unit conversions. Volumes are in cents of a unit,
+// as on the wire; prices, pips and lots are exact decimals.
+type SymbolInfo struct {
+	symbol *openapi.ProtoOASymbol
+	light  *openapi.ProtoOALightSymbol
+}
+
+func NewSymbolInfo(symbol *openapi.ProtoOASymbol, light *openapi.ProtoOALightSymbol) *SymbolInfo {
+	return &SymbolInfo{symbol: symbol, light: light}
+}
+
+// SymbolInfo returns the symbol from the account symbol cache.
+func (account *Account) SymbolInfo(symbolId int64) (*SymbolInfo, error) {
+	return account.symbols.Info(symbolId)
+}
+
+func (info *SymbolInfo) Id() int64 {
+	return info.symbol.GetSymbolId()
+}
+
+// Name is empty unless the info was created with a light symbol.
+func (info *SymbolInfo) Name() string {
+	return info.light.GetSymbolName()
+}
+
+func (info *SymbolInfo) Symbol() *openapi.ProtoOASymbol {
+	return info.symbol
+}
+
+func (info *SymbolInfo) LightSymbol() *openapi.ProtoOALightSymbol {
+	return info.light
+}
+
+func (info *SymbolInfo) Digits() int32 {
+	return info.symbol.GetDigits()
+}
+
+func (info *SymbolInfo) PipPosition() int32 {
+	return info.symbol.GetPipPosition()
+}
+
+// PipSize is the price value of one pip, e.g. 0.0001 for EURUSD.
+func (info *SymbolInfo) PipSize() decimal.Decimal {
+	return decimal.New(1, -info.PipPosition())
+}
+
+func (info *SymbolInfo) RawToPrice(raw int64) decimal.Decimal {
+	return decimal.New(raw, -PriceScale)
+}
+
+func (info *SymbolInfo) PriceToRaw(price decimal.Decimal) int64 {
+	return price.Shift(PriceScale).Round(0).IntPart()
+}
+
+// RoundPrice rounds price to the symbol digits.
+func (info *SymbolInfo) RoundPrice(price decimal.Decimal) decimal.Decimal {
+	return price.Round(info.Digits())
+}
+
+func (info *SymbolInfo) PipsToPrice(pips decimal.Decimal) decimal.Decimal {
+	return pips.Mul(info.PipSize())
+}
+
+func (info *SymbolInfo) PriceToPips(price decimal.Decimal) decimal.Decimal {
+	return price.Shift(info.PipPosition())
+}
+
+// PipsToRelative converts a pip distance to the raw relative distance used by RelativeStopLoss/RelativeTakeProfit.
+func (info *SymbolInfo) PipsToRelative(pips decimal.Decimal) int64 {
+	return info.PriceToRaw(info.PipsToPrice(pips))
+}
+
+// LotsToVolume converts lots to volume in cents, rounded to the nearest cent.
+func (info *SymbolInfo) LotsToVolume(lots decimal.Decimal) int64 {
+	return lots.Mul(decimal.NewFromInt(info.symbol.GetLotSize())).Round(0).IntPart()
+}
+
+func (info *SymbolInfo) VolumeToLots(volume int64) decimal.Decimal {
+	if info.symbol.GetLotSize() == 0 {
+		return decimal.Zero
+	}
+
+	return decimal.NewFromInt(volume).Div(decimal.NewFromInt(info.symbol.GetLotSize()))
+}
+
+// VolumeToUnits converts volume in cents to units of the base asset.
+func (info *SymbolInfo) VolumeToUnits(volume int64) decimal.Decimal {
+	return decimal.New(volume, -2)
+}
+
+func (info *SymbolInfo) UnitsToVolume(units decimal.Decimal) int64 {
+	return units.Shift(2).Round(0).IntPart()
+}
+
+// RoundVolume rounds volume down to a multiple of the volume step and clamps it to the maximum volume.
+// The result may be below the minimum volume; use ValidateVolume to check.
+func (info *SymbolInfo) RoundVolume(volume int64) int64 {
+	if step := info.symbol.GetStepVolume(); step > 0 {
+		volume -= volume % step
+	}
+
+	if max := info.symbol.GetMaxVolume(); max > 0 && volume > max {
+		volume = max
+		if step := info.symbol.GetStepVolume(); step > 0 {
+			volume -= volume % step
+		}
+	}
+
+	return volume
+}
+
+func (info *SymbolInfo) ValidateVolume(volume int64) error {
+	if volume <= 0 {
+		return errors.New("volume must be positive")
+	}
+	if min := info.symbol.GetMinVolume(); min > 0 && volume < min {
+		return errors.New("volume is below the symbol minimum")
+	}
+	if max := info.symbol.GetMaxVolume(); max > 0 && volume > max {
+		return errors.New("volume is above the symbol maximum")
+	}
+	if step := info.symbol.GetStepVolume(); step > 0 && volume%step != 0 {
+		return errors.New("volume is not a multiple of the symbol volume step")
+	}
+
+	return nil
+}
+
+// defaultMoneyDigits applies when a message does not carry moneyDigits.
+const defaultMoneyDigits = 2
+
+func moneyDigits(digits *uint32) uint32 {
+	if digits == nil {
+		return defaultMoneyDigits
+	}
+
+	return *digits
+}
+
+// MoneyToDecimal scales a money value by its moneyDigits, e.g. 12345 with 2 digits is 123.45.
+func MoneyToDecimal(amount int64, moneyDigits uint32) decimal.Decimal {
+	return decimal.New(amount, -int32(moneyDigits))
+}
+
+func DecimalToMoney(amount decimal.Decimal, moneyDigits uint32) int64 {
+	return amount.Shift(int32(moneyDigits)).Round(0).IntPart()
+}
+
+// IsOpen reports whether the symbol trades at t according to its trading mode, weekly schedule
+// and holidays. A symbol without a schedule is always open.
+func (info *SymbolInfo) IsOpen(t time.Time) (bool, error) {
+	if info.symbol.GetTradingMode() != openapi.ProtoOATradingMode_ENABLED {
+		return false, nil
+	}
+
+	for _, holiday := range info.symbol.GetHoliday() {
+		location, err := time.LoadLocation(holiday.GetScheduleTimeZone())
+		if err != nil {
+			return false, err
+		}
+
+		date := time.Unix(holiday.GetHolidayDate()*86400, 0).UTC()
+		year := date.Year()
+		if holiday.GetIsRecurring() {
+			year = t.In(location).Year()
+		}
+
+		day := time.Date(year, date.Month(), date.Day(), 0, 0, 0, 0, location)
+		from, to := day, day.AddDate(0, 0, 1)
+		if holiday.StartSecond != nil {
+			from = day.Add(time.Duration(holiday.GetStartSecond()) * time.Second)
+		}
+		if holiday.EndSecond != nil {
+			to = day.Add(time.Duration(holiday.GetEndSecond()) * time.Second)
+		}
+		if !t.Before(from) && t.Before(to) {
+			return false, nil
+		}
+	}
+
+	schedule := info.symbol.GetSchedule()
+	if len(schedule) == 0 {
+		return true, nil
+	}
+
+	location, err := time.LoadLocation(info.symbol.GetScheduleTimeZone())
+	if err != nil {
+		return false, err
+	}
+
+	local := t.In(location)
+	second := uint32(local.Weekday())*86400 + uint32(local.Hour()*3600+local.Minute()*60+local.Second())
+	for _, interval := range schedule {
+		if interval.GetStartSecond() <= second && second < interval.GetEndSecond() {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
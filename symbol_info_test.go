@@ -0,0 +1,87 @@
+package ctrader
+
+import (
+	"github.com/shopspring/decimal"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ty2/ctrader-go/proto/openapi"
+	"google.golang.org/protobuf/proto"
+	"testing"
+	"time"
+)
+
+func TestSymbolInfo(t *testing.T) {
+	Convey("SymbolInfo", t, func() {
+		info := NewSymbolInfo(testSymbol(), nil)
+
+		Convey("lots and volume", func() {
+			So(info.LotsToVolume(decimal.RequireFromString("0.01")), ShouldEqual, 100000)
+			So(info.VolumeToLots(2500000).String(), ShouldEqual, "0.25")
+			So(info.VolumeToUnits(100000).String(), ShouldEqual, "1000")
+			So(info.UnitsToVolume(decimal.RequireFromString("1000")), ShouldEqual, 100000)
+		})
+
+		Convey("pips and prices", func() {
+			So(info.PipSize().String(), ShouldEqual, "0.0001")
+			So(info.PipsToPrice(decimal.RequireFromString("12.5")).String(), ShouldEqual, "0.00125")
+			So(info.PriceToPips(decimal.RequireFromString("0.00125")).String(), ShouldEqual, "12.5")
+			So(info.PipsToRelative(decimal.NewFromInt(10)), ShouldEqual, 100)
+			So(info.RawToPrice(112345).String(), ShouldEqual, "1.12345")
+			So(info.PriceToRaw(decimal.RequireFromString("1.12345")), ShouldEqual, 112345)
+			So(info.RoundPrice(decimal.RequireFromString("1.123456")).String(), ShouldEqual, "1.12346")
+		})
+
+		Convey("volume rounding and validation", func() {
+			So(info.RoundVolume(150000), ShouldEqual, 100000)
+			So(info.RoundVolume(2000000000), ShouldEqual, 1000000000)
+			So(info.ValidateVolume(50000), ShouldNotBeNil)
+			So(info.ValidateVolume(150000), ShouldNotBeNil)
+			So(info.ValidateVolume(200000), ShouldBeNil)
+		})
+
+		Convey("money", func() {
+			So(MoneyToDecimal(-12345, 2).String(), ShouldEqual, "-123.45")
+			So(MoneyToDecimal(12345, 8).String(), ShouldEqual, "0.00012345")
+			So(DecimalToMoney(decimal.RequireFromString("123.45"), 2), ShouldEqual, 12345)
+		})
+
+		Convey("trading hours", func() {
+			symbol := testSymbol()
+			symbol.ScheduleTimeZone = proto.String("UTC")
+			// Monday to Friday, 00:00 to 22:00
+			for day := uint32(1); day <= 5; day++ {
+				symbol.Schedule = append(symbol.Schedule, &openapi.ProtoOAInterval{
+					StartSecond: proto.Uint32(day * 86400),
+					EndSecond:   proto.Uint32(day*86400 + 22*3600),
+				})
+			}
+			info := NewSymbolInfo(symbol, nil)
+
+			// 2021-06-07 is a Monday
+			monday := time.Date(2021, 6, 7, 10, 0, 0, 0, time.UTC)
+			open, err := info.IsOpen(monday)
+			So(err, ShouldBeNil)
+			So(open, ShouldBeTrue)
+
+			open, _ = info.IsOpen(monday.Add(13 * time.Hour))
+			So(open, ShouldBeFalse)
+			open, _ = info.IsOpen(monday.AddDate(0, 0, -1))
+			So(open, ShouldBeFalse)
+
+			// a recurring holiday on June 8th until noon
+			symbol.Holiday = []*openapi.ProtoOAHoliday{{
+				ScheduleTimeZone: proto.String("UTC"),
+				HolidayDate:      proto.Int64(time.Date(2000, 6, 8, 0, 0, 0, 0, time.UTC).Unix() / 86400),
+				IsRecurring:      proto.Bool(true),
+				EndSecond:        proto.Int32(12 * 3600),
+			}}
+			open, _ = info.IsOpen(monday.AddDate(0, 0, 1))
+			So(open, ShouldBeFalse)
+			open, _ = info.IsOpen(monday.AddDate(0, 0, 1).Add(3 * time.Hour))
+			So(open, ShouldBeTrue)
+
+			symbol.TradingMode = openapi.ProtoOATradingMode_CLOSE_ONLY_MODE.Enum()
+			open, _ = info.IsOpen(monday)
+			So(open, ShouldBeFalse)
+		})
+	})
+}
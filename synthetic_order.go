@@ -0,0 +1,571 @@
+package ctrader
+
+import (
+	"errors"
+	"fmt"
+	"github.com/shopspring/decimal"
+	"github.com/ty2/ctrader-go/proto/openapi"
+	"github.com/vmware/transport-go/bus"
+	"github.com/vmware/transport-go/model"
+	"sync"
+	"time"
+)
+
+const syntheticAuditChannel = "synthetic-audit"
+
+// DefaultSyntheticTickInterval is how often a SyntheticOrderEngine checks time exits between spot events.
+const DefaultSyntheticTickInterval = time.Second
+
+const (
+	// syntheticRetryDelay is the wait after a failed request, doubled on every further failure.
+	syntheticRetryDelay = time.Second
+	// syntheticMaxRetryDelay caps the wait between failed requests of a position.
+	syntheticMaxRetryDelay = 5 * time.Minute
+)
+
+type SyntheticRuleType string
+
+const (
+	BreakEvenRule           SyntheticRuleType = "break-even"
+	TrailingTakeProfitRule  SyntheticRuleType = "trailing-take-profit"
+	TimeExitRule            SyntheticRuleType = "time-exit"
+	SteppedTrailingStopRule SyntheticRuleType = "stepped-trailing-stop"
+)
+
+// SyntheticRule is a client-side exit rule of a position. Trigger, Offset, Distance and Step are
+// in pips and measured from the position price to the closing side of the quote.
+type SyntheticRule struct {
+	Type     SyntheticRuleType
+	Trigger  decimal.Decimal
+	Offset   decimal.Decimal
+	Distance decimal.Decimal
+	Step     decimal.Decimal
+	After    time.Duration
+	At       time.Time
+}
+
+// BreakEven moves the stop loss to offset pips beyond the position price once the profit reaches trigger pips.
+func BreakEven(trigger, offset decimal.Decimal) SyntheticRule {
+	return SyntheticRule{Type: BreakEvenRule, Trigger: trigger, Offset: offset}
+}
+
+// TrailingTakeProfit closes the position once the profit reached trigger pips and the price then
+// fell back distance pips from its best.
+func TrailingTakeProfit(trigger, distance decimal.Decimal) SyntheticRule {
+	return SyntheticRule{Type: TrailingTakeProfitRule, Trigger: trigger, Distance: distance}
+}
+
+// CloseAfter closes the position when it has been open for d.
+func CloseAfter(d time.Duration) SyntheticRule {
+	return SyntheticRule{Type: TimeExitRule, After: d}
+}
+
+// CloseAt closes the position at t.
+func CloseAt(t time.Time) SyntheticRule {
+	return SyntheticRule{Type: TimeExitRule, At: t}
+}
+
+// SteppedTrailingStop moves the stop loss for every step pips of profit, keeping it distance pips
+// behind the last step reached.
+func SteppedTrailingStop(step, distance decimal.Decimal) SyntheticRule {
+	return SyntheticRule{Type: SteppedTrailingStopRule, Step: step, Distance: distance}
+}
+
+func (rule SyntheticRule) validate() error {
+	switch rule.Type {
+	case BreakEvenRule:
+		if !rule.Trigger.IsPositive() || rule.Offset.IsNegative() || !rule.Offset.LessThan(rule.Trigger) {
+			return errors.New("break-even needs a positive trigger above the offset")
+		}
+	case TrailingTakeProfitRule:
+		if !rule.Trigger.IsPositive() || !rule.Distance.IsPositive() {
+			return errors.New("trailing take profit needs a positive trigger and distance")
+		}
+	case TimeExitRule:
+		if rule.After <= 0 && rule.At.IsZero() {
+			return errors.New("time exit needs a duration or a time")
+		}
+	case SteppedTrailingStopRule:
+		if !rule.Step.IsPositive() || !rule.Distance.IsPositive() {
+			return errors.New("stepped trailing stop needs a positive step and distance")
+		}
+	default:
+		return fmt.Errorf("unknown synthetic rule %q", rule.Type)
+	}
+
+	return nil
+}
+
+type SyntheticAction string
+
+const (
+	SyntheticAttached      SyntheticAction = "attached"
+	SyntheticActivated     SyntheticAction = "activated"
+	SyntheticStopLossMoved SyntheticAction = "stop-loss-moved"
+	SyntheticClosed        SyntheticAction = "closed"
+	SyntheticDetached      SyntheticAction = "detached"
+)
+
+// SyntheticAudit is published for every rule attached, detached or acting on a position. Price is
+// the closing price the rule acted on; Error is set when the request to the server failed, and
+// the rule tries again after a delay that grows with every failure.
+type SyntheticAudit struct {
+	Time       time.Time
+	PositionId int64
+	Rule       SyntheticRule
+	Action     SyntheticAction
+	Price      decimal.Decimal
+	StopLoss   decimal.Decimal
+	Volume     int64
+	Error      error
+}
+
+type syntheticRule struct {
+	SyntheticRule
+	active bool
+	best   decimal.Decimal
+	done   bool
+}
+
+type syntheticPosition struct {
+	positionId   int64
+	info         *SymbolInfo
+	rules        []*syntheticRule
+	attached     time.Time
+	stopLoss     decimal.Decimal
+	busy         bool
+	closed       bool
+	failures     int
+	retryAt      time.Time
+	subscription *Subscription
+}
+
+// syntheticStep is the request a position needs; close wins over moving the stop loss.
+type syntheticStep struct {
+	state    *syntheticPosition
+	position *openapi.ProtoOAPosition
+	rule     *syntheticRule
+	close    bool
+	price    decimal.Decimal
+	stopLoss decimal.Decimal
+}
+
+// SyntheticOrderEngine runs exit rules the server does not offer: break-even stops, trailing take
+// profits, time exits and stepped trailing stops. Rules act on spot events of the position symbol
+// and amend or close the position through the account.
+type SyntheticOrderEngine struct {
+	eventBus      bus.EventBus
+	quotes        *QuoteCache
+	position      func(positionId int64) (*openapi.ProtoOAPosition, bool)
+	symbolInfo    func(symbolId int64) (*SymbolInfo, error)
+	subscribe     func(symbolId int64) (*Subscription, error)
+	amendPosition func(req *openapi.ProtoOAAmendPositionSLTPReq) error
+	closePosition func(positionId, volume int64) error
+	now           func() time.Time
+
+	mu        sync.Mutex
+	positions map[int64]*syntheticPosition
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	handler  bus.MessageHandler
+}
+
+func newSyntheticOrderEngine(portfolio *Portfolio, quotes *QuoteCache) *SyntheticOrderEngine {
+	engine := &SyntheticOrderEngine{
+		eventBus:  bus.NewEventBusInstance(),
+		quotes:    quotes,
+		position:  portfolio.Position,
+		now:       time.Now,
+		positions: map[int64]*syntheticPosition{},
+		stop:      make(chan struct{}),
+	}
+
+	engine.eventBus.GetChannelManager().CreateChannel(syntheticAuditChannel)
+
+	return engine
+}
+
+// SyntheticOrders returns an engine over the account portfolio. Attaching a rule keeps the spot
+// events of the position symbol flowing until the position is gone or detached.
+func (account *Account) SyntheticOrders() (*SyntheticOrderEngine, error) {
+	portfolio, err := account.Portfolio()
+	if err != nil {
+		return nil, err
+	}
+
+	engine := newSyntheticOrderEngine(portfolio, account.quotes)
+	engine.symbolInfo = account.symbols.Info
+	engine.subscribe = func(symbolId int64) (*Subscription, error) {
+		return account.Subscriptions().Spots(symbolId)
+	}
+	engine.amendPosition = func(req *openapi.ProtoOAAmendPositionSLTPReq) error {
+		_, err := account.AmendOrderPositionSlip(req)
+		return err
+	}
+	engine.closePosition = func(positionId, volume int64) error {
+		_, err := account.ClosePosition(positionId, volume)
+		return err
+	}
+
+	handler, err := account.quotes.OnUpdate()
+	if err != nil {
+		return nil, err
+	}
+	handler.Handle(
+		func(msg *model.Message) {
+			if v, ok := msg.Payload.(*openapi.ProtoOASpotEvent); ok {
+				engine.ApplySpot(v)
+			}
+		},
+		func(err error) {
+			logger.Warn(err.Error())
+		})
+	engine.handler = handler
+
+	go engine.tickLoop()
+
+	return engine, nil
+}
+
+// Attach adds rules to an open position.
+func (engine *SyntheticOrderEngine) Attach(positionId int64, rules ...SyntheticRule) error {
+	if len(rules) == 0 {
+		return errors.New("no rules")
+	}
+	for _, rule := range rules {
+		if err := rule.validate(); err != nil {
+			return err
+		}
+	}
+
+	position, ok := engine.position(positionId)
+	if !ok || position.GetPositionStatus() != openapi.ProtoOAPositionStatus_POSITION_STATUS_OPEN {
+		return fmt.Errorf("position %d is not open", positionId)
+	}
+	symbolId := position.GetTradeData().GetSymbolId()
+	info, err := engine.symbolInfo(symbolId)
+	if err != nil {
+		return err
+	}
+
+	engine.mu.Lock()
+	state, ok := engine.positions[positionId]
+	if !ok {
+		state = &syntheticPosition{positionId: positionId, info: info, attached: engine.now()}
+		engine.positions[positionId] = state
+	}
+	subscribe := state.subscription == nil && engine.subscribe != nil
+	for _, rule := range rules {
+		state.rules = append(state.rules, &syntheticRule{SyntheticRule: rule})
+	}
+	engine.mu.Unlock()
+
+	if subscribe {
+		subscription, err := engine.subscribe(symbolId)
+		if err != nil {
+			logger.Warn(err.Error())
+		} else {
+			engine.mu.Lock()
+			if state.subscription == nil && engine.positions[positionId] == state {
+				state.subscription, subscription = subscription, nil
+			}
+			engine.mu.Unlock()
+			if subscription != nil {
+				subscription.Close()
+			}
+		}
+	}
+
+	for _, rule := range rules {
+		engine.publish(SyntheticAudit{Time: engine.now(), PositionId: positionId, Rule: rule, Action: SyntheticAttached})
+	}
+	return nil
+}
+
+// Detach removes all rules of a position.
+func (engine *SyntheticOrderEngine) Detach(positionId int64) {
+	engine.mu.Lock()
+	state, ok := engine.positions[positionId]
+	if ok {
+		delete(engine.positions, positionId)
+	}
+	engine.mu.Unlock()
+
+	if ok {
+		engine.detached(state)
+	}
+}
+
+func (engine *SyntheticOrderEngine) detached(state *syntheticPosition) {
+	if state.subscription != nil {
+		if err := state.subscription.Close(); err != nil {
+			logger.Warn(err.Error())
+		}
+	}
+	for _, rule := range state.rules {
+		if !rule.done {
+			engine.publish(SyntheticAudit{Time: engine.now(), PositionId: state.positionId, Rule: rule.SyntheticRule, Action: SyntheticDetached})
+		}
+	}
+}
+
+// Rules returns the rules of a position that have not acted for good yet.
+func (engine *SyntheticOrderEngine) Rules(positionId int64) []SyntheticRule {
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+
+	var rules []SyntheticRule
+	if state, ok := engine.positions[positionId]; ok {
+		for _, rule := range state.rules {
+			if !rule.done {
+				rules = append(rules, rule.SyntheticRule)
+			}
+		}
+	}
+	return rules
+}
+
+// ApplySpot runs the rules of the positions of the spot event symbol against the quote cache,
+// which must have applied the event already.
+func (engine *SyntheticOrderEngine) ApplySpot(event *openapi.ProtoOASpotEvent) {
+	engine.check(event.GetSymbolId())
+}
+
+// Tick runs the rules of all positions, so time exits act without spot events.
+func (engine *SyntheticOrderEngine) Tick() {
+	engine.check(0)
+}
+
+func (engine *SyntheticOrderEngine) tickLoop() {
+	for {
+		select {
+		case <-engine.stop:
+			return
+		case <-time.After(DefaultSyntheticTickInterval):
+		}
+		engine.Tick()
+	}
+}
+
+// check runs the rules of the positions of symbolId, or of all positions when it is zero.
+func (engine *SyntheticOrderEngine) check(symbolId int64) {
+	var steps []*syntheticStep
+	var audits []SyntheticAudit
+	var gone []*syntheticPosition
+
+	engine.mu.Lock()
+	now := engine.now()
+	for positionId, state := range engine.positions {
+		position, ok := engine.position(positionId)
+		if !ok || position.GetPositionStatus() != openapi.ProtoOAPositionStatus_POSITION_STATUS_OPEN {
+			delete(engine.positions, positionId)
+			gone = append(gone, state)
+			continue
+		}
+		if symbolId != 0 && position.GetTradeData().GetSymbolId() != symbolId || state.busy || state.closed || now.Before(state.retryAt) {
+			continue
+		}
+
+		quote, ok := engine.quotes.Last(position.GetTradeData().GetSymbolId())
+		step, stepAudits := engine.evaluate(state, position, quote.Quote, ok && !quote.Stale, now)
+		audits = append(audits, stepAudits...)
+		if step != nil {
+			state.busy = true
+			steps = append(steps, step)
+		}
+	}
+	engine.mu.Unlock()
+
+	for _, state := range gone {
+		engine.detached(state)
+	}
+	for _, audit := range audits {
+		engine.publish(audit)
+	}
+	for _, step := range steps {
+		engine.run(step)
+	}
+}
+
+// evaluate updates the rule state of a position and returns the request it needs, if any.
+func (engine *SyntheticOrderEngine) evaluate(state *syntheticPosition, position *openapi.ProtoOAPosition, quote Quote, hasQuote bool, now time.Time) (*syntheticStep, []SyntheticAudit) {
+	buy := position.GetTradeData().GetTradeSide() == openapi.ProtoOATradeSide_BUY
+	entry := decimal.NewFromFloat(position.GetPrice())
+	price, profit := quote.Ask, entry.Sub(quote.Ask)
+	if buy {
+		price, profit = quote.Bid, quote.Bid.Sub(entry)
+	}
+	profitPips := state.info.PriceToPips(profit)
+
+	// better reports whether a is a more protective stop loss than b
+	better := func(a, b decimal.Decimal) bool {
+		if b.IsZero() {
+			return true
+		}
+		if buy {
+			return a.GreaterThan(b)
+		}
+		return a.LessThan(b)
+	}
+	// stopAt is the stop loss pips beyond the entry price
+	stopAt := func(pips decimal.Decimal) decimal.Decimal {
+		if buy {
+			return state.info.RoundPrice(entry.Add(state.info.PipsToPrice(pips)))
+		}
+		return state.info.RoundPrice(entry.Sub(state.info.PipsToPrice(pips)))
+	}
+
+	stopLoss := state.stopLoss
+	if position.StopLoss != nil && better(decimal.NewFromFloat(position.GetStopLoss()), stopLoss) {
+		stopLoss = decimal.NewFromFloat(position.GetStopLoss())
+	}
+
+	var audits []SyntheticAudit
+	var step *syntheticStep
+	for _, rule := range state.rules {
+		if rule.done {
+			continue
+		}
+
+		if rule.Type == TimeExitRule {
+			deadline := rule.At
+			if deadline.IsZero() {
+				opened := state.attached
+				if position.GetTradeData().OpenTimestamp != nil {
+					opened = time.Unix(0, position.GetTradeData().GetOpenTimestamp()*int64(time.Millisecond))
+				}
+				deadline = opened.Add(rule.After)
+			}
+			if !now.Before(deadline) {
+				step = &syntheticStep{state: state, position: position, rule: rule, close: true, price: price}
+			}
+			continue
+		}
+		if !hasQuote {
+			continue
+		}
+
+		switch rule.Type {
+		case BreakEvenRule:
+			target := stopAt(rule.Offset)
+			if !stopLoss.IsZero() && !better(target, stopLoss) {
+				rule.done = true
+			} else if !profitPips.LessThan(rule.Trigger) && (step == nil || !step.close && better(target, step.stopLoss)) {
+				step = &syntheticStep{state: state, position: position, rule: rule, price: price, stopLoss: target}
+			}
+		case TrailingTakeProfitRule:
+			if !rule.active && !profitPips.LessThan(rule.Trigger) {
+				rule.active, rule.best = true, price
+				audits = append(audits, SyntheticAudit{Time: now, PositionId: state.positionId, Rule: rule.SyntheticRule, Action: SyntheticActivated, Price: price})
+			}
+			if !rule.active {
+				continue
+			}
+			if buy && price.GreaterThan(rule.best) || !buy && price.LessThan(rule.best) {
+				rule.best = price
+			}
+			if !state.info.PriceToPips(rule.best.Sub(price).Abs()).LessThan(rule.Distance) {
+				step = &syntheticStep{state: state, position: position, rule: rule, close: true, price: price}
+			}
+		case SteppedTrailingStopRule:
+			steps := profitPips.Div(rule.Step).Floor()
+			if !steps.IsPositive() {
+				continue
+			}
+			target := stopAt(steps.Mul(rule.Step).Sub(rule.Distance))
+			if better(target, stopLoss) && (step == nil || !step.close && better(target, step.stopLoss)) {
+				step = &syntheticStep{state: state, position: position, rule: rule, price: price, stopLoss: target}
+			}
+		}
+	}
+
+	// the server rejects a stop loss on the wrong side of the market
+	if step != nil && !step.close && !better(price, step.stopLoss) {
+		step = nil
+	}
+
+	return step, audits
+}
+
+func (engine *SyntheticOrderEngine) run(step *syntheticStep) {
+	positionId := step.state.positionId
+	audit := SyntheticAudit{Time: engine.now(), PositionId: positionId, Rule: step.rule.SyntheticRule, Price: step.price}
+
+	var err error
+	if step.close {
+		audit.Action = SyntheticClosed
+		audit.Volume = step.position.GetTradeData().GetVolume()
+		err = engine.closePosition(positionId, audit.Volume)
+	} else {
+		audit.Action = SyntheticStopLossMoved
+		audit.StopLoss = step.stopLoss
+		stopLoss, _ := step.stopLoss.Float64()
+		err = engine.amendPosition(&openapi.ProtoOAAmendPositionSLTPReq{
+			PositionId:            &positionId,
+			StopLoss:              &stopLoss,
+			TakeProfit:            step.position.TakeProfit,
+			GuaranteedStopLoss:    step.position.GuaranteedStopLoss,
+			TrailingStopLoss:      step.position.TrailingStopLoss,
+			StopLossTriggerMethod: step.position.StopLossTriggerMethod,
+		})
+	}
+	if err != nil {
+		audit.Error = fmt.Errorf("%s position %d: %w", step.rule.Type, positionId, err)
+	}
+
+	engine.mu.Lock()
+	step.state.busy = false
+	if err != nil {
+		delay := syntheticRetryDelay << uint(step.state.failures)
+		if delay >= syntheticMaxRetryDelay {
+			delay = syntheticMaxRetryDelay
+		} else {
+			step.state.failures++
+		}
+		step.state.retryAt = engine.now().Add(delay)
+	} else {
+		step.state.failures, step.state.retryAt = 0, time.Time{}
+		if step.close {
+			step.state.closed = true
+			step.rule.done = true
+		} else {
+			step.state.stopLoss = step.stopLoss
+			if step.rule.Type == BreakEvenRule {
+				step.rule.done = true
+			}
+		}
+	}
+	engine.mu.Unlock()
+
+	engine.publish(audit)
+}
+
+func (engine *SyntheticOrderEngine) publish(audit SyntheticAudit) {
+	if err := engine.eventBus.SendBroadcastMessage(syntheticAuditChannel, audit); err != nil {
+		logger.Warn(err.Error())
+	}
+}
+
+// OnAudit delivers a SyntheticAudit for every rule attached, detached or acting.
+func (engine *SyntheticOrderEngine) OnAudit() (bus.MessageHandler, error) {
+	return engine.eventBus.ListenFirehose(syntheticAuditChannel)
+}
+
+func (engine *SyntheticOrderEngine) Close() {
+	engine.stopOnce.Do(func() {
+		close(engine.stop)
+		if engine.handler != nil {
+			engine.handler.Close()
+		}
+
+		engine.mu.Lock()
+		positions := engine.positions
+		engine.positions = map[int64]*syntheticPosition{}
+		engine.mu.Unlock()
+
+		for _, state := range positions {
+			engine.detached(state)
+		}
+	})
+}
@@ -0,0 +1,198 @@
+package ctrader
+
+import (
+	"errors"
+	"github.com/shopspring/decimal"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ty2/ctrader-go/proto/openapi"
+	"github.com/vmware/transport-go/model"
+	"google.golang.org/protobuf/proto"
+	"testing"
+	"time"
+)
+
+func TestSyntheticOrderEngine(t *testing.T) {
+	Convey("SyntheticOrderEngine", t, func() {
+		long := testPosition(1, 100000, openapi.ProtoOAPositionStatus_POSITION_STATUS_OPEN, 1)
+		long.TakeProfit = proto.Float64(1.2)
+		short := testPosition(2, 200000, openapi.ProtoOAPositionStatus_POSITION_STATUS_OPEN, 1)
+		short.TradeData.TradeSide = openapi.ProtoOATradeSide_SELL.Enum()
+		short.TradeData.OpenTimestamp = proto.Int64(0)
+		portfolio := NewPortfolio()
+		portfolio.Seed(&openapi.ProtoOATrader{}, &openapi.ProtoOAReconcileRes{Position: []*openapi.ProtoOAPosition{long, short}})
+
+		quotes := NewQuoteCache()
+		engine := newSyntheticOrderEngine(portfolio, quotes)
+		defer engine.Close()
+		spot := func(event *openapi.ProtoOASpotEvent) {
+			quotes.Apply(event)
+			engine.ApplySpot(event)
+		}
+		now := time.Unix(0, 0)
+		engine.now = func() time.Time { return now }
+		engine.symbolInfo = func(symbolId int64) (*SymbolInfo, error) {
+			return NewSymbolInfo(testSymbol(), testLightSymbol(1, 2, 3)), nil
+		}
+		var amended []*openapi.ProtoOAAmendPositionSLTPReq
+		var amendErr error
+		engine.amendPosition = func(req *openapi.ProtoOAAmendPositionSLTPReq) error {
+			amended = append(amended, req)
+			return amendErr
+		}
+		closed := map[int64]int64{}
+		engine.closePosition = func(positionId, volume int64) error {
+			closed[positionId] += volume
+			return nil
+		}
+
+		audits := make(chan SyntheticAudit, 20)
+		handler, err := engine.OnAudit()
+		So(err, ShouldBeNil)
+		handler.Handle(func(msg *model.Message) { audits <- msg.Payload.(SyntheticAudit) }, func(err error) {})
+		defer handler.Close()
+		// waitAudit skips audits until one with action arrives
+		waitAudit := func(action SyntheticAction) SyntheticAudit {
+			for {
+				select {
+				case audit := <-audits:
+					if audit.Action == action {
+						return audit
+					}
+				case <-time.After(time.Second):
+					return SyntheticAudit{}
+				}
+			}
+		}
+
+		Convey("moves the stop loss to break-even once", func() {
+			So(engine.Attach(1, BreakEven(decimal.NewFromInt(10), decimal.NewFromInt(1))), ShouldBeNil)
+
+			spot(testSpot(1, 110050, 110060))
+			So(amended, ShouldBeEmpty)
+
+			spot(testSpot(1, 110100, 110110))
+			So(amended, ShouldHaveLength, 1)
+			So(amended[0].GetStopLoss(), ShouldEqual, 1.1001)
+			So(amended[0].GetTakeProfit(), ShouldEqual, 1.2)
+
+			audit := waitAudit(SyntheticStopLossMoved)
+			So(audit.PositionId, ShouldEqual, 1)
+			So(audit.Rule.Type, ShouldEqual, BreakEvenRule)
+			So(audit.Price.String(), ShouldEqual, "1.101")
+
+			spot(testSpot(1, 110300, 110310))
+			So(amended, ShouldHaveLength, 1)
+			So(engine.Rules(1), ShouldBeEmpty)
+		})
+
+		Convey("mirrors break-even for short positions", func() {
+			So(engine.Attach(2, BreakEven(decimal.NewFromInt(10), decimal.Zero)), ShouldBeNil)
+			spot(testSpot(1, 109890, 109900))
+			So(amended, ShouldHaveLength, 1)
+			So(amended[0].GetStopLoss(), ShouldEqual, 1.1)
+		})
+
+		Convey("trails the stop loss in steps", func() {
+			So(engine.Attach(1, SteppedTrailingStop(decimal.NewFromInt(10), decimal.NewFromInt(15))), ShouldBeNil)
+
+			spot(testSpot(1, 110100, 110110))
+			So(amended, ShouldHaveLength, 1)
+			So(amended[0].GetStopLoss(), ShouldEqual, 1.0995)
+
+			spot(testSpot(1, 110150, 110160))
+			So(amended, ShouldHaveLength, 1)
+
+			spot(testSpot(1, 110200, 110210))
+			So(amended, ShouldHaveLength, 2)
+			So(amended[1].GetStopLoss(), ShouldEqual, 1.1005)
+
+			// never moves back
+			spot(testSpot(1, 110120, 110130))
+			So(amended, ShouldHaveLength, 2)
+		})
+
+		Convey("closes when the price falls back from its best", func() {
+			So(engine.Attach(1, TrailingTakeProfit(decimal.NewFromInt(20), decimal.NewFromInt(5))), ShouldBeNil)
+
+			spot(testSpot(1, 110200, 110210))
+			So(waitAudit(SyntheticActivated).Price.String(), ShouldEqual, "1.102")
+
+			spot(testSpot(1, 110300, 110310))
+			spot(testSpot(1, 110260, 110270))
+			So(closed, ShouldBeEmpty)
+
+			spot(testSpot(1, 110250, 110260))
+			So(closed, ShouldResemble, map[int64]int64{1: 100000})
+			So(waitAudit(SyntheticClosed).Volume, ShouldEqual, 100000)
+
+			spot(testSpot(1, 110200, 110210))
+			So(closed, ShouldResemble, map[int64]int64{1: 100000})
+		})
+
+		Convey("closes on time without quotes", func() {
+			So(engine.Attach(2, CloseAfter(time.Hour)), ShouldBeNil)
+			So(engine.Attach(1, CloseAt(time.Unix(7200, 0))), ShouldBeNil)
+
+			engine.Tick()
+			So(closed, ShouldBeEmpty)
+
+			now = time.Unix(3600, 0)
+			engine.Tick()
+			So(closed, ShouldResemble, map[int64]int64{2: 200000})
+
+			now = time.Unix(7200, 0)
+			engine.Tick()
+			So(closed, ShouldResemble, map[int64]int64{1: 100000, 2: 200000})
+		})
+
+		Convey("retries failed requests with a growing delay and audits the error", func() {
+			So(engine.Attach(1, BreakEven(decimal.NewFromInt(10), decimal.Zero)), ShouldBeNil)
+			amendErr = errors.New("TRADING_DISABLED")
+			spot(testSpot(1, 110100, 110110))
+			So(waitAudit(SyntheticStopLossMoved).Error, ShouldNotBeNil)
+
+			spot(testSpot(1, 110100, 110110))
+			So(amended, ShouldHaveLength, 1)
+
+			now = now.Add(syntheticRetryDelay)
+			spot(testSpot(1, 110100, 110110))
+			So(amended, ShouldHaveLength, 2)
+
+			now = now.Add(syntheticRetryDelay)
+			spot(testSpot(1, 110100, 110110))
+			So(amended, ShouldHaveLength, 2)
+
+			amendErr = nil
+			now = now.Add(syntheticRetryDelay)
+			spot(testSpot(1, 110100, 110110))
+			So(amended, ShouldHaveLength, 3)
+			So(engine.Rules(1), ShouldBeEmpty)
+		})
+
+		Convey("detaches the rules of closed positions", func() {
+			So(engine.Attach(1, CloseAfter(time.Hour)), ShouldBeNil)
+			So(waitAudit(SyntheticAttached).Rule.After, ShouldEqual, time.Hour)
+
+			portfolio.Seed(&openapi.ProtoOATrader{}, &openapi.ProtoOAReconcileRes{Position: []*openapi.ProtoOAPosition{short}})
+			engine.Tick()
+			So(waitAudit(SyntheticDetached).PositionId, ShouldEqual, 1)
+			So(engine.Rules(1), ShouldBeEmpty)
+		})
+
+		Convey("detaches all rules on Close", func() {
+			So(engine.Attach(2, CloseAfter(time.Hour)), ShouldBeNil)
+			So(waitAudit(SyntheticAttached).PositionId, ShouldEqual, 2)
+
+			engine.Close()
+			So(waitAudit(SyntheticDetached).PositionId, ShouldEqual, 2)
+			So(engine.Rules(2), ShouldBeEmpty)
+		})
+
+		Convey("rejects invalid rules and positions", func() {
+			So(engine.Attach(1, BreakEven(decimal.Zero, decimal.Zero)), ShouldNotBeNil)
+			So(engine.Attach(1, SteppedTrailingStop(decimal.NewFromInt(10), decimal.Zero)), ShouldNotBeNil)
+			So(engine.Attach(1), ShouldNotBeNil)
+			So(engine.Attach(3, CloseAfter(time.Hour)), ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,236 @@
+package ctrader
+
+import (
+	"context"
+	"github.com/shopspring/decimal"
+	"github.com/ty2/ctrader-go/proto/openapi"
+	"sort"
+	"time"
+)
+
+// MaxTickDataRange is the widest from/to window GetTickData accepts.
+const MaxTickDataRange = 604800000 * time.Millisecond
+
+// tickWindow bounds how many ticks DownloadTicks buffers before emitting them in order.
+const tickWindow = 24 * time.Hour
+
+// Tick is one bid or ask price.
+type Tick struct {
+	SymbolId int64
+	Time     time.Time
+	Price    decimal.Decimal
+}
+
+// DecodeTickData resolves the delta encoded ticks of a response (the first tick is absolute,
+// every following one is relative to its predecessor) and returns them oldest first.
+func DecodeTickData(res *openapi.ProtoOAGetTickDataRes, symbolId int64, digits int32) []Tick {
+	ticks := make([]Tick, len(res.TickData))
+	var timestamp, price int64
+	for i, v := range res.TickData {
+		timestamp += v.GetTimestamp()
+		price += v.GetTick()
+		ticks[i] = Tick{
+			SymbolId: symbolId,
+			Time:     time.Unix(0, timestamp*int64(time.Millisecond)).UTC(),
+			Price:    rawToDecimal(price, digits),
+		}
+	}
+
+	sort.SliceStable(ticks, func(i, j int) bool {
+		return ticks[i].Time.Before(ticks[j].Time)
+	})
+	return ticks
+}
+
+// DownloadTicks streams the bid or ask ticks of [from, to] oldest first, following HasMore until
+// each window is exhausted. Channels behave as in DownloadBars.
+func (account *Account) DownloadTicks(ctx context.Context, symbolId int64, quoteType openapi.ProtoOAQuoteType, from, to time.Time) (<-chan Tick, <-chan error) {
+	ticks := make(chan Tick, 1024)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(errs)
+		defer close(ticks)
+
+		info, err := account.symbols.Info(symbolId)
+		if err != nil {
+			errs <- err
+			return
+		}
+
+		fetch := func(fromTimestamp, toTimestamp int64) ([]Tick, bool, error) {
+			var res *openapi.ProtoOAGetTickDataRes
+			err := account.historicalRequest(ctx, func() error {
+				var err error
+				res, err = account.GetTickData(symbolId, quoteType, fromTimestamp, toTimestamp)
+				return err
+			})
+			if err != nil {
+				return nil, false, err
+			}
+
+			return DecodeTickData(res, symbolId, info.Digits()), res.GetHasMore(), nil
+		}
+
+		if err := downloadTicks(ctx, fetch, tickWindow, from, to, ticks); err != nil {
+			errs <- err
+		}
+	}()
+
+	return ticks, errs
+}
+
+// DownloadQuotes downloads bid and ask ticks concurrently and merges them into quotes. A quote is
+// emitted for every tick time once both sides are known, carrying the last price of the other side.
+func (account *Account) DownloadQuotes(ctx context.Context, symbolId int64, from, to time.Time) (<-chan Quote, <-chan error) {
+	quotes := make(chan Quote, 1024)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(errs)
+		defer close(quotes)
+
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		bids, bidErrs := account.DownloadTicks(ctx, symbolId, openapi.ProtoOAQuoteType_BID, from, to)
+		asks, askErrs := account.DownloadTicks(ctx, symbolId, openapi.ProtoOAQuoteType_ASK, from, to)
+		if err := mergeTicks(ctx, bids, asks, bidErrs, askErrs, quotes); err != nil {
+			errs <- err
+		}
+	}()
+
+	return quotes, errs
+}
+
+// downloadTicks pages backwards through each window: a response with HasMore holds the newest ticks
+// of the requested range, so the next request ends at its earliest tick. That millisecond may hold
+// more ticks than the response, so the next page replaces the ones already fetched there.
+func downloadTicks(ctx context.Context, fetch func(fromTimestamp, toTimestamp int64) ([]Tick, bool, error), window time.Duration, from, to time.Time, out chan<- Tick) error {
+	if window > MaxTickDataRange {
+		window = MaxTickDataRange
+	}
+
+	for start := from; !start.After(to); start = start.Add(window) {
+		end := start.Add(window - time.Millisecond)
+		if end.After(to) {
+			end = to
+		}
+
+		var chunk []Tick
+		fromTimestamp, toTimestamp := unixMillis(start), unixMillis(end)
+		for fromTimestamp <= toTimestamp {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			ticks, hasMore, err := fetch(fromTimestamp, toTimestamp)
+			if err != nil {
+				return err
+			}
+
+			chunk = append(ticks, ticksAfter(chunk, toTimestamp)...)
+			if !hasMore || len(ticks) == 0 {
+				break
+			}
+
+			earliest := unixMillis(ticks[0].Time)
+			if earliest > toTimestamp {
+				break
+			}
+			if earliest == toTimestamp {
+				// a page within a single millisecond would be returned again
+				earliest--
+			}
+			toTimestamp = earliest
+		}
+
+		for _, tick := range chunk {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case out <- tick:
+			}
+		}
+	}
+
+	return nil
+}
+
+// ticksAfter drops the leading ticks up to timestamp from time ordered ticks.
+func ticksAfter(ticks []Tick, timestamp int64) []Tick {
+	for i, tick := range ticks {
+		if unixMillis(tick.Time) > timestamp {
+			return ticks[i:]
+		}
+	}
+
+	return nil
+}
+
+// mergeTicks merges two time ordered tick streams into quotes. When a stream ends, its error
+// channel is checked so that a failed side stops the merge instead of leaving a stale price.
+func mergeTicks(ctx context.Context, bids, asks <-chan Tick, bidErrs, askErrs <-chan error, out chan<- Quote) error {
+	var bid, ask *Tick
+	var quote Quote
+	hasBid, hasAsk := false, false
+
+	next := func(ticks <-chan Tick, errs <-chan error) (*Tick, error) {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case tick, ok := <-ticks:
+			if !ok {
+				return nil, <-errs
+			}
+			return &tick, nil
+		}
+	}
+
+	var err error
+	if bid, err = next(bids, bidErrs); err != nil {
+		return err
+	}
+	if ask, err = next(asks, askErrs); err != nil {
+		return err
+	}
+
+	for bid != nil || ask != nil {
+		var tick *Tick
+		isBid := ask == nil || (bid != nil && !bid.Time.After(ask.Time))
+		if isBid {
+			tick = bid
+			quote.Bid, hasBid = tick.Price, true
+			if bid, err = next(bids, bidErrs); err != nil {
+				return err
+			}
+		} else {
+			tick = ask
+			quote.Ask, hasAsk = tick.Price, true
+			if ask, err = next(asks, askErrs); err != nil {
+				return err
+			}
+		}
+		quote.SymbolId, quote.Time = tick.SymbolId, tick.Time
+
+		// both sides ticking in the same millisecond make a single quote
+		if isBid && ask != nil && ask.Time.Equal(tick.Time) {
+			continue
+		}
+		if !isBid && bid != nil && bid.Time.Equal(tick.Time) {
+			continue
+		}
+
+		if !hasBid || !hasAsk {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case out <- quote:
+		}
+	}
+
+	return nil
+}
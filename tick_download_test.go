@@ -0,0 +1,119 @@
+package ctrader
+
+import (
+	"context"
+	"github.com/shopspring/decimal"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/ty2/ctrader-go/proto/openapi"
+	"testing"
+	"time"
+)
+
+func testTick(millis int64, price string) Tick {
+	return Tick{SymbolId: 1, Time: time.Unix(0, millis*int64(time.Millisecond)).UTC(), Price: decimal.RequireFromString(price)}
+}
+
+func TestTickDownload(t *testing.T) {
+	Convey("DecodeTickData resolves deltas", t, func() {
+		tickData := func(timestamp, tick int64) *openapi.ProtoOATickData {
+			return &openapi.ProtoOATickData{Timestamp: &timestamp, Tick: &tick}
+		}
+		res := &openapi.ProtoOAGetTickDataRes{TickData: []*openapi.ProtoOATickData{
+			tickData(1000300, 112345), tickData(-100, -5), tickData(-200, 10),
+		}}
+
+		ticks := DecodeTickData(res, 1, 5)
+		So(ticks, ShouldHaveLength, 3)
+		So(ticks[0].Time, ShouldEqual, time.Unix(1000, 0).UTC())
+		So(ticks[0].Price.String(), ShouldEqual, "1.1235")
+		So(ticks[1].Price.String(), ShouldEqual, "1.1234")
+		So(ticks[2].Price.String(), ShouldEqual, "1.12345")
+	})
+
+	Convey("downloadTicks follows HasMore", t, func() {
+		// the fake server has a tick every second and returns the newest 50 of a range
+		fetch := func(fromTimestamp, toTimestamp int64) ([]Tick, bool, error) {
+			var ticks []Tick
+			for ts := toTimestamp - toTimestamp%1000; ts >= fromTimestamp; ts -= 1000 {
+				if len(ticks) == 50 {
+					return ticks, true, nil
+				}
+				ticks = append([]Tick{testTick(ts, "1")}, ticks...)
+			}
+			return ticks, false, nil
+		}
+
+		from := time.Unix(0, 0)
+		out := make(chan Tick, 1000)
+		So(downloadTicks(context.Background(), fetch, 2*time.Minute, from, from.Add(5*time.Minute-time.Second), out), ShouldBeNil)
+		close(out)
+
+		i := 0
+		for tick := range out {
+			So(tick.Time, ShouldEqual, from.Add(time.Duration(i)*time.Second).UTC())
+			i++
+		}
+		So(i, ShouldEqual, 300)
+	})
+
+	Convey("downloadTicks keeps every tick of a millisecond split across pages", t, func() {
+		// three ticks share each even second; pages hold the newest 4 ticks of a range
+		var all []Tick
+		for ts := int64(0); ts < 10000; ts += 2000 {
+			all = append(all, testTick(ts, "1"), testTick(ts, "2"), testTick(ts, "3"))
+		}
+		fetch := func(fromTimestamp, toTimestamp int64) ([]Tick, bool, error) {
+			var ticks []Tick
+			for _, tick := range all {
+				if ts := unixMillis(tick.Time); ts >= fromTimestamp && ts <= toTimestamp {
+					ticks = append(ticks, tick)
+				}
+			}
+			if len(ticks) > 4 {
+				return ticks[len(ticks)-4:], true, nil
+			}
+			return ticks, false, nil
+		}
+
+		from := time.Unix(0, 0)
+		out := make(chan Tick, 100)
+		So(downloadTicks(context.Background(), fetch, time.Minute, from, from.Add(10*time.Second), out), ShouldBeNil)
+		close(out)
+
+		var got []Tick
+		for tick := range out {
+			got = append(got, tick)
+		}
+		So(got, ShouldResemble, all)
+	})
+
+	Convey("mergeTicks combines both sides", t, func() {
+		bids, asks := make(chan Tick, 10), make(chan Tick, 10)
+		bidErrs, askErrs := make(chan error), make(chan error)
+		bids <- testTick(1, "1.1")
+		bids <- testTick(3, "1.2")
+		bids <- testTick(5, "1.3")
+		asks <- testTick(2, "1.15")
+		asks <- testTick(3, "1.25")
+		close(bids)
+		close(asks)
+		close(bidErrs)
+		close(askErrs)
+
+		out := make(chan Quote, 10)
+		So(mergeTicks(context.Background(), bids, asks, bidErrs, askErrs, out), ShouldBeNil)
+		close(out)
+
+		var quotes []Quote
+		for quote := range out {
+			quotes = append(quotes, quote)
+		}
+		So(quotes, ShouldHaveLength, 3)
+		So(quotes[0].Bid.String(), ShouldEqual, "1.1")
+		So(quotes[0].Ask.String(), ShouldEqual, "1.15")
+		So(quotes[1].Bid.String(), ShouldEqual, "1.2")
+		So(quotes[1].Ask.String(), ShouldEqual, "1.25")
+		So(quotes[2].Bid.String(), ShouldEqual, "1.3")
+		So(quotes[2].Time, ShouldEqual, time.Unix(0, 5*int64(time.Millisecond)).UTC())
+	})
+}